@@ -18,11 +18,14 @@ import (
 	"go.hackfix.me/disco/app/cli"
 	actx "go.hackfix.me/disco/app/context"
 	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/app/keyprovider"
 	"go.hackfix.me/disco/crypto"
 	"go.hackfix.me/disco/db"
+	"go.hackfix.me/disco/db/models"
 	"go.hackfix.me/disco/db/queries"
 	"go.hackfix.me/disco/db/store"
 	"go.hackfix.me/disco/db/store/sqlite"
+	"go.hackfix.me/disco/web/client"
 )
 
 // App is the application.
@@ -75,6 +78,12 @@ func New(name, dataDir string, opts ...Option) (*App, error) {
 // Run initializes the application environment and starts execution of the
 // application.
 func (app *App) Run(args []string) error {
+	return app.run(args, nil)
+}
+
+// run executes the command in args. If encKey is nil, it's read for commands
+// that require it.
+func (app *App) run(args []string, encKey *[32]byte) error {
 	if err := app.cli.Parse(args); err != nil {
 		return err
 	}
@@ -87,6 +96,7 @@ func (app *App) Run(args []string) error {
 	if err := app.createDataDir(app.cli.DataDir); err != nil {
 		return err
 	}
+	app.ctx.DataDir = app.cli.DataDir
 	storeDir := app.cli.DataDir
 	if app.ctx.FS.Name() == "MemoryFileSystem" {
 		// The SQLite lib will attempt to write directly with the os interface,
@@ -94,22 +104,62 @@ func (app *App) Run(args []string) error {
 		storeDir = ":memory:"
 	}
 
-	var encKey *[32]byte
-	if app.ctx.User != nil {
-		encKey = app.ctx.User.PrivateKey
+	if err := app.openDB(storeDir); err != nil {
+		return err
 	}
+
 	cmd := app.cli.Command()
+	if cmd == "unseal" {
+		return app.unseal(args)
+	}
+
+	if encKey == nil && app.ctx.User != nil {
+		encKey = app.ctx.User.PrivateKey
+	}
 	// Only read the encryption for specific commands.
-	encKeyCommands := []string{"get", "set", "ls", "serve", "invite user", "remote add"}
-	if encKey == nil && slices.Contains(encKeyCommands, cmd) {
+	encKeyCommands := []string{
+		"get", "set", "ls", "history", "rollback", "apply-batch", "serve",
+		"invite user", "remote add", "ns ls", "ns create", "ns rm", "ns rename",
+		"ns cp", "ns stats", "key rotate", "key rewrap", "passphrase add",
+		"passphrase change", "passphrase rm", "agent start", "remote seal",
+		"remote unseal", "recovery add",
+	}
+	// Commands that can be served by the agent, if one is running, instead of
+	// reading the encryption key. 'agent start' is included so that it fails
+	// early, without asking for the passphrase.
+	agentCommands := []string{
+		"get", "set", "ls", "history", "rollback", "apply-batch", "ns ls",
+		"ns create", "ns rm", "ns rename", "ns cp", "ns stats", "agent start",
+	}
+	app.ctx.AgentSocket = ""
+	if encKey == nil && app.cli.EncryptionKey == "" && slices.Contains(agentCommands, cmd) {
+		app.ctx.AgentSocket = app.findAgent()
+	}
+	keyRequired := slices.Contains(encKeyCommands, cmd)
+	if cmd == "serve" {
+		keyRequired = app.cli.Serve.KeyRequired(app.ctx)
+	}
+	// The TLS client key for accessing remote nodes is encrypted.
+	if app.cli.AdminRemote(cmd) != "" {
+		keyRequired = true
+	}
+	var readKey sqlite.KeyProvider
+	if encKey == nil && app.ctx.AgentSocket == "" && keyRequired {
 		var err error
 		encKey, err = app.readEncryptionKey()
 		if err != nil {
 			return aerrors.NewRuntimeError("invalid encryption key", err, "")
 		}
+		encKey = crypto.LockKey(encKey)
+		readKey = app.keyProvider(cmd)
 	}
 
-	if err := app.initStores(storeDir, encKey); err != nil {
+	cipher, err := crypto.ParseCipher(app.cli.Cipher)
+	if err != nil {
+		return aerrors.NewRuntimeError("invalid cipher", err, "")
+	}
+
+	if err := app.initStores(storeDir, encKey, readKey, cipher); err != nil {
 		return err
 	}
 
@@ -126,7 +176,53 @@ func (app *App) Run(args []string) error {
 	return nil
 }
 
+// unseal runs the unseal command, which only needs the database. If the key
+// shares reach the threshold, the command passed to it is run with the
+// reconstructed encryption key, along with the global flags that preceded it.
+func (app *App) unseal(args []string) error {
+	if err := app.cli.Execute(app.ctx); err != nil {
+		return err
+	}
+
+	encKey, cmdArgs := app.cli.Unseal.Unsealed()
+	if encKey == nil {
+		return nil
+	}
+
+	app.ctx.User = nil
+	globalArgs := args[:slices.Index(args, "unseal")]
+
+	return app.run(append(slices.Clip(globalArgs), cmdArgs...), encKey)
+}
+
+// findAgent returns the socket path of the agent, if it's running.
+func (app *App) findAgent() string {
+	socketPath := cli.AgentSocketPath(app.ctx.DataDir)
+	ctx, cancel := context.WithTimeout(app.ctx.Ctx, time.Second)
+	defer cancel()
+	if err := client.NewAgent(socketPath).Ping(ctx); err != nil {
+		return ""
+	}
+	app.ctx.Logger.Debug("using agent", "socket", socketPath)
+
+	return socketPath
+}
+
 func (app *App) readEncryptionKey() (*[32]byte, error) {
+	app.ctx.KeySlot = 0
+	app.ctx.KeyFile = ""
+	if app.cli.EncryptionKey == "" && app.ctx.VersionInit != "" {
+		encKey, err := app.unlockKeySlots()
+		if encKey != nil || err != nil {
+			return encKey, err
+		}
+	}
+
+	if keyprovider.IsURI(app.cli.EncryptionKey) {
+		app.ctx.KeyFile = keyprovider.Path(app.ctx, app.cli.EncryptionKey)
+		return keyprovider.ReadKey(app.ctx, app.cli.EncryptionKey)
+	}
+
 	encKey, err := crypto.DecodeKey(app.cli.EncryptionKey)
 	if err != nil {
 		// Maybe it's a file path
@@ -139,11 +235,61 @@ func (app *App) readEncryptionKey() (*[32]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		app.ctx.KeyFile = app.cli.EncryptionKey
 	}
 
 	return encKey, nil
 }
 
+// keyProvider returns a function that reads the encryption key from the key
+// provider each time the store needs it, so that the store doesn't hold it in
+// memory. It returns nil if the key wasn't read from a key provider, or if the
+// provider can't be read again.
+func (app *App) keyProvider(cmd string) sqlite.KeyProvider {
+	uri := app.cli.EncryptionKey
+	if !keyprovider.IsURI(uri) {
+		return nil
+	}
+	// The server is sandboxed, which prevents it from running commands.
+	if cmd == "serve" && strings.HasPrefix(uri, "cmd:") {
+		return nil
+	}
+
+	readKey, err := keyprovider.KeyFunc(app.ctx, uri)
+	if err != nil {
+		app.ctx.Logger.Debug("the store will keep the encryption key in memory", "error", err)
+		return nil
+	}
+
+	return readKey
+}
+
+// unlockKeySlots reads the passphrase, and returns the encryption key from the
+// first key slot it unlocks. It returns nil if no key slots exist.
+func (app *App) unlockKeySlots() (*[32]byte, error) {
+	slots, err := models.KeySlots(app.ctx.DB.NewContext(), app.ctx.DB)
+	if err != nil {
+		return nil, err
+	}
+	if len(slots) == 0 {
+		return nil, nil
+	}
+
+	passphrase, err := app.ctx.ReadPassphrase("DISCO_PASSPHRASE", "Passphrase", false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ks := range slots {
+		if encKey, err := ks.Unlock(passphrase); err == nil {
+			app.ctx.KeySlot = ks.ID
+			return encKey, nil
+		}
+	}
+
+	return nil, errors.New("invalid passphrase")
+}
+
 func (app *App) createDataDir(dir string) error {
 	err := app.ctx.FS.MkdirAll(dir, 0o700)
 	if err != nil {
@@ -153,9 +299,10 @@ func (app *App) createDataDir(dir string) error {
 	return nil
 }
 
-// TODO: Remove encryption key from here. Instead load it only when needed,
-// to minimize the amount of time it's stored in RAM.
-func (app *App) initStores(dataDir string, encKey *[32]byte) error {
+// openDB opens the main database, and brings it up to date if it was created by
+// a previous version. The encryption key isn't needed for this, and the key
+// slots it contains can be used for unlocking it.
+func (app *App) openDB(dataDir string) error {
 	var err error
 	if app.ctx.DB == nil {
 		app.ctx.DB, err = initDB(app.ctx.Ctx, dataDir)
@@ -169,13 +316,41 @@ func (app *App) initStores(dataDir string, encKey *[32]byte) error {
 		app.ctx.VersionInit = version.V
 	}
 
+	if app.ctx.VersionInit != "" {
+		if err = app.ctx.DB.Migrate(app.ctx.Logger); err != nil {
+			return aerrors.NewRuntimeError("failed migrating database", err, "")
+		}
+	}
+
+	return nil
+}
+
+// initStores opens the store, and brings the database and store up to date. If
+// readKey is set, the store reads the encryption key from it only when needed,
+// instead of holding encKey.
+func (app *App) initStores(
+	dataDir string, encKey *[32]byte, readKey sqlite.KeyProvider, cipher crypto.Cipher,
+) error {
+	var err error
 	if app.ctx.Store == nil {
-		app.ctx.Store, err = initKVStore(app.ctx.Ctx, dataDir, encKey)
+		app.ctx.Store, err = initKVStore(app.ctx.Ctx, dataDir, encKey, readKey, cipher)
 		if err != nil {
 			return err
 		}
 	}
 
+	// Bring stores created by previous versions up to date.
+	if app.ctx.VersionInit != "" {
+		if encKey != nil {
+			if err = app.ctx.DB.UpgradeKeys(encKey, app.ctx.Logger); err != nil {
+				return aerrors.NewRuntimeError("failed upgrading encryption keys", err, "")
+			}
+		}
+		if err = app.ctx.Store.Migrate(app.ctx.Logger); err != nil {
+			return aerrors.NewRuntimeError("failed migrating store", err, "")
+		}
+	}
+
 	return nil
 }
 
@@ -209,7 +384,9 @@ func initDB(ctx context.Context, dataDir string) (*db.DB, error) {
 	return d, nil
 }
 
-func initKVStore(ctx context.Context, dataDir string, encKey *[32]byte) (store.Store, error) {
+func initKVStore(
+	ctx context.Context, dataDir string, encKey *[32]byte, readKey sqlite.KeyProvider, cipher crypto.Cipher,
+) (store.Store, error) {
 	var s *sqlite.Store
 	storePath := dataDir
 	if strings.Contains(storePath, "mode=memory") {
@@ -224,8 +401,10 @@ func initKVStore(ctx context.Context, dataDir string, encKey *[32]byte) (store.S
 		storePath = filepath.Join(dataDir, "store.db")
 	}
 
-	storeOpts := []sqlite.Option{}
-	if encKey != nil {
+	storeOpts := []sqlite.Option{sqlite.WithCipher(cipher)}
+	if readKey != nil {
+		storeOpts = append(storeOpts, sqlite.WithKeyProvider(readKey))
+	} else if encKey != nil {
 		storeOpts = append(storeOpts, sqlite.WithEncryptionKey(encKey))
 	}
 
@@ -1,12 +1,31 @@
 package app
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mandelsoft/vfs/pkg/memoryfs"
+	"github.com/mandelsoft/vfs/pkg/vfs"
+	"github.com/mr-tron/base58"
 	"github.com/stretchr/testify/assert"
+
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/app/keyprovider"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/db/store/sqlite"
 )
 
 func TestAppStore(t *testing.T) {
@@ -98,87 +117,1963 @@ func TestAppStore(t *testing.T) {
 		err = app.Run("get", "missingkey")
 		h(assert.EqualError(t, err, "key 'missingkey' doesn't exist in the 'default' namespace"))
 	})
+
+	t.Run("ok/history_rollback", func(t *testing.T) {
+		err = app.Run("set", "--namespace=hist", "key", "value1")
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=hist", "key", "value2")
+		h(assert.NoError(t, err))
+
+		err = app.Run("get", "--namespace=hist", "--ver=1", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value1", app.stdout.String()))
+
+		err = app.Run("history", "--namespace=hist", "key")
+		h(assert.NoError(t, err))
+		histRx := regexp.MustCompile(`(?m)^VERSION\s+CREATED\s+AUTHOR\s*\n` +
+			`1\s+[0-9-]+ [0-9:]+\s+\S+\s*\n` +
+			`2\s+[0-9-]+ [0-9:]+\s+\S+\s*\n$`)
+		h(assert.Regexp(t, histRx, app.stdout.String()))
+
+		err = app.Run("rollback", "--namespace=hist", "key", "1")
+		h(assert.NoError(t, err))
+
+		err = app.Run("get", "--namespace=hist", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value1", app.stdout.String()))
+
+		err = app.Run("get", "--namespace=hist", "--ver=3", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value1", app.stdout.String()))
+
+		err = app.Run("get", "--namespace=hist", "--ver=4", "key")
+		h(assert.EqualError(t, err, "version 4 of key 'key' doesn't exist in the 'hist' namespace"))
+
+		err = app.Run("rollback", "--namespace=hist", "key", "4")
+		h(assert.EqualError(t, err, "version doesn't exist: key 'key', version 4"))
+
+		err = app.Run("rm", "--namespace=hist", "key")
+		h(assert.NoError(t, err))
+
+		err = app.Run("history", "--namespace=hist", "key")
+		h(assert.EqualError(t, err, "key 'key' has no history in the 'hist' namespace"))
+	})
+	t.Run("ok/ls_long", func(t *testing.T) {
+		err = app.Run("set", "--namespace=meta", "key", "testvalue")
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=meta", "--content-type=application/json", "key.json", `{"a": 1}`)
+		h(assert.NoError(t, err))
+
+		err = app.Run("ls", "--namespace=meta", "--long")
+		h(assert.NoError(t, err))
+		lsRx := regexp.MustCompile(`(?m)^KEY\s+REVISION\s+SIZE\s+TYPE\s+UPDATED\s+EXPIRES\s+AUTHOR\s*\n` +
+			`key\s+[0-9]+\s+9\s+text/plain; charset=utf-8\s+[0-9-]+ [0-9:]+\s+\S+\s*\n` +
+			`key\.json\s+[0-9]+\s+8\s+application/json\s+[0-9-]+ [0-9:]+\s+\S+\s*\n$`)
+		h(assert.Regexp(t, lsRx, app.stdout.String()))
+	})
+
+	t.Run("ok/ttl", func(t *testing.T) {
+		err = app.Run("set", "--namespace=ttl", "--ttl=1h", "key", "testvalue")
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=ttl", "--ttl=1ms", "shortkey", "testvalue")
+		h(assert.NoError(t, err))
+
+		time.Sleep(5 * time.Millisecond)
+
+		err = app.Run("get", "--namespace=ttl", "shortkey")
+		h(assert.EqualError(t, err, "key 'shortkey' doesn't exist in the 'ttl' namespace"))
+
+		err = app.Run("ls", "--namespace=ttl", "--long")
+		h(assert.NoError(t, err))
+		lsRx := regexp.MustCompile(`(?m)^KEY\s+REVISION\s+SIZE\s+TYPE\s+UPDATED\s+EXPIRES\s+AUTHOR\s*\n` +
+			`key\s+[0-9]+\s+9\s+text/plain; charset=utf-8\s+[0-9-]+ [0-9:]+\s+(59m59s|1h0m0s)\s+\S+\s*\n$`)
+		h(assert.Regexp(t, lsRx, app.stdout.String()))
+
+		n, err := app.ctx.Store.DeleteExpired()
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, 1, n))
+
+		err = app.Run("set", "--namespace=ttl", "shortkey", "newvalue")
+		h(assert.NoError(t, err))
+
+		err = app.Run("history", "--namespace=ttl", "shortkey")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^1\s+`, app.stdout.String()))
+		h(assert.NotRegexp(t, `(?m)^2\s+`, app.stdout.String()))
+	})
+
+	t.Run("ok/cas", func(t *testing.T) {
+		revision := func(key string) int {
+			meta, err := app.ctx.Store.Stat("cas", key)
+			h(assert.NoError(t, err))
+			h(assert.NotNil(t, meta))
+			return meta.Revision
+		}
+
+		err = app.Run("set", "--namespace=cas", "--create-only", "key", "value1")
+		h(assert.NoError(t, err))
+		rev1 := revision("key")
+
+		err = app.Run("set", "--namespace=cas", "--create-only", "key", "value2")
+		h(assert.EqualError(t, err, "precondition failed: key 'key' already exists"))
+
+		err = app.Run("set", "--namespace=cas", fmt.Sprintf("--if-revision=%d", rev1), "key", "value2")
+		h(assert.NoError(t, err))
+		rev2 := revision("key")
+		h(assert.Greater(t, rev2, rev1))
+
+		err = app.Run("set", "--namespace=cas", fmt.Sprintf("--if-revision=%d", rev1), "key", "value3")
+		h(assert.EqualError(t, err,
+			fmt.Sprintf("precondition failed: key 'key' is at revision %d, not %d", rev2, rev1)))
+
+		err = app.Run("set", "--namespace=cas", "--if-revision=1", "missingkey", "value")
+		h(assert.EqualError(t, err, "precondition failed: key 'missingkey' doesn't exist"))
+
+		err = app.Run("get", "--namespace=cas", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value2", app.stdout.String()))
+
+		// Revisions aren't reused if the key is deleted and created again.
+		err = app.Run("rm", "--namespace=cas", "key")
+		h(assert.NoError(t, err))
+		err = app.Run("set", "--namespace=cas", "key", "value1")
+		h(assert.NoError(t, err))
+		err = app.Run("set", "--namespace=cas", "key", "value2")
+		h(assert.NoError(t, err))
+		rev3 := revision("key")
+		h(assert.NotEqual(t, rev2, rev3))
+
+		err = app.Run("set", "--namespace=cas", fmt.Sprintf("--if-revision=%d", rev2), "key", "value3")
+		h(assert.EqualError(t, err,
+			fmt.Sprintf("precondition failed: key 'key' is at revision %d, not %d", rev3, rev2)))
+
+		err = app.Run("set", "--namespace=cas", "--if-revision=1", "--create-only", "key", "value")
+		h(assert.EqualError(t, err, "--if-revision and --create-only can't be used together"))
+	})
+
+	t.Run("ok/apply_batch", func(t *testing.T) {
+		err = app.Run("set", "--namespace=batch", "db/host", "db1")
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=batch", "db/port", "5432")
+		h(assert.NoError(t, err))
+
+		meta, err := app.ctx.Store.Stat("batch", "db/host")
+		h(assert.NoError(t, err))
+
+		batch := `{"ops": [
+			{"op": "set", "namespace": "batch", "key": "db/host", "value": "db2", "if_revision": ` +
+			strconv.Itoa(meta.Revision) + `},
+			{"op": "set", "namespace": "batch", "key": "db/user", "value": "admin", "create_only": true},
+			{"op": "delete", "namespace": "batch", "key": "db/port"}
+		]}`
+		err = vfs.WriteFile(app.ctx.FS, "/batch.json", []byte(batch), 0o600)
+		h(assert.NoError(t, err))
+
+		err = app.Run("apply-batch", "/batch.json")
+		h(assert.NoError(t, err))
+
+		err = app.Run("ls", "--namespace=batch")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "db/host\ndb/user\n", app.stdout.String()))
+
+		err = app.Run("get", "--namespace=batch", "db/host")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "db2", app.stdout.String()))
+
+		// The first operation succeeds, but the second one fails, so neither
+		// should be applied.
+		meta, err = app.ctx.Store.Stat("batch", "db/user")
+		h(assert.NoError(t, err))
+		staleRev := meta.Revision - 1
+
+		batch = `{"ops": [
+			{"op": "set", "namespace": "batch", "key": "db/host", "value": "db3"},
+			{"op": "set", "namespace": "batch", "key": "db/user", "value": "root", "if_revision": ` +
+			strconv.Itoa(staleRev) + `}
+		]}`
+		err = vfs.WriteFile(app.ctx.FS, "/batch.json", []byte(batch), 0o600)
+		h(assert.NoError(t, err))
+
+		err = app.Run("apply-batch", "/batch.json")
+		h(assert.EqualError(t, err, fmt.Sprintf(
+			"operation 2: precondition failed: key 'db/user' is at revision %d, not %d", meta.Revision, staleRev)))
+
+		err = app.Run("get", "--namespace=batch", "db/host")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "db2", app.stdout.String()))
+	})
+
+	t.Run("err/swapped_value", func(t *testing.T) {
+		err = app.Run("set", "--namespace=bind", "secret", "hunter2")
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=bind", "banner", "hello")
+		h(assert.NoError(t, err))
+
+		s := app.ctx.Store.(*sqlite.Store)
+		_, err = s.Exec(`UPDATE bind SET value = (SELECT value FROM bind WHERE key = 'secret')
+			WHERE key = 'banner'`)
+		h(assert.NoError(t, err))
+
+		err = app.Run("get", "--namespace=bind", "banner")
+		h(assert.EqualError(t, err, "failed decrypting value: failed decrypting chunk"))
+	})
+
+	t.Run("ok/upgrade_encryption", func(t *testing.T) {
+		app, err := newTestApp(tctx)
+		h(assert.NoError(t, err))
+		encKey := app.ctx.User.PrivateKey
+
+		err = app.Run("init")
+		h(assert.NoError(t, err))
+
+		// Simulate a value written before values were bound to their
+		// namespace and key.
+		encValue, err := crypto.EncryptSymInMemory([]byte("oldvalue"), encKey)
+		h(assert.NoError(t, err))
+
+		err = app.Run("set", "--namespace=upgrade", "key", "placeholder")
+		h(assert.NoError(t, err))
+
+		s := app.ctx.Store.(*sqlite.Store)
+		for _, stmt := range []string{
+			`UPDATE upgrade SET value = ? WHERE key = 'key'`,
+			`UPDATE _history SET value = ? WHERE namespace = 'upgrade' AND key = 'key'`,
+		} {
+			_, err = s.Exec(stmt, encValue)
+			h(assert.NoError(t, err))
+		}
+		_, err = s.Exec(`UPDATE _meta SET encryption_version = 1`)
+		h(assert.NoError(t, err))
+
+		err = app.Run("get", "--namespace=upgrade", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "oldvalue", app.stdout.String()))
+
+		err = app.Run("get", "--namespace=upgrade", "--ver=1", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "oldvalue", app.stdout.String()))
+	})
 }
 
-// Test the scenario of 2 Disco nodes, where one creates a user and invitation
-// token, and the other joins and reads a remote key over the network.
-func TestAppUserInviteJoin(t *testing.T) {
+func TestAppStoreWildcard(t *testing.T) {
 	t.Parallel()
 
-	// wg.Wait must be deferred before the test context cancellation (so that
-	// it's called after it when the function returns) to avoid waiting for the
-	// context timeout to be reached.
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
 
-	timeout := 5 * time.Second
-	tctx, cancel, h := newTestContext(t, timeout)
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init")
+	h(assert.NoError(t, err))
+
+	err = app.Run("set", "--namespace=*", "key", "testvalue")
+	h(assert.EqualError(t, err, "no namespaces exist"))
+
+	err = app.Run("set", "--namespace=prod", "key", "prodvalue")
+	h(assert.NoError(t, err))
+
+	err = app.Run("set", "--namespace=dev", "otherkey", "devvalue")
+	h(assert.NoError(t, err))
+
+	err = app.Run("get", "--namespace=*", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "prod\x00prodvalue\x00", app.stdout.String()))
+
+	err = app.Run("set", "--namespace=*", "key", "testvalue")
+	h(assert.NoError(t, err))
+
+	err = app.Run("get", "--namespace=*", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "dev\x00testvalue\x00prod\x00testvalue\x00", app.stdout.String()))
+
+	err = app.Run("get", "--namespace=*", "--json", "key")
+	h(assert.NoError(t, err))
+	h(assert.JSONEq(t, `{"dev": "testvalue", "prod": "testvalue"}`, app.stdout.String()))
+
+	err = app.Run("rm", "--namespace=*", "key")
+	h(assert.NoError(t, err))
+
+	err = app.Run("get", "--namespace=*", "key")
+	h(assert.EqualError(t, err, "key 'key' doesn't exist in any namespace"))
+
+	err = app.Run("rm", "--namespace=*", "key")
+	h(assert.EqualError(t, err, "key doesn't exist: key"))
+
+	err = app.Run("get", "--namespace=dev", "otherkey")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "devvalue", app.stdout.String()))
+}
+
+func TestAppNamespace(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
 	defer cancel()
 
-	// app1 will accept remote connections
-	app1, err := newTestApp(tctx)
+	app, err := newTestApp(tctx)
 	h(assert.NoError(t, err))
 
-	err = app1.Run("init")
+	err = app.Run("init")
 	h(assert.NoError(t, err))
 
-	err = app1.Run("set", "key", "testvalue")
+	err = app.Run("ns", "create", "--description=Production", "prod")
 	h(assert.NoError(t, err))
 
-	err = app1.Run("get", "key")
+	err = app.Run("ns", "create", "prod")
+	h(assert.EqualError(t, err, "failed creating namespace 'prod': namespace already exists: prod"))
+
+	err = app.Run("ns", "create", "_internal")
+	h(assert.EqualError(t, err, "failed creating namespace '_internal': invalid namespace: '_internal'"))
+
+	err = app.Run("set", "--namespace=prod", "key", "testvalue")
 	h(assert.NoError(t, err))
-	h(assert.Equal(t, "testvalue", app1.stdout.String()))
 
-	err = app1.Run("user", "add", "newuser", "--roles=admin")
+	err = app.Run("ns", "ls")
 	h(assert.NoError(t, err))
+	lsRx := regexp.MustCompile(`(?m)^NAME\s+CREATED\s+DESCRIPTION\s*\n` +
+		`prod\s+[0-9-]+ [0-9:]+\s+Production\s*\n$`)
+	h(assert.Regexp(t, lsRx, app.stdout.String()))
 
-	err = app1.Run("invite", "user", "newuser", "--ttl=1m")
+	err = app.Run("ns", "cp", "prod", "staging")
 	h(assert.NoError(t, err))
 
-	// Extract the invite token from the output
-	tokenRx := regexp.MustCompile(`^Token: (.*)\n`)
-	match := tokenRx.FindStringSubmatch(app1.stdout.String())
-	h(assert.Lenf(t, match, 2, "token not found in output:\n%s", app1.stdout.String()))
+	err = app.Run("ns", "rename", "staging", "dev")
+	h(assert.NoError(t, err))
 
-	token := match[1]
+	err = app.Run("get", "--namespace=dev", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "testvalue", app.stdout.String()))
 
-	// Start the web server on app1
-	addrCh := make(chan string)
-	app1.stderr.waitFor(`started web server.*address=(.*)\n`, 1, addrCh)
+	err = app.Run("history", "--namespace=dev", "key")
+	h(assert.NoError(t, err))
+	h(assert.Regexp(t, `(?m)^1\s+`, app.stdout.String()))
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err = app1.Run("serve", "--address=:0")
-		h(assert.NoError(t, err))
-	}()
+	err = app.Run("ns", "stats")
+	h(assert.NoError(t, err))
+	statsRx := regexp.MustCompile(`(?m)^NAME\s+KEYS\s+SIZE\s*\n` +
+		`dev\s+1\s+\d+\s*\n` +
+		`prod\s+1\s+\d+\s*\n$`)
+	h(assert.Regexp(t, statsRx, app.stdout.String()))
 
-	var srvAddress string
-	select {
-	case srvAddress = <-addrCh:
-	case <-tctx.Done():
-		t.Fatalf("timed out after %s", timeout)
-	}
+	err = app.Run("ns", "rm", "dev")
+	h(assert.EqualError(t, err, "failed removing namespace 'dev': namespace is not empty: dev "+
+		"(remove all keys first or pass --force to delete anyway)"))
 
-	// app2 is the remote client that will join app1 with the generated token
-	app2, err := newTestApp(tctx)
+	err = app.Run("ns", "rm", "--force", "dev")
 	h(assert.NoError(t, err))
 
-	err = app2.Run("init")
+	err = app.Run("ns", "stats", "dev")
+	h(assert.EqualError(t, err, "failed reading statistics of namespace 'dev': namespace doesn't exist: dev"))
+
+	err = app.Run("ls", "--namespace=*")
 	h(assert.NoError(t, err))
+	h(assert.Equal(t, "NAMESPACE   KEY \nprod        key   \n", app.stdout.String()))
+}
 
-	err = app2.Run("remote", "add", "testremote", srvAddress, token)
+func TestAppKeyRotate(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
 	h(assert.NoError(t, err))
 
-	// The key doesn't exist for app2 locally...
-	err = app2.Run("get", "key")
-	h(assert.EqualError(t, err, "key 'key' doesn't exist in the 'default' namespace"))
-	h(assert.Equal(t, "", app2.stdout.String()))
+	err = app.Run("init")
+	h(assert.NoError(t, err))
+	oldKey := app.ctx.User.PrivateKey
 
-	// ... but it does exist in the remote node.
-	err = app2.Run("get", "--remote=testremote", "key")
+	err = app.Run("set", "key", "value1")
 	h(assert.NoError(t, err))
-	h(assert.Equal(t, "testvalue", app2.stdout.String()))
+
+	err = app.Run("set", "key", "value2")
+	h(assert.NoError(t, err))
+
+	err = app.Run("set", "--namespace=prod", "key", "prodvalue")
+	h(assert.NoError(t, err))
+
+	err = app.Run("user", "add", "newuser", "--roles=user")
+	h(assert.NoError(t, err))
+
+	err = app.Run("invite", "user", "newuser", "--ttl=1m")
+	h(assert.NoError(t, err))
+
+	s := app.ctx.Store.(*sqlite.Store)
+	var encValue, encHistValue []byte
+	err = s.QueryRow(`SELECT value FROM prod WHERE key = 'key'`).Scan(&encValue)
+	h(assert.NoError(t, err))
+	err = s.QueryRow(`SELECT value FROM _history WHERE namespace = 'default' AND key = 'key' AND version = 1`).
+		Scan(&encHistValue)
+	h(assert.NoError(t, err))
+
+	err = app.Run("key", "rotate")
+	h(assert.NoError(t, err))
+
+	keyRx := regexp.MustCompile(`^New encryption key: (.*)\n`)
+	match := keyRx.FindStringSubmatch(app.stdout.String())
+	h(assert.Lenf(t, match, 2, "key not found in output:\n%s", app.stdout.String()))
+	newKey, err := crypto.DecodeKey(match[1])
+	h(assert.NoError(t, err))
+	h(assert.NotEqual(t, oldKey, newKey))
+
+	err = app.Run("get", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "value2", app.stdout.String()))
+
+	err = app.Run("get", "--ver=1", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "value1", app.stdout.String()))
+
+	err = app.Run("get", "--namespace=prod", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "prodvalue", app.stdout.String()))
+
+	// The data keys are replaced as well, so all values are re-encrypted.
+	var newEncValue []byte
+	err = s.QueryRow(`SELECT value FROM _history WHERE namespace = 'default' AND key = 'key' AND version = 1`).
+		Scan(&newEncValue)
+	h(assert.NoError(t, err))
+	h(assert.NotEqual(t, encHistValue, newEncValue))
+	err = s.QueryRow(`SELECT value FROM prod WHERE key = 'key'`).Scan(&newEncValue)
+	h(assert.NoError(t, err))
+	h(assert.NotEqual(t, encValue, newEncValue))
+
+	encValue = newEncValue
+	err = app.Run("key", "rotate", "--namespace=prod")
+	h(assert.NoError(t, err))
+	h(assert.Empty(t, app.stdout.String()))
+
+	err = s.QueryRow(`SELECT value FROM prod WHERE key = 'key'`).Scan(&newEncValue)
+	h(assert.NoError(t, err))
+	h(assert.NotEqual(t, encValue, newEncValue))
+
+	err = app.Run("key", "rewrap")
+	h(assert.NoError(t, err))
+
+	err = app.Run("get", "--namespace=prod", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "prodvalue", app.stdout.String()))
+
+	err = app.Run("key", "rotate", "--namespace=missing")
+	h(assert.EqualError(t, err, "failed rotating data key of namespace 'missing': namespace doesn't exist: missing"))
+
+	err = app.ctx.LoadLocalUser(oldKey)
+	h(assert.EqualError(t, err, "invalid encryption key: hash mismatch"))
+
+	err = app.ctx.LoadLocalUser(newKey)
+	h(assert.NoError(t, err))
+
+	_, _, _, err = app.ctx.ServerTLSInfo()
+	h(assert.NoError(t, err))
+
+	invites, err := models.Invites(app.ctx.DB.NewContext(), app.ctx.DB, nil)
+	h(assert.NoError(t, err))
+	h(assert.Len(t, invites, 1))
+	_, err = invites[0].PrivateKey(newKey)
+	h(assert.NoError(t, err))
+}
+
+func TestAppKeyUpgrade(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+	storeKey := app.ctx.User.PrivateKey
+
+	err = app.Run("init")
+	h(assert.NoError(t, err))
+	encKey := app.ctx.User.PrivateKey
+
+	err = app.Run("set", "--namespace=upgrade", "key", "value")
+	h(assert.NoError(t, err))
+
+	err = app.Run("user", "add", "newuser", "--roles=user")
+	h(assert.NoError(t, err))
+
+	err = app.Run("invite", "user", "newuser", "--ttl=1m")
+	h(assert.NoError(t, err))
+
+	// Simulate a data dir created before subkeys were derived for each
+	// purpose, where everything was encrypted with the private key itself.
+	reencrypt := func(value []byte, oldKey, newKey *[32]byte, opts ...crypto.Option) []byte {
+		plaintext, err := crypto.DecryptSymInMemory(value, oldKey, opts...)
+		h(assert.NoError(t, err))
+		newValue, err := crypto.EncryptSymInMemory(plaintext, newKey, opts...)
+		h(assert.NoError(t, err))
+		return newValue
+	}
+
+	var tlsKeyEnc, invKeyEnc []byte
+	err = app.ctx.DB.QueryRow(`SELECT server_tls_key_enc FROM _meta`).Scan(&tlsKeyEnc)
+	h(assert.NoError(t, err))
+	err = app.ctx.DB.QueryRow(`SELECT privkey_enc FROM invites`).Scan(&invKeyEnc)
+	h(assert.NoError(t, err))
+	_, err = app.ctx.DB.Exec(`UPDATE _meta SET server_tls_key_enc = ?, key_version = 1`,
+		reencrypt(tlsKeyEnc, crypto.DeriveKey(encKey, crypto.KeyPurposeServerTLS), encKey))
+	h(assert.NoError(t, err))
+	_, err = app.ctx.DB.Exec(`UPDATE invites SET privkey_enc = ?`,
+		reencrypt(invKeyEnc, crypto.DeriveKey(encKey, crypto.KeyPurposeInvites), encKey))
+	h(assert.NoError(t, err))
+	_, err = app.ctx.DB.Exec(`UPDATE users SET private_key_hash = ? WHERE type = ?`,
+		base58.Encode(crypto.Hash("encryption key hash", encKey[:])), models.UserTypeLocal)
+	h(assert.NoError(t, err))
+
+	s := app.ctx.Store.(*sqlite.Store)
+	var dataKeyEnc []byte
+	err = s.QueryRow(`SELECT data_key_enc FROM _namespaces WHERE name = 'upgrade'`).Scan(&dataKeyEnc)
+	h(assert.NoError(t, err))
+	_, err = s.Exec(`UPDATE _namespaces SET data_key_enc = ? WHERE name = 'upgrade'`,
+		reencrypt(dataKeyEnc, crypto.DeriveKey(storeKey, crypto.KeyPurposeStore), storeKey,
+			crypto.WithAssociatedData([]byte("data key:upgrade"))))
+	h(assert.NoError(t, err))
+	_, err = s.Exec(`UPDATE _meta SET encryption_version = 3`)
+	h(assert.NoError(t, err))
+
+	err = app.Run("get", "--namespace=upgrade", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "value", app.stdout.String()))
+
+	var keyVersion, encVersion int
+	err = app.ctx.DB.QueryRow(`SELECT key_version FROM _meta`).Scan(&keyVersion)
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, 2, keyVersion))
+	err = s.QueryRow(`SELECT encryption_version FROM _meta`).Scan(&encVersion)
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, 4, encVersion))
+
+	err = app.ctx.LoadLocalUser(encKey)
+	h(assert.NoError(t, err))
+
+	_, _, _, err = app.ctx.ServerTLSInfo()
+	h(assert.NoError(t, err))
+
+	invites, err := models.Invites(app.ctx.DB.NewContext(), app.ctx.DB, nil)
+	h(assert.NoError(t, err))
+	h(assert.Len(t, invites, 1))
+	_, err = invites[0].PrivateKey(encKey)
+	h(assert.NoError(t, err))
+}
+
+func TestAppPassphrase(t *testing.T) {
+	t.Parallel()
+
+	// Deriving keys from passphrases is intentionally slow.
+	tctx, cancel, h := newTestContext(t, 20*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init", "--passphrase")
+	h(assert.EqualError(t, err, "failed reading passphrase: no passphrase provided; "+
+		"set DISCO_PASSPHRASE or run in a terminal"))
+	h(assert.Empty(t, app.ctx.VersionInit))
+
+	err = app.env.Set("DISCO_PASSPHRASE", "first passphrase")
+	h(assert.NoError(t, err))
+
+	err = app.Run("init", "--passphrase")
+	h(assert.NoError(t, err))
+	h(assert.Contains(t, app.stdout.String(), "unlocked with the passphrase in key slot 1"))
+	h(assert.NotContains(t, app.stdout.String(), "New encryption key"))
+
+	// A new app without the local user loaded must unlock the encryption key.
+	newApp := func(passphrase string) *testApp {
+		a, err := newTestApp(tctx,
+			WithDB(app.ctx.DB), WithStore(app.ctx.Store), WithUser(nil))
+		h(assert.NoError(t, err))
+		if passphrase != "" {
+			err = a.env.Set("DISCO_PASSPHRASE", passphrase)
+			h(assert.NoError(t, err))
+		}
+		return a
+	}
+
+	t.Run("ok/unlock", func(t *testing.T) {
+		app2 := newApp("first passphrase")
+		err = app2.Run("set", "key", "value")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, uint64(1), app2.ctx.KeySlot))
+
+		err = app2.Run("get", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app2.stdout.String()))
+	})
+
+	t.Run("err/unlock", func(t *testing.T) {
+		err = newApp("wrong passphrase").Run("get", "key")
+		h(assert.EqualError(t, err, "invalid encryption key: invalid passphrase"))
+
+		err = newApp("").Run("get", "key")
+		h(assert.EqualError(t, err, "invalid encryption key: no passphrase provided; "+
+			"set DISCO_PASSPHRASE or run in a terminal"))
+	})
+
+	t.Run("ok/add_change_rm", func(t *testing.T) {
+		app2 := newApp("first passphrase")
+		err = app2.env.Set("DISCO_NEW_PASSPHRASE", "second passphrase")
+		h(assert.NoError(t, err))
+		err = app2.Run("passphrase", "add", "--kdf=scrypt")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "Added key slot 2\n", app2.stdout.String()))
+
+		app3 := newApp("second passphrase")
+		err = app3.Run("get", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app3.stdout.String()))
+		h(assert.Equal(t, uint64(2), app3.ctx.KeySlot))
+
+		err = app3.env.Set("DISCO_NEW_PASSPHRASE", "third passphrase")
+		h(assert.NoError(t, err))
+		err = app3.Run("passphrase", "change")
+		h(assert.NoError(t, err))
+
+		err = newApp("second passphrase").Run("get", "key")
+		h(assert.EqualError(t, err, "invalid encryption key: invalid passphrase"))
+
+		err = newApp("third passphrase").Run("get", "key")
+		h(assert.NoError(t, err))
+
+		err = app3.Run("passphrase", "ls")
+		h(assert.NoError(t, err))
+		lsRx := regexp.MustCompile(`(?m)^SLOT\s+KDF\s+PARAMETERS\s+CREATED\s*\n` +
+			`1\s+argon2id\s+t=3,m=65536,p=4\s+[0-9-]+ [0-9:]+\s*\n` +
+			`2\s+scrypt\s+N=32768,r=8,p=1\s+[0-9-]+ [0-9:]+\s*\n$`)
+		h(assert.Regexp(t, lsRx, app3.stdout.String()))
+
+		app4 := newApp("first passphrase")
+		err = app4.Run("passphrase", "rm", "2")
+		h(assert.NoError(t, err))
+
+		err = app4.Run("passphrase", "rm", "1")
+		h(assert.EqualError(t, err, "can't delete the last key slot when unlocked with a passphrase"))
+	})
+}
+
+func TestAppRecovery(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init")
+	h(assert.NoError(t, err))
+	err = app.Run("set", "key", "value")
+	h(assert.NoError(t, err))
+
+	keygen := func() (privKey, pubKey string) {
+		err := app.Run("recovery", "keygen")
+		h(assert.NoError(t, err))
+		keyRx := regexp.MustCompile(`^Private key: (.*)\nPublic key: (.*)\n`)
+		match := keyRx.FindStringSubmatch(app.stdout.String())
+		h(assert.Lenf(t, match, 3, "keys not found in output:\n%s", app.stdout.String()))
+		return match[1], match[2]
+	}
+
+	alicePriv, alicePub := keygen()
+	bobPriv, bobPub := keygen()
+	otherPriv, _ := keygen()
+
+	err = app.Run("recovery", "unlock", "--private-key="+alicePriv)
+	h(assert.EqualError(t, err, "no recovery keys exist (Recovery keys must be added "+
+		"with 'disco recovery add' before they can be used.)"))
+
+	err = app.Run("recovery", "add", "alice", alicePub)
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "Added recovery key 'alice'\n", app.stdout.String()))
+
+	err = app.Run("recovery", "add", "bob", bobPub)
+	h(assert.NoError(t, err))
+
+	err = app.Run("recovery", "add", "bob", alicePub)
+	h(assert.ErrorContains(t, err, "UNIQUE constraint failed: recovery_keys.name"))
+
+	err = app.Run("recovery", "add", "invalid", "invalid")
+	h(assert.ErrorContains(t, err, "invalid public key"))
+
+	err = app.Run("recovery", "ls")
+	h(assert.NoError(t, err))
+	lsRx := regexp.MustCompile(`(?m)^NAME\s+PUBLIC KEY\s+CREATED\s*\n` +
+		`alice\s+` + alicePub + `\s+[0-9-]+ [0-9:]+\s*\n` +
+		`bob\s+` + bobPub + `\s+[0-9-]+ [0-9:]+\s*\n$`)
+	h(assert.Regexp(t, lsRx, app.stdout.String()))
+
+	// A new app without the local user loaded, and without the encryption key.
+	newApp := func() *testApp {
+		a, err := newTestApp(tctx,
+			WithDB(app.ctx.DB), WithStore(app.ctx.Store), WithUser(nil))
+		h(assert.NoError(t, err))
+		return a
+	}
+
+	unlock := func(privKey string) *[32]byte {
+		a := newApp()
+		err := a.Run("recovery", "unlock", "--private-key="+privKey)
+		h(assert.NoError(t, err))
+		keyRx := regexp.MustCompile(`^Encryption key: (.*)\n$`)
+		match := keyRx.FindStringSubmatch(a.stdout.String())
+		h(assert.Lenf(t, match, 2, "key not found in output:\n%s", a.stdout.String()))
+		encKey, err := crypto.DecodeKey(match[1])
+		h(assert.NoError(t, err))
+		return encKey
+	}
+
+	t.Run("ok/unlock", func(t *testing.T) {
+		h(assert.Equal(t, app.ctx.User.PrivateKey, unlock(alicePriv)))
+		h(assert.Equal(t, app.ctx.User.PrivateKey, unlock(bobPriv)))
+	})
+
+	t.Run("err/unlock", func(t *testing.T) {
+		err := newApp().Run("recovery", "unlock", "--private-key="+otherPriv)
+		h(assert.EqualError(t, err, "the private key doesn't unlock any recovery key"))
+
+		err = newApp().Run("recovery", "unlock", "--private-key=invalid")
+		h(assert.ErrorContains(t, err, "invalid private key"))
+
+		err = newApp().Run("recovery", "unlock")
+		h(assert.EqualError(t, err,
+			"no private key provided; pass it with --private-key or run in a terminal"))
+	})
+
+	t.Run("ok/rotate", func(t *testing.T) {
+		oldKey := app.ctx.User.PrivateKey
+		err := app.Run("key", "rotate")
+		h(assert.NoError(t, err))
+		h(assert.NotEqual(t, oldKey, app.ctx.User.PrivateKey))
+
+		// The recovery keys are wrapped again with the new key.
+		h(assert.Equal(t, app.ctx.User.PrivateKey, unlock(alicePriv)))
+
+		a := newApp()
+		err = a.Run("recovery", "unlock", "--rotate", "--private-key="+bobPriv)
+		h(assert.NoError(t, err))
+		keyRx := regexp.MustCompile(`^New encryption key: (.*)\n`)
+		match := keyRx.FindStringSubmatch(a.stdout.String())
+		h(assert.Lenf(t, match, 2, "key not found in output:\n%s", a.stdout.String()))
+		newKey, err := crypto.DecodeKey(match[1])
+		h(assert.NoError(t, err))
+		h(assert.NotEqual(t, app.ctx.User.PrivateKey, newKey))
+		h(assert.Equal(t, newKey, unlock(alicePriv)))
+
+		err = a.Run("get", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", a.stdout.String()))
+	})
+
+	t.Run("ok/rm", func(t *testing.T) {
+		err := app.Run("recovery", "rm", "bob")
+		h(assert.NoError(t, err))
+
+		err = newApp().Run("recovery", "unlock", "--private-key="+bobPriv)
+		h(assert.EqualError(t, err, "the private key doesn't unlock any recovery key"))
+
+		err = app.Run("recovery", "rm", "bob")
+		h(assert.EqualError(t, err, "recovery key 'bob' doesn't exist"))
+	})
+}
+
+func TestAppEncryptionKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init")
+	h(assert.NoError(t, err))
+	err = app.Run("set", "key", "value")
+	h(assert.NoError(t, err))
+
+	encKey := base58.Encode(app.ctx.User.PrivateKey[:])
+	err = vfs.WriteFile(app.ctx.FS, "/key", []byte(encKey+"\n"), 0o600)
+	h(assert.NoError(t, err))
+
+	// A new app without the local user loaded.
+	newApp := func() *testApp {
+		a, err := newTestApp(tctx,
+			WithDB(app.ctx.DB), WithStore(app.ctx.Store), WithUser(nil), WithFS(app.ctx.FS))
+		h(assert.NoError(t, err))
+		return a
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			uri  string
+			env  map[string]string
+		}{
+			{name: "file", uri: "file:/key"},
+			{name: "path", uri: "/key"},
+			{name: "env", uri: "env:MY_KEY", env: map[string]string{"MY_KEY": encKey}},
+			{name: "cmd", uri: "cmd:echo " + encKey},
+			{
+				name: "systemd-creds", uri: "systemd-creds:",
+				env: map[string]string{"CREDENTIALS_DIRECTORY": "/creds"},
+			},
+		}
+
+		err = app.ctx.FS.MkdirAll("/creds", 0o700)
+		h(assert.NoError(t, err))
+		err = vfs.WriteFile(app.ctx.FS, "/creds/disco-encryption-key", []byte(encKey), 0o600)
+		h(assert.NoError(t, err))
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				tcApp := newApp()
+				for k, v := range tc.env {
+					err := tcApp.env.Set(k, v)
+					h(assert.NoError(t, err))
+				}
+				err := tcApp.Run("get", "key", "--encryption-key="+tc.uri)
+				h(assert.NoError(t, err))
+				h(assert.Equal(t, "value", tcApp.stdout.String()))
+			})
+		}
+	})
+
+	t.Run("ok/data_keys", func(t *testing.T) {
+		tcApp := newApp()
+		err := tcApp.env.Set("MY_KEY", encKey)
+		h(assert.NoError(t, err))
+		readKey, err := keyprovider.KeyFunc(tcApp.ctx, "env:MY_KEY")
+		h(assert.NoError(t, err))
+
+		// The store has no encryption key, so it's read from the provider
+		// each time a data key is wrapped or unwrapped.
+		s, err := sqlite.Open(tctx, filepath.Join(t.TempDir(), "store.db"),
+			sqlite.WithKeyProvider(readKey))
+		h(assert.NoError(t, err))
+		defer s.Close()
+		err = s.Init("test", tcApp.ctx.Logger)
+		h(assert.NoError(t, err))
+
+		err = s.Set("default", "key", strings.NewReader("value"))
+		h(assert.NoError(t, err))
+		ok, value, err := s.Get("default", "key")
+		h(assert.NoError(t, err))
+		h(assert.True(t, ok))
+		data, err := io.ReadAll(value)
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", string(data)))
+
+		err = tcApp.env.Set("MY_KEY", "")
+		h(assert.NoError(t, err))
+		_, _, err = s.Get("default", "key")
+		h(assert.EqualError(t, err,
+			"failed reading the encryption key: environment variable 'MY_KEY' is not set"))
+
+		// A key from another source can't unwrap the data key.
+		err = tcApp.env.Set("MY_KEY", base58.Encode(make([]byte, 32)))
+		h(assert.NoError(t, err))
+		_, _, err = s.Get("default", "key")
+		h(assert.ErrorContains(t, err, "failed decrypting data key of namespace 'default'"))
+
+		// Sealing the store stops it from reading the key from the provider.
+		err = tcApp.env.Set("MY_KEY", encKey)
+		h(assert.NoError(t, err))
+		s.SetEncryptionKey(nil)
+		_, _, err = s.Get("default", "key")
+		h(assert.EqualError(t, err, "the encryption key is required"))
+
+		// A file descriptor can't be read again.
+		_, err = keyprovider.KeyFunc(tcApp.ctx, "fd:3")
+		h(assert.EqualError(t, err, "a file descriptor can only be read once"))
+	})
+
+	t.Run("err", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			uri    string
+			expErr string
+		}{
+			{
+				name: "file", uri: "file:/missing",
+				expErr: "invalid encryption key: failed reading key file '/missing': file does not exist",
+			},
+			{
+				name: "env", uri: "env:MISSING_KEY",
+				expErr: "invalid encryption key: environment variable 'MISSING_KEY' is not set",
+			},
+			{
+				name: "cmd", uri: "cmd:false",
+				expErr: "invalid encryption key: failed running key command 'false': exit status 1",
+			},
+			{
+				name: "fd", uri: "fd:x",
+				expErr: "invalid encryption key: invalid file descriptor 'x'",
+			},
+			{
+				name: "systemd-creds", uri: "systemd-creds:",
+				expErr: "invalid encryption key: $CREDENTIALS_DIRECTORY is not set; " +
+					"is the service running under systemd?",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := newApp().Run("get", "key", "--encryption-key="+tc.uri)
+				h(assert.EqualError(t, err, tc.expErr))
+			})
+		}
+	})
+}
+
+func TestAppAgent(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timeout := 5 * time.Second
+	tctx, cancel, h := newTestContext(t, timeout)
+	defer cancel()
+
+	// Flags are passed after the command, since commands are read from
+	// positional arguments.
+	dataDirPath := t.TempDir()
+	dataDir := "--data-dir=" + dataDirPath
+	// The agent refuses to listen in a directory accessible by other users.
+	h(assert.NoError(t, os.Chmod(dataDirPath, 0o700)))
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init", dataDir)
+	h(assert.NoError(t, err))
+
+	encKey := "--encryption-key=" + base58.Encode(app.ctx.User.PrivateKey[:])
+
+	// A new app without the local user loaded, and without the encryption key.
+	newApp := func() *testApp {
+		a, err := newTestApp(tctx,
+			WithDB(app.ctx.DB), WithStore(app.ctx.Store), WithUser(nil))
+		h(assert.NoError(t, err))
+		return a
+	}
+
+	err = newApp().Run("agent", "lock", dataDir)
+	h(assert.ErrorContains(t, err, "agent is not running"))
+
+	startAgent := func(args ...string) <-chan struct{} {
+		agentApp := newApp()
+		startedCh := make(chan string)
+		agentApp.stderr.waitFor(`started agent.*socket=(.*)\n`, 1, startedCh)
+
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			err := agentApp.Run(append(args, dataDir, encKey)...)
+			h(assert.NoError(t, err))
+		}()
+
+		select {
+		case <-startedCh:
+		case <-tctx.Done():
+			t.Fatalf("timed out after %s", timeout)
+		}
+
+		return done
+	}
+
+	t.Run("ok/lock", func(t *testing.T) {
+		agentDone := startAgent("agent")
+
+		err = newApp().Run("agent", "start", dataDir)
+		h(assert.ErrorContains(t, err, "agent is already running"))
+
+		fi, err := os.Stat(filepath.Join(dataDirPath, "agent.sock"))
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm()))
+
+		app2 := newApp()
+		err = app2.Run("set", "key", "value", dataDir)
+		h(assert.NoError(t, err))
+		h(assert.NotEmpty(t, app2.ctx.AgentSocket))
+		h(assert.Nil(t, app2.ctx.User.PrivateKey))
+
+		err = app2.Run("get", "key", dataDir)
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app2.stdout.String()))
+
+		err = app2.Run("ns", "create", "prod", dataDir)
+		h(assert.NoError(t, err))
+
+		err = app2.Run("ns", "ls", dataDir)
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^prod\s+`, app2.stdout.String()))
+
+		err = app2.Run("agent", "lock", dataDir)
+		h(assert.NoError(t, err))
+
+		select {
+		case <-agentDone:
+		case <-tctx.Done():
+			t.Fatalf("timed out after %s", timeout)
+		}
+
+		err = newApp().Run("get", "key", dataDir)
+		h(assert.ErrorContains(t, err, "invalid encryption key"))
+	})
+
+	t.Run("ok/idle_timeout", func(t *testing.T) {
+		agentDone := startAgent("agent", "start", "--idle-timeout=200ms")
+
+		select {
+		case <-agentDone:
+		case <-tctx.Done():
+			t.Fatalf("timed out after %s", timeout)
+		}
+
+		err = newApp().Run("agent", "lock", dataDir)
+		h(assert.ErrorContains(t, err, "agent is not running"))
+	})
+
+	t.Run("ok/conditions", func(t *testing.T) {
+		// Agent requests are considered to come from the IPv6 loopback address.
+		err = newApp().Run("role", "update", "admin", "*:*:*;cidr=::1/128", dataDir)
+		h(assert.NoError(t, err))
+		defer func() {
+			err := newApp().Run("role", "update", "admin", "*:*:*", dataDir)
+			h(assert.NoError(t, err))
+		}()
+
+		lockAgent := func(agentDone <-chan struct{}) {
+			err := newApp().Run("agent", "lock", dataDir)
+			h(assert.NoError(t, err))
+			select {
+			case <-agentDone:
+			case <-tctx.Done():
+				t.Fatalf("timed out after %s", timeout)
+			}
+		}
+
+		agentDone := startAgent("agent")
+		app2 := newApp()
+		err = app2.Run("get", "key", dataDir)
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app2.stdout.String()))
+		lockAgent(agentDone)
+
+		err = newApp().Run("role", "update", "admin", "*:*:*;cidr=10.0.0.0/8", dataDir)
+		h(assert.NoError(t, err))
+
+		agentDone = startAgent("agent")
+		err = newApp().Run("get", "key", dataDir)
+		h(assert.ErrorContains(t, err, "is not authorized to read default:store:key"))
+		lockAgent(agentDone)
+	})
+
+	t.Run("err/insecure_dir", func(t *testing.T) {
+		err = os.Chmod(dataDirPath, 0o755)
+		h(assert.NoError(t, err))
+		defer os.Chmod(dataDirPath, 0o700) //nolint:errcheck
+
+		err = newApp().Run("agent", "start", dataDir, encKey)
+		h(assert.ErrorContains(t, err, "is accessible by other users"))
+	})
+}
+
+func TestAppUnseal(t *testing.T) {
+	t.Parallel()
+
+	tctx, cancel, h := newTestContext(t, 5*time.Second)
+	defer cancel()
+
+	app, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app.Run("init", "--shares=3", "--threshold=4")
+	h(assert.EqualError(t, err, "--threshold must be between 2 and --shares, which must be at most 255"))
+
+	err = app.Run("init", "--shares=3", "--threshold=2")
+	h(assert.NoError(t, err))
+	h(assert.NotContains(t, app.stdout.String(), "New encryption key"))
+	h(assert.Contains(t, app.stdout.String(),
+		"Key shares (2 of 3 are required to reconstruct the encryption key)"))
+
+	sharesRx := regexp.MustCompile(`(?m)^Share \d: (\S+)$`)
+	findShares := func(out string) []string {
+		shares := []string{}
+		for _, m := range sharesRx.FindAllStringSubmatch(out, -1) {
+			shares = append(shares, m[1])
+		}
+		return shares
+	}
+	shares := findShares(app.stdout.String())
+	h(assert.Len(t, shares, 3))
+
+	// A new app without the local user loaded, which shares the filesystem
+	// for keeping the key shares between calls.
+	newApp := func() *testApp {
+		a, err := newTestApp(tctx, WithDB(app.ctx.DB), WithStore(app.ctx.Store),
+			WithFS(app.ctx.FS), WithUser(nil))
+		h(assert.NoError(t, err))
+		return a
+	}
+
+	t.Run("ok/print_key", func(t *testing.T) {
+		app2 := newApp()
+		err = app2.Run("unseal", "--share", shares[2], "--share", shares[0])
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "Encryption key: "+base58.Encode(app.ctx.User.PrivateKey[:])+"\n",
+			app2.stdout.String()))
+	})
+
+	t.Run("ok/repeated_calls", func(t *testing.T) {
+		err = newApp().Run("unseal", "--share", shares[1], "set", "key", "value")
+		h(assert.NoError(t, err))
+
+		app2 := newApp()
+		err = app2.Run("unseal", "--share", shares[1], "get", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "Provided 1 of 2 key shares. "+
+			"Run 'disco unseal' again with another share.\n", app2.stdout.String()))
+
+		app3 := newApp()
+		err = app3.Run("unseal", "--share", shares[0], "set", "key", "value")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "", app3.stdout.String()))
+		h(assert.NotNil(t, app3.ctx.User.PrivateKey))
+
+		err = app3.Run("unseal", "--share", shares[0], "--share", shares[2], "get", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app3.stdout.String()))
+	})
+
+	t.Run("ok/reshare", func(t *testing.T) {
+		app2 := newApp()
+		err = app2.Run("unseal", "--share", shares[0], "--share", shares[1],
+			"key", "reshare", "--shares=2", "--threshold=2")
+		h(assert.NoError(t, err))
+		newShares := findShares(app2.stdout.String())
+		h(assert.Len(t, newShares, 2))
+
+		err = newApp().Run("unseal", "--share", newShares[0], "--share", newShares[1], "get", "key")
+		h(assert.NoError(t, err))
+
+		err = newApp().Run("unseal", "--share", shares[0], "--share", newShares[1], "get", "key")
+		h(assert.EqualError(t, err, "the key share belongs to a different set than the other shares"))
+	})
+
+	t.Run("err/pending_set", func(t *testing.T) {
+		err = newApp().Run("unseal", "--share", shares[0])
+		h(assert.NoError(t, err))
+
+		other, err := crypto.SplitKey(app.ctx.User.PrivateKey, 3, 2)
+		h(assert.NoError(t, err))
+
+		err = newApp().Run("unseal", "--share", other[0])
+		h(assert.EqualError(t, err, "invalid key share: the key share belongs to a different "+
+			"set than the other shares (Run 'disco unseal --reset' to discard the shares provided before.)"))
+
+		err = newApp().Run("unseal", "--reset", "--share", other[0], "--share", other[1])
+		h(assert.NoError(t, err))
+	})
+
+	t.Run("err/invalid", func(t *testing.T) {
+		err = newApp().Run("unseal")
+		h(assert.EqualError(t, err, "no key shares provided (Pass them with --share, or run in a terminal.)"))
+
+		err = newApp().Run("unseal", "--share", "invalid")
+		h(assert.EqualError(t, err, "invalid key share"))
+
+		otherUser, err := createLocalUser()
+		h(assert.NoError(t, err))
+		other, err := crypto.SplitKey(otherUser.PrivateKey, 3, 2)
+		h(assert.NoError(t, err))
+		err = newApp().Run("unseal", "--share", other[0], "--share", other[1])
+		h(assert.ErrorContains(t, err, "the key shares don't reconstruct the encryption key"))
+	})
+}
+
+// Test the scenario of 2 Disco nodes, where one creates a user and invitation
+// token, and the other joins and reads a remote key over the network.
+func TestAppUserInviteJoin(t *testing.T) {
+	t.Parallel()
+
+	// wg.Wait must be deferred before the test context cancellation (so that
+	// it's called after it when the function returns) to avoid waiting for the
+	// context timeout to be reached.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timeout := 5 * time.Second
+	tctx, cancel, h := newTestContext(t, timeout)
+	defer cancel()
+
+	// app1 will accept remote connections
+	app1, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app1.Run("init")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("set", "key", "testvalue")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("get", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "testvalue", app1.stdout.String()))
+
+	err = app1.Run("user", "add", "newuser", "--roles=admin")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("invite", "user", "newuser", "--ttl=1m")
+	h(assert.NoError(t, err))
+
+	// Extract the invite token from the output
+	tokenRx := regexp.MustCompile(`^Token: (.*)\n`)
+	match := tokenRx.FindStringSubmatch(app1.stdout.String())
+	h(assert.Lenf(t, match, 2, "token not found in output:\n%s", app1.stdout.String()))
+
+	token := match[1]
+
+	// Start the web server on app1
+	addrCh := make(chan string)
+	app1.stderr.waitFor(`started web server.*address=(.*)\n`, 1, addrCh)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err = app1.Run("serve", "--address=:0")
+		h(assert.NoError(t, err))
+	}()
+
+	var srvAddress string
+	select {
+	case srvAddress = <-addrCh:
+	case <-tctx.Done():
+		t.Fatalf("timed out after %s", timeout)
+	}
+
+	// app2 is the remote client that will join app1 with the generated token
+	app2, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app2.Run("init")
+	h(assert.NoError(t, err))
+
+	err = app2.Run("remote", "add", "testremote", srvAddress, token)
+	h(assert.NoError(t, err))
+
+	// The key doesn't exist for app2 locally...
+	err = app2.Run("get", "key")
+	h(assert.EqualError(t, err, "key 'key' doesn't exist in the 'default' namespace"))
+	h(assert.Equal(t, "", app2.stdout.String()))
+
+	// ... but it does exist in the remote node.
+	err = app2.Run("get", "--remote=testremote", "key")
+	h(assert.NoError(t, err))
+	h(assert.Equal(t, "testvalue", app2.stdout.String()))
+
+	err = app2.Run("ns", "create", "--remote=testremote", "remote/ns")
+	h(assert.NoError(t, err))
+
+	err = app2.Run("ns", "stats", "--remote=testremote", "remote/ns")
+	h(assert.NoError(t, err))
+	h(assert.Regexp(t, `(?m)^remote/ns\s+0\s+0\s*$`, app2.stdout.String()))
+}
+
+// Test managing users, roles and invites of a Disco node remotely.
+func TestAppRemoteAdmin(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timeout := 10 * time.Second
+	tctx, cancel, h := newTestContext(t, timeout)
+	defer cancel()
+
+	app1, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app1.Run("init")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("role", "add", "useradmin", "rwd:*:user:*")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("role", "add", "storeonly", "rw:*:store:*")
+	h(assert.NoError(t, err))
+
+	tokenRx := regexp.MustCompile(`^Token: (.*)\n`)
+	invite := func(app *testApp, args ...string) string {
+		err := app.Run(append([]string{"invite", "user"}, args...)...)
+		h(assert.NoError(t, err))
+		match := tokenRx.FindStringSubmatch(app.stdout.String())
+		h(assert.Lenf(t, match, 2, "token not found in output:\n%s", app.stdout.String()))
+		return match[1]
+	}
+
+	err = app1.Run("user", "add", "admin", "--roles=admin")
+	h(assert.NoError(t, err))
+	adminToken := invite(app1, "admin")
+
+	err = app1.Run("user", "add", "limited", "--roles=useradmin")
+	h(assert.NoError(t, err))
+	limitedToken := invite(app1, "limited")
+
+	err = app1.Run("user", "add", "writer", "--roles=storeonly")
+	h(assert.NoError(t, err))
+	writerToken := invite(app1, "writer")
+
+	addrCh := make(chan string)
+	app1.stderr.waitFor(`started web server.*address=(.*)\n`, 1, addrCh)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := app1.Run("serve", "--address=:0")
+		h(assert.NoError(t, err))
+	}()
+
+	var srvAddress string
+	select {
+	case srvAddress = <-addrCh:
+	case <-tctx.Done():
+		t.Fatalf("timed out after %s", timeout)
+	}
+
+	join := func(token string) *testApp {
+		app, err := newTestApp(tctx)
+		h(assert.NoError(t, err))
+		err = app.Run("init")
+		h(assert.NoError(t, err))
+		err = app.Run("remote", "add", "testremote", srvAddress, token)
+		h(assert.NoError(t, err))
+		return app
+	}
+	admin := join(adminToken)
+	limited := join(limitedToken)
+	writer := join(writerToken)
+
+	t.Run("ok/roles", func(t *testing.T) {
+		err = admin.Run("role", "add", "--remote=testremote", "reader", "r:*:store:*")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("role", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*reader\s+\*\s+read\s+store:\*\s*$`, admin.stdout.String()))
+
+		err = admin.Run("role", "update", "--remote=testremote", "reader", "r:dev:store:*")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("role", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*reader\s+dev\s+read\s+store:\*\s*$`, app1.stdout.String()))
+	})
+
+	t.Run("ok/users", func(t *testing.T) {
+		err = admin.Run("user", "add", "--remote=testremote", "bob", "--roles=reader")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("user", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*bob\s+reader\s*$`, admin.stdout.String()))
+
+		err = admin.Run("role", "rm", "--remote=testremote", "reader")
+		h(assert.EqualError(t, err, "failed deleting role with name 'reader': 1 user has this role"))
+
+		err = admin.Run("user", "update", "--remote=testremote", "bob", "--roles=node")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("role", "rm", "--remote=testremote", "reader")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("user", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*bob\s+node\s*$`, app1.stdout.String()))
+	})
+
+	t.Run("ok/invites", func(t *testing.T) {
+		token := invite(admin, "--remote=testremote", "bob", "--ttl=1m")
+
+		err = admin.Run("invite", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		match := regexp.MustCompile(`(?m)^\s*(\S+)\s+bob\s+` + token).FindStringSubmatch(admin.stdout.String())
+		h(assert.Lenf(t, match, 2, "invite not found in output:\n%s", admin.stdout.String()))
+
+		err = admin.Run("invite", "update", "--remote=testremote", match[1], "--ttl=1h")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("invite", "rm", "--remote=testremote", match[1])
+		h(assert.NoError(t, err))
+
+		err = app1.Run("invite", "ls")
+		h(assert.NoError(t, err))
+		h(assert.NotContains(t, app1.stdout.String(), token))
+	})
+
+	t.Run("err/unauthorized", func(t *testing.T) {
+		err = limited.Run("user", "add", "--remote=testremote", "eve", "--roles=admin")
+		h(assert.EqualError(t, err, "failed adding user 'eve': user 'limited' is not authorized to write *:role:admin"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "evil", "*:*:*")
+		h(assert.EqualError(t, err, "failed adding role 'evil': user 'limited' is not authorized to write *:role:evil"))
+
+		err = limited.Run("role", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		h(assert.NotContains(t, limited.stdout.String(), "admin"))
+
+		err = limited.Run("user", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*bob\s+node\s*$`, limited.stdout.String()))
+
+		err = limited.Run("invite", "user", "--remote=testremote", "bob")
+		h(assert.EqualError(t, err, "failed creating invite for user 'bob': user 'limited' is not authorized to write *:invite:bob"))
+
+		err = limited.Run("remote", "rm", "--remote=testremote", "testremote")
+		h(assert.EqualError(t, err, "failed deleting remote 'testremote': user 'limited' is not authorized to delete *:remote:testremote"))
+	})
+
+	t.Run("err/grant", func(t *testing.T) {
+		err = app1.Run("role", "update", "useradmin", "rwd:*:user:*", "rwd:*:role:*")
+		h(assert.NoError(t, err))
+		defer func() {
+			err = app1.Run("role", "update", "useradmin", "rwd:*:user:*")
+			h(assert.NoError(t, err))
+		}()
+
+		err = limited.Run("role", "add", "--remote=testremote", "evil", "*:*:*")
+		h(assert.EqualError(t, err, "failed adding role 'evil': not allowed to grant permission '*:*:*:*': "+
+			"user 'limited' is not authorized to * *:*:*"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "evil", "r:*:store:*")
+		h(assert.EqualError(t, err, "failed adding role 'evil': not allowed to grant permission 'r:*:store:*': "+
+			"user 'limited' is not authorized to read *:store:*"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "userreader", "r:*:user:*", "!d:*:store:*")
+		h(assert.NoError(t, err))
+
+		err = limited.Run("role", "update", "--remote=testremote", "userreader", "r:*:user:*", "r:*:*")
+		h(assert.EqualError(t, err, "failed adding role 'userreader': not allowed to grant permission 'r:*:*:*': "+
+			"user 'limited' is not authorized to read *:*:*"))
+
+		err = limited.Run("user", "update", "--remote=testremote", "limited", "--roles=useradmin,admin")
+		h(assert.EqualError(t, err, "failed updating user 'limited': not allowed to grant permission '*:*:*:*': "+
+			"user 'limited' is not authorized to * *:*:*"))
+
+		err = limited.Run("role", "rm", "--remote=testremote", "userreader")
+		h(assert.NoError(t, err))
+	})
+
+	t.Run("err/grant_restricted", func(t *testing.T) {
+		err = app1.Run("role", "add", "noadmins", "!rwd:*:user:admin")
+		h(assert.NoError(t, err))
+		err = app1.Run("role", "update", "useradmin", "rwd:*:user:*", "rwd:*:role:*;cidr=127.0.0.0/8,::1/128")
+		h(assert.NoError(t, err))
+		err = app1.Run("user", "update", "limited", "--roles=useradmin,noadmins")
+		h(assert.NoError(t, err))
+		defer func() {
+			err = app1.Run("user", "update", "limited", "--roles=useradmin")
+			h(assert.NoError(t, err))
+			err = app1.Run("role", "update", "useradmin", "rwd:*:user:*")
+			h(assert.NoError(t, err))
+		}()
+
+		err = limited.Run("role", "add", "--remote=testremote", "useradmin2", "rwd:*:user:*")
+		h(assert.EqualError(t, err, "failed adding role 'useradmin2': not allowed to grant permission 'drw:*:user:*': "+
+			"user 'limited' is denied by permission '!drw:*:user:admin' of role 'noadmins', which must also be granted"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "useradmin2", "rwd:*:user:*", "!d:*:user:*")
+		h(assert.EqualError(t, err, "failed adding role 'useradmin2': not allowed to grant permission 'drw:*:user:*': "+
+			"user 'limited' is denied by permission '!drw:*:user:admin' of role 'noadmins', which must also be granted"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "useradmin2", "rwd:*:user:*", "!rwd:*:user:admin*")
+		h(assert.NoError(t, err))
+
+		err = limited.Run("role", "add", "--remote=testremote", "roleadmin", "r:*:role:*")
+		h(assert.EqualError(t, err, "failed adding role 'roleadmin': not allowed to grant permission 'r:*:role:*': "+
+			"user 'limited' is only authorized to read *:role:* under stricter conditions"))
+
+		err = limited.Run("role", "add", "--remote=testremote", "roleadmin", "r:*:role:*;cidr=127.0.0.1/32")
+		h(assert.NoError(t, err))
+
+		err = limited.Run("user", "add", "--remote=testremote", "carol", "--roles=roleadmin,useradmin2")
+		h(assert.NoError(t, err))
+
+		err = limited.Run("user", "update", "--remote=testremote", "carol", "--roles=useradmin")
+		h(assert.EqualError(t, err, "failed updating user 'carol': not allowed to grant permission 'drw:*:user:*': "+
+			"user 'limited' is denied by permission '!drw:*:user:admin' of role 'noadmins', which must also be granted"))
+
+		err = limited.Run("user", "update", "--remote=testremote", "carol", "--roles=useradmin,noadmins")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("user", "rm", "--remote=testremote", "carol")
+		h(assert.NoError(t, err))
+		for _, role := range []string{"useradmin2", "roleadmin"} {
+			err = admin.Run("role", "rm", "--remote=testremote", role)
+			h(assert.NoError(t, err))
+		}
+	})
+
+	t.Run("err/namespace", func(t *testing.T) {
+		err = writer.Run("set", "--remote=testremote", "key", "value")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("ns", "create", "--remote=testremote", "dev")
+		h(assert.EqualError(t, err, "failed creating namespace 'dev': user 'writer' is not authorized to write dev:namespace:dev"))
+
+		err = writer.Run("set", "--remote=testremote", "--namespace=dev", "key", "value")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to write dev:namespace:dev"))
+
+		err = app1.Run("role", "update", "storeonly", "rw:*:store:*", "w:*:namespace:*")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("set", "--remote=testremote", "--namespace=dev", "key", "value")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("ns", "rm", "--remote=testremote", "dev")
+		h(assert.EqualError(t, err, "failed removing namespace 'dev': user 'writer' is not authorized to delete dev:namespace:dev"))
+	})
+
+	t.Run("err/deny", func(t *testing.T) {
+		err = app1.Run("role", "add", "nopayments", "!w:*:store:payments/*")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("role", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*nopayments\s+\*\s+!write\s+store:payments/\*\s*$`, app1.stdout.String()))
+
+		err = writer.Run("set", "--remote=testremote", "payments/key", "value")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to write default:store:payments/key"))
+
+		err = writer.Run("set", "--remote=testremote", "--namespace=dev", "payments/key", "value")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to write dev:store:payments/key"))
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", writer.stdout.String()))
+	})
+
+	t.Run("err/deny_all_namespaces", func(t *testing.T) {
+		err = app1.Run("set", "--namespace=prod", "secret", "value")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("role", "add", "noprod", "!rw:prod:store:*")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments,noprod")
+		h(assert.NoError(t, err))
+		defer func() {
+			err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments")
+			h(assert.NoError(t, err))
+		}()
+
+		err = writer.Run("set", "--remote=testremote", "--namespace=*", "secret", "changed")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to write prod:store:secret"))
+
+		err = app1.Run("get", "--namespace=prod", "secret")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", app1.stdout.String()))
+
+		err = writer.Run("ls", "--remote=testremote", "--namespace=*")
+		h(assert.NoError(t, err))
+		h(assert.Contains(t, writer.stdout.String(), "key"))
+		h(assert.NotContains(t, writer.stdout.String(), "prod"))
+		h(assert.NotContains(t, writer.stdout.String(), "secret"))
+
+		err = writer.Run("ls", "--remote=testremote", "--namespace=prod")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to read prod:store:"))
+	})
+
+	t.Run("err/deny_copy", func(t *testing.T) {
+		err = writer.Run("ns", "cp", "--remote=testremote", "dev", "devcopy")
+		h(assert.EqualError(t, err, "failed copying namespace 'dev': "+
+			"user 'writer' is not authorized to write all of devcopy:store:*"))
+
+		err = app1.Run("role", "add", "nodevpayments", "!r:dev:store:payments/*")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("user", "update", "writer", "--roles=storeonly,nodevpayments")
+		h(assert.NoError(t, err))
+		defer func() {
+			err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments")
+			h(assert.NoError(t, err))
+		}()
+
+		err = writer.Run("ns", "cp", "--remote=testremote", "dev", "devcopy")
+		h(assert.EqualError(t, err, "failed copying namespace 'dev': "+
+			"user 'writer' is not authorized to read all of dev:store:*"))
+
+		err = writer.Run("ns", "cp", "--remote=testremote", "default", "defaultcopy")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("ns", "rm", "--force", "defaultcopy")
+		h(assert.NoError(t, err))
+	})
+
+	t.Run("err/conditions", func(t *testing.T) {
+		err = app1.Run("role", "update", "storeonly", "rw:*:store:*", "--cidr=10.0.0.0/8")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to read default:store:key"))
+
+		err = app1.Run("role", "update", "storeonly", "rw:*:store:*;cidr=10.0.0.0/8,127.0.0.0/8,::1/128")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", writer.stdout.String()))
+
+		err = app1.Run("role", "update", "storeonly", "rw:*:store:*",
+			"--time=2000-01-01T00:00:00Z/2000-02-01T00:00:00Z")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to read default:store:key"))
+
+		err = app1.Run("role", "update", "storeonly", "rw:*:store:*",
+			"--time=2000-01-01T00:00:00Z/2000-02-01T00:00:00Z", "--time=Mon-Sun")
+		h(assert.NoError(t, err))
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "value", writer.stdout.String()))
+
+		err = app1.Run("role", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*storeonly\s+\*\s+read,write\s+store:\*\s+`+
+			`time=2000-01-01T00:00:00Z/2000-02-01T00:00:00Z;time=Sun-Sat\s*$`, app1.stdout.String()))
+
+		err = app1.Run("role", "add", "invalid", "r:*:store:*;time=Mon 09:00")
+		h(assert.EqualError(t, err, `<permissions> ...: invalid time window 'Mon 09:00': invalid time range '09:00'`))
+	})
+
+	t.Run("err/spoofed_addr", func(t *testing.T) {
+		err = app1.Run("role", "add", "noloopback", "!r:*:store:*;cidr=127.0.0.0/8,::1/128")
+		h(assert.NoError(t, err))
+
+		err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments,noloopback")
+		h(assert.NoError(t, err))
+		defer func() {
+			err = app1.Run("user", "update", "writer", "--roles=storeonly,nopayments")
+			h(assert.NoError(t, err))
+		}()
+
+		err = writer.Run("get", "--remote=testremote", "key")
+		h(assert.EqualError(t, err, "user 'writer' is not authorized to read default:store:key"))
+
+		// The proxy headers must not change the address that conditions are
+		// evaluated against.
+		r := &models.Remote{Name: "testremote"}
+		err = r.Load(writer.ctx.DB.NewContext(), writer.ctx.DB)
+		h(assert.NoError(t, err))
+		tlsConfig, err := r.ClientTLSConfig(writer.ctx.User.PrivateKey)
+		h(assert.NoError(t, err))
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		for _, header := range []string{"X-Forwarded-For", "X-Real-IP"} {
+			req, err := http.NewRequestWithContext(tctx, http.MethodGet,
+				fmt.Sprintf("https://%s/api/v1/store/value/key", srvAddress), nil)
+			h(assert.NoError(t, err))
+			req.Header.Set(header, "10.1.2.3")
+			resp, err := httpClient.Do(req)
+			h(assert.NoError(t, err))
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			h(assert.NoError(t, err))
+			h(assert.Equalf(t, http.StatusUnauthorized, resp.StatusCode, "%s: %s", header, body))
+		}
+	})
+
+	t.Run("err/rollback", func(t *testing.T) {
+		r := &models.Remote{Name: "testremote"}
+		err = r.Load(writer.ctx.DB.NewContext(), writer.ctx.DB)
+		h(assert.NoError(t, err))
+		tlsConfig, err := r.ClientTLSConfig(writer.ctx.User.PrivateKey)
+		h(assert.NoError(t, err))
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		testCases := []struct {
+			name      string
+			query     string
+			expStatus int
+			expErr    string
+		}{
+			{
+				name: "version", query: "version=100", expStatus: http.StatusNotFound,
+				expErr: "version doesn't exist: key 'key', version 100",
+			},
+			{
+				name: "namespace", query: "namespace=missing&version=1", expStatus: http.StatusNotFound,
+				expErr: "namespace doesn't exist: missing",
+			},
+			{
+				name: "invalid_version", query: "version=0", expStatus: http.StatusBadRequest,
+				expErr: "invalid version: '0'",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				req, err := http.NewRequestWithContext(tctx, http.MethodPost,
+					fmt.Sprintf("https://%s/api/v1/store/rollback/key?%s", srvAddress, tc.query), nil)
+				h(assert.NoError(t, err))
+				resp, err := httpClient.Do(req)
+				h(assert.NoError(t, err))
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				h(assert.NoError(t, err))
+				h(assert.Equalf(t, tc.expStatus, resp.StatusCode, "%s", body))
+				h(assert.Contains(t, string(body), tc.expErr))
+			})
+		}
+
+		err = writer.Run("rollback", "--remote=testremote", "key", "100")
+		h(assert.EqualError(t, err, "version doesn't exist: key 'key', version 100"))
+	})
+
+	t.Run("ok/auth_check", func(t *testing.T) {
+		err = app1.Run("auth", "check", "--user=writer", "write", "default", "store:payments/key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "deny: role 'nopayments', permission '!w:*:store:payments/*'\n", app1.stdout.String()))
+
+		err = app1.Run("auth", "check", "--user=writer", "read", "dev", "store:key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "allow: role 'storeonly', permission "+
+			"'rw:*:store:*;time=2000-01-01T00:00:00Z/2000-02-01T00:00:00Z;time=Sun-Sat'\n",
+			app1.stdout.String()))
+
+		err = app1.Run("auth", "check", "--user=writer", "delete", "dev", "store:key")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `^deny: no permission allows it\n`, app1.stdout.String()))
+		h(assert.Regexp(t, `(?m)^\s*storeonly\s+rw:\*:store:\*;\S+;time=Sun-Sat\s+action\s*$`, app1.stdout.String()))
+
+		err = writer.Run("auth", "check", "--remote=testremote", "write", "default", "store:payments/key")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "deny: role 'nopayments', permission '!w:*:store:payments/*'\n", writer.stdout.String()))
+
+		err = limited.Run("auth", "check", "--remote=testremote", "--user=writer", "read", "default", "store:key")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `^allow: role 'storeonly'`, limited.stdout.String()))
+
+		err = writer.Run("auth", "check", "--remote=testremote", "--user=limited", "read", "default", "store:key")
+		h(assert.EqualError(t, err, "failed checking access: user 'writer' is not authorized to read *:user:limited"))
+	})
+
+	t.Run("err/user_not_found", func(t *testing.T) {
+		err = admin.Run("user", "rm", "--remote=testremote", "missing")
+		h(assert.EqualError(t, err, "user with name 'missing' doesn't exist"))
+	})
+
+	t.Run("ok/remotes", func(t *testing.T) {
+		err = admin.Run("remote", "ls", "--remote=testremote")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "", admin.stdout.String()))
+
+		err = admin.Run("remote", "rm", "--remote=testremote", "missing")
+		h(assert.EqualError(t, err, "failed deleting remote 'missing': remote with name 'missing' doesn't exist"))
+
+		err = admin.Run("remote", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Regexp(t, `(?m)^\s*testremote\s+`, admin.stdout.String()))
+
+		err = admin.Run("remote", "rm", "testremote")
+		h(assert.NoError(t, err))
+
+		err = admin.Run("remote", "ls")
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "", admin.stdout.String()))
+	})
+}
+
+// Test sealing and unsealing a server remotely, and starting it sealed.
+func TestAppServeSealed(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timeout := 10 * time.Second
+	tctx, cancel, h := newTestContext(t, timeout)
+	defer cancel()
+
+	app1, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app1.Run("init", "--shares=3", "--threshold=2")
+	h(assert.NoError(t, err))
+	shares := []string{}
+	for _, m := range regexp.MustCompile(`(?m)^Share \d: (\S+)$`).FindAllStringSubmatch(app1.stdout.String(), -1) {
+		shares = append(shares, m[1])
+	}
+	h(assert.Len(t, shares, 3))
+	encKey := base58.Encode(app1.ctx.User.PrivateKey[:])
+
+	// Wrap the data keys with the initialized encryption key, instead of the
+	// one the test store was created with.
+	err = app1.Run("key", "rewrap")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("set", "key", "testvalue")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("user", "add", "newuser", "--roles=admin")
+	h(assert.NoError(t, err))
+
+	err = app1.Run("invite", "user", "newuser", "--ttl=1m")
+	h(assert.NoError(t, err))
+	match := regexp.MustCompile(`^Token: (.*)\n`).FindStringSubmatch(app1.stdout.String())
+	h(assert.Lenf(t, match, 2, "token not found in output:\n%s", app1.stdout.String()))
+	token := match[1]
+
+	// startServer starts a server that shares the stores of app1, and returns
+	// its address, and a function that stops it.
+	fs := memoryfs.New()
+	startServer := func(args ...string) (string, func()) {
+		srvCtx, cancelSrv := context.WithCancel(tctx)
+		srvApp, err := newTestApp(srvCtx, WithDB(app1.ctx.DB), WithStore(app1.ctx.Store),
+			WithFS(fs), WithUser(nil))
+		h(assert.NoError(t, err))
+
+		addrCh := make(chan string)
+		srvApp.stderr.waitFor(`started web server.*address=(.*)\n`, 1, addrCh)
+
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			err := srvApp.Run(append([]string{"serve"}, args...)...)
+			h(assert.NoError(t, err))
+		}()
+
+		select {
+		case addr := <-addrCh:
+			return addr, func() {
+				cancelSrv()
+				<-done
+			}
+		case <-tctx.Done():
+			t.Fatalf("timed out after %s", timeout)
+		}
+
+		return "", nil
+	}
+
+	srvAddress, stopServer := startServer("--address=:0", "--encryption-key="+encKey)
+
+	app2, err := newTestApp(tctx)
+	h(assert.NoError(t, err))
+
+	err = app2.Run("init")
+	h(assert.NoError(t, err))
+
+	err = app2.Run("remote", "add", "testremote", srvAddress, token)
+	h(assert.NoError(t, err))
+
+	getRemote := func() error {
+		err := app2.Run("get", "--remote=testremote", "key")
+		if err == nil {
+			h(assert.Equal(t, "testvalue", app2.stdout.String()))
+		}
+		return err
+	}
+
+	t.Run("ok/seal_unseal_shares", func(t *testing.T) {
+		err = app2.Run("remote", "seal", "testremote")
+		h(assert.NoError(t, err))
+
+		err = getRemote()
+		h(assert.EqualError(t, err, "the server is sealed"))
+
+		err = app2.Run("remote", "unseal", "testremote", "--share", shares[2])
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "Provided 1 of 2 key shares.\n", app2.stdout.String()))
+
+		err = getRemote()
+		h(assert.EqualError(t, err, "the server is sealed"))
+
+		err = app2.Run("remote", "unseal", "testremote", "--share", shares[0])
+		h(assert.NoError(t, err))
+		h(assert.Equal(t, "Remote 'testremote' is unsealed.\n", app2.stdout.String()))
+
+		err = getRemote()
+		h(assert.NoError(t, err))
+	})
+
+	t.Run("err/unseal", func(t *testing.T) {
+		err = app2.Run("remote", "unseal", "testremote")
+		h(assert.EqualError(t, err, "no encryption key or key shares provided "+
+			"(Pass them with --key or --share, or run in a terminal.)"))
+
+		err = app2.Run("remote", "seal", "testremote")
+		h(assert.NoError(t, err))
+
+		otherUser, err := createLocalUser()
+		h(assert.NoError(t, err))
+		err = app2.Run("remote", "unseal", "testremote",
+			"--key", base58.Encode(otherUser.PrivateKey[:]))
+		h(assert.EqualError(t, err,
+			"failed unsealing remote 'testremote': the encryption key is invalid"))
+
+		err = app2.Run("remote", "unseal", "testremote", "--key", encKey)
+		h(assert.NoError(t, err))
+	})
+
+	stopServer()
+
+	t.Run("ok/start_sealed", func(t *testing.T) {
+		// Older versions stored the CA private key unencrypted.
+		legacyKeyFile := filepath.Join("/disco", "server-tls.key")
+		err = vfs.WriteFile(fs, legacyKeyFile, []byte("key"), 0o600)
+		h(assert.NoError(t, err))
+
+		// The first time, the encryption key is needed for creating the TLS
+		// serving certificate.
+		_, stopServer := startServer("--address="+srvAddress, "--sealed", "--encryption-key="+encKey)
+		certData, err := vfs.ReadFile(fs, filepath.Join("/disco", actx.ServerTLSFile))
+		h(assert.NoError(t, err))
+		_, err = fs.Stat(legacyKeyFile)
+		h(assert.ErrorIs(t, err, os.ErrNotExist))
+
+		// It can't be used for signing or as a client certificate.
+		certBlock, _ := pem.Decode(certData)
+		h(assert.NotNil(t, certBlock))
+		cert, err := x509.ParseCertificate(certBlock.Bytes)
+		h(assert.NoError(t, err))
+		h(assert.False(t, cert.IsCA))
+		h(assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage))
+
+		err = getRemote()
+		h(assert.EqualError(t, err, "the server is sealed"))
+		stopServer()
+
+		// After that, it can be started unattended.
+		_, stopServer = startServer("--address="+srvAddress, "--sealed")
+		defer stopServer()
+
+		err = getRemote()
+		h(assert.EqualError(t, err, "the server is sealed"))
+
+		err = app2.Run("remote", "unseal", "testremote", "--key", encKey)
+		h(assert.NoError(t, err))
+
+		err = getRemote()
+		h(assert.NoError(t, err))
+	})
 }
 
 func TestAppLogLevel(t *testing.T) {
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/web/client"
+	"go.hackfix.me/disco/web/server"
+)
+
+// The Agent command keeps the node unlocked, so that local commands can access
+// the store without the encryption key.
+type Agent struct {
+	Start struct {
+		IdleTimeout time.Duration `help:"Lock the agent after not receiving requests for this long. \n If 0, the agent is only locked explicitly." default:"15m"`
+	} `kong:"cmd,default='withargs',help='Unlock the node, and serve local commands until the agent is locked.'"`
+	Lock struct{} `kong:"cmd,help='Stop the running agent, which forgets the encryption key.'"`
+}
+
+// AgentSocketPath returns the path of the Unix socket the agent listens on.
+func AgentSocketPath(dataDir string) string {
+	return filepath.Join(dataDir, "agent.sock")
+}
+
+// Run the agent command.
+func (c *Agent) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	socketPath := AgentSocketPath(appCtx.DataDir)
+	aclient := client.NewAgent(socketPath)
+
+	switch kctx.Selected().Name {
+	case "start":
+		return c.start(appCtx, aclient, socketPath)
+	case "lock":
+		if err := aclient.Ping(appCtx.Ctx); err != nil {
+			return aerrors.NewRuntimeError("agent is not running", err, "")
+		}
+		if err := aclient.AgentLock(appCtx.Ctx); err != nil {
+			return aerrors.NewRuntimeError("failed locking agent", err, "")
+		}
+	}
+
+	return nil
+}
+
+func (c *Agent) start(appCtx *actx.Context, aclient *client.Client, socketPath string) error {
+	if c.Start.IdleTimeout < 0 {
+		return errors.New("idle timeout must not be negative")
+	}
+
+	if err := aclient.Ping(appCtx.Ctx); err == nil {
+		return aerrors.NewRuntimeError("agent is already running", nil,
+			"Run 'disco agent lock' to stop it.")
+	}
+	// The socket file of an agent that didn't shut down cleanly would prevent
+	// listening on it.
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return aerrors.NewRuntimeError("failed removing stale agent socket", err, "")
+	}
+
+	// The process holds the encryption key for as long as it runs.
+	if err := crypto.DisableCoreDumps(); err != nil {
+		appCtx.Logger.Warn("failed disabling core dumps", "error", err)
+	}
+
+	agent := server.NewAgent(appCtx, socketPath, c.Start.IdleTimeout)
+
+	agentDone := make(chan error)
+	go func() {
+		agentErr := agent.ListenAndServe()
+		slog.Debug("agent shutdown")
+		agentDone <- agentErr
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	select {
+	case s := <-sigCh:
+		slog.Debug("process received signal", "signal", s)
+	case <-appCtx.Ctx.Done():
+		slog.Debug("app context is done")
+	case <-agent.Locked():
+		slog.Debug("agent is locked")
+	case agentErr := <-agentDone:
+		if agentErr != nil && !errors.Is(agentErr, http.ErrServerClosed) {
+			return fmt.Errorf("agent error: %w", agentErr)
+		}
+		return nil
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := agent.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("failed shutting down agent: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mandelsoft/vfs/pkg/vfs"
+
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/db/store"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// The ApplyBatch command applies multiple changes to the store atomically.
+type ApplyBatch struct {
+	File string `arg:"" help:"Path to a JSON file with the changes to apply, or '-' to read from stdin."`
+
+	Remote string `help:"The remote Disco node to apply the changes to."`
+}
+
+// Run the apply-batch command.
+func (c *ApplyBatch) Run(appCtx *actx.Context) error {
+	var (
+		batchData []byte
+		err       error
+	)
+	if c.File == "-" {
+		batchData, err = io.ReadAll(appCtx.Stdin)
+	} else {
+		batchData, err = vfs.ReadFile(appCtx.FS, c.File)
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading batch file: %w", err)
+	}
+
+	batch := &types.StoreTxnRequest{}
+	if err = json.Unmarshal(batchData, batch); err != nil {
+		return fmt.Errorf("failed parsing batch file: %w", err)
+	}
+	if len(batch.Ops) == 0 {
+		return errors.New("batch file contains no operations")
+	}
+
+	ops := make([]*store.TxnOp, len(batch.Ops))
+	for i, bop := range batch.Ops {
+		op, err := bop.StoreOp()
+		if err != nil {
+			return fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		op.Options = append(op.Options, store.WithAuthor(appCtx.User.Name))
+		ops[i] = op
+	}
+
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		return rclient.StoreTxn(appCtx.Ctx, batch.Ops)
+	}
+
+	return appCtx.Store.Txn(ops)
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/db/models"
+)
+
+// The Auth command inspects access control.
+type Auth struct {
+	Check struct {
+		Action    string     `arg:"" enum:"read,write,delete" help:"The action to check. One of: ${enum}."`
+		Namespace string     `arg:"" help:"The namespace of the target."`
+		Target    string     `arg:"" help:"The target in '<resource>:<pattern>' format, e.g. 'store:myapp/db'."`
+		User      string     `help:"The user to check. \n By default, the local user, or the user authenticated by the remote node, is checked."`
+		Addr      netip.Addr `help:"The source IP address to evaluate IP range conditions with. \n Ignored if --remote is specified, in which case the address of the request is used."`
+		Time      time.Time  `help:"The time in RFC 3339 format to evaluate time window conditions with. Defaults to the current time. \n Ignored if --remote is specified."`
+		Remote    string     `help:"The remote Disco node to check the access on."`
+	} `kong:"cmd,help='Check whether a user is allowed to perform an action, and explain why.'"`
+}
+
+// Run the auth command.
+func (c *Auth) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	switch kctx.Args[1] {
+	case "check":
+		dec, err := c.check(appCtx)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed checking access", err, "")
+		}
+		return printDecision(appCtx.Stdout, dec)
+	}
+
+	return nil
+}
+
+func (c *Auth) check(appCtx *actx.Context) (*models.Decision, error) {
+	res, pattern, ok := strings.Cut(c.Check.Target, ":")
+	if !ok || pattern == "" {
+		return nil, fmt.Errorf("invalid target '%s': must be in '<resource>:<pattern>' format", c.Check.Target)
+	}
+	resource, err := models.ResourceFromString(res)
+	if err != nil {
+		return nil, err
+	}
+
+	rclient, err := remoteClient(appCtx, c.Check.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if rclient != nil {
+		remoteDec, err := rclient.AuthCheck(appCtx.Ctx, c.Check.User, c.Check.Action,
+			c.Check.Namespace, c.Check.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		dec := &models.Decision{Allowed: remoteDec.Allowed, Role: remoteDec.Role}
+		if remoteDec.Permission != "" {
+			dec.Permission = &models.Permission{}
+			if err = dec.Permission.UnmarshalText([]byte(remoteDec.Permission)); err != nil {
+				return nil, fmt.Errorf("invalid permission '%s': %w", remoteDec.Permission, err)
+			}
+		}
+		for _, rc := range remoteDec.Candidates {
+			cand := &models.Candidate{Role: rc.Role, Permission: &models.Permission{}, Mismatch: rc.Mismatch}
+			if err = cand.Permission.UnmarshalText([]byte(rc.Permission)); err != nil {
+				return nil, fmt.Errorf("invalid permission '%s': %w", rc.Permission, err)
+			}
+			dec.Candidates = append(dec.Candidates, cand)
+		}
+
+		return dec, nil
+	}
+
+	user := appCtx.User
+	if c.Check.User != "" {
+		user = &models.User{Name: c.Check.User}
+		if err = user.Load(appCtx.DB.NewContext(), appCtx.DB); err != nil {
+			return nil, err
+		}
+	}
+
+	accCtx := &models.AccessContext{Addr: c.Check.Addr, Time: c.Check.Time}
+	if accCtx.Time.IsZero() {
+		accCtx.Time = time.Now()
+	}
+	target := fmt.Sprintf("%s:%s:%s", c.Check.Namespace, resource, pattern)
+
+	return user.Check(c.Check.Action, target, accCtx)
+}
+
+// printDecision writes the decision of an authorization check, followed by the
+// closest candidate permissions if no permission decided it.
+func printDecision(w io.Writer, dec *models.Decision) error {
+	result := "deny"
+	if dec.Allowed {
+		result = "allow"
+	}
+
+	if dec.Permission == nil {
+		_, err := fmt.Fprintf(w, "%s: no permission allows it\n", result)
+		if err != nil || len(dec.Candidates) == 0 {
+			return err
+		}
+
+		data := make([][]string, len(dec.Candidates))
+		for i, cand := range dec.Candidates {
+			perm, err := cand.Permission.MarshalText()
+			if err != nil {
+				return err
+			}
+			data[i] = []string{cand.Role, string(perm), strings.Join(cand.Mismatch, ",")}
+		}
+
+		if _, err = fmt.Fprintln(w, "\nClosest permissions:"); err != nil {
+			return err
+		}
+		header := []string{"Role", "Permission", "Mismatch"}
+		newTable(header, data, w).Render()
+
+		return nil
+	}
+
+	perm, err := dec.Permission.MarshalText()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s: role '%s', permission '%s'\n", result, dec.Role, perm)
+
+	return err
+}
@@ -14,21 +14,32 @@ type CLI struct {
 	kong *kong.Kong
 	kctx *kong.Context
 
-	Init   Init   `kong:"cmd,help='Initialize the data stores and generate the encryption key.'"`
-	Get    Get    `kong:"cmd,help='Get the value of a key.'"`
-	Set    Set    `kong:"cmd,help='Set the value of a key.'"`
-	Rm     Rm     `kong:"cmd,help='Delete a key.'"`
-	Ls     Ls     `kong:"cmd,help='List keys.'"`
-	Role   Role   `kong:"cmd,help='Manage roles.'"`
-	Serve  Serve  `kong:"cmd,help='Start the web server.'"`
-	User   User   `kong:"cmd,help='Manage users.'"`
-	Invite Invite `kong:"cmd,help='Manage invitations for remote users.'"`
-	Remote Remote `kong:"cmd,help='Manage remote Disco nodes.'"`
+	Init       Init       `kong:"cmd,help='Initialize the data stores and generate the encryption key.'"`
+	Get        Get        `kong:"cmd,help='Get the value of a key.'"`
+	Set        Set        `kong:"cmd,help='Set the value of a key.'"`
+	Rm         Rm         `kong:"cmd,help='Delete a key.'"`
+	Ls         Ls         `kong:"cmd,help='List keys.'"`
+	History    History    `kong:"cmd,help='Show the version history of a key.'"`
+	Rollback   Rollback   `kong:"cmd,help='Restore a previous version of a key.'"`
+	ApplyBatch ApplyBatch `kong:"cmd,help='Apply multiple changes to the store atomically.'"`
+	Ns         Ns         `kong:"cmd,help='Manage namespaces.'"`
+	Role       Role       `kong:"cmd,help='Manage roles.'"`
+	Serve      Serve      `kong:"cmd,help='Start the web server.'"`
+	User       User       `kong:"cmd,help='Manage users.'"`
+	Invite     Invite     `kong:"cmd,help='Manage invitations for remote users.'"`
+	Remote     Remote     `kong:"cmd,help='Manage remote Disco nodes.'"`
+	Key        Key        `kong:"cmd,help='Manage the encryption key.'"`
+	Passphrase Passphrase `kong:"cmd,help='Manage the passphrases that unlock the encryption key.'"`
+	Agent      Agent      `kong:"cmd,help='Keep the node unlocked for local commands.'"`
+	Unseal     Unseal     `kong:"cmd,help='Reconstruct the encryption key from key shares.'"`
+	Recovery   Recovery   `kong:"cmd,help='Manage the recovery keys that can recover the encryption key.'"`
+	Auth       Auth       `kong:"cmd,help='Inspect access control.'"`
 
 	Version kong.VersionFlag `kong:"help='Output Disco version and exit.'"`
 	DataDir string           `kong:"default='${dataDir}',help='Directory to store Disco data in.'"`
 	//nolint:lll
-	EncryptionKey string `kong:"help='Private key used for encrypting and decrypting the local data store. \n It can be the value itself or a file path that contains the value. '"`
+	EncryptionKey string `kong:"help='Private key used for encrypting and decrypting the local data store. \n It can be the value itself, a file path that contains the value, or a key provider URI: \n file:<path>, env:<name>, fd:<number>, cmd:<command>, or systemd-creds:<name>. '"`
+	Cipher        string `kong:"enum='xsalsa20-poly1305,xchacha20-poly1305',default='xsalsa20-poly1305',help='Cipher used for encrypting new values in the local data store.'"`
 	Log           struct {
 		Level slog.Level `enum:"DEBUG,INFO,WARN,ERROR" default:"INFO" help:"Set the app logging level."`
 	} `embed:"" prefix:"log-"`
@@ -97,3 +108,29 @@ func (c *CLI) Command() string {
 
 	return strings.Join(cmdPath, " ")
 }
+
+// AdminRemote returns the name of the remote node that the user, role, invite,
+// remote or auth command cmd manages, or an empty string if it manages the
+// local node.
+func (c *CLI) AdminRemote(cmd string) string {
+	switch cmd {
+	case "remote ls":
+		return c.Remote.Ls.Remote
+	case "remote rm":
+		return c.Remote.Rm.Remote
+	case "auth check":
+		return c.Auth.Check.Remote
+	}
+
+	group, _, _ := strings.Cut(cmd, " ")
+	switch group {
+	case "user":
+		return c.User.Remote
+	case "role":
+		return c.Role.Remote
+	case "invite":
+		return c.Invite.Remote
+	default:
+		return ""
+	}
+}
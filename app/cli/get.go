@@ -1,12 +1,12 @@
 package cli
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io"
+	"slices"
 
 	actx "go.hackfix.me/disco/app/context"
-	"go.hackfix.me/disco/db/models"
 	"go.hackfix.me/disco/web/client"
 )
 
@@ -14,53 +14,117 @@ import (
 type Get struct {
 	Key string `arg:"" help:"The unique key associated with the value."`
 
-	Namespace string `default:"default" help:"The namespace to retrieve the value from."`
+	Namespace string `default:"default" help:"The namespace to retrieve the value from.\n If '*' is specified, the value is retrieved from all namespaces that contain the key, and printed as namespace and value pairs separated by NUL characters. "`
+	JSON      bool   `help:"Print the values as a JSON object of namespaces to values."`
 	Remote    string `help:"The remote Disco node to retrieve the value from."`
+	// The --version flag is reserved for the app version.
+	Version int `name:"ver" help:"Retrieve the value of a previous version of the key. \n See the 'history' command for the available versions."`
 }
 
 // Run the get command.
 func (c *Get) Run(appCtx *actx.Context) error {
-	if c.Namespace == "*" {
-		// TODO: Think about how the wildcard namespace could work for the get
-		// command. Output values for the given key in all namespaces, separated
-		// by \0?
-		return errors.New("namespace '*' is not supported for the get command")
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
 	}
 
-	var (
-		value io.Reader
-		ok    bool
-		err   error
-	)
-
-	if c.Remote != "" {
-		r := &models.Remote{Name: c.Remote}
-		if err = r.Load(appCtx.DB.NewContext(), appCtx.DB); err != nil {
+	namespaces := []string{c.Namespace}
+	if c.Namespace == "*" {
+		var err error
+		namespaces, err = c.keyNamespaces(appCtx, rclient)
+		if err != nil {
 			return err
 		}
+		if len(namespaces) == 0 {
+			return fmt.Errorf("key '%s' doesn't exist in any namespace", c.Key)
+		}
+	}
 
-		tlsConfig, err := r.ClientTLSConfig(appCtx.User.PrivateKey)
+	values := make(map[string]string, len(namespaces))
+	for _, ns := range namespaces {
+		value, err := c.getValue(appCtx, rclient, ns)
 		if err != nil {
 			return err
 		}
+		values[ns] = value
+	}
 
-		client := client.New(r.Address, tlsConfig)
-		ok, value, err = client.StoreGet(appCtx.Ctx, c.Namespace, c.Key)
-		if err != nil {
-			return err
+	switch {
+	case c.JSON:
+		enc := json.NewEncoder(appCtx.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(values); err != nil {
+			return fmt.Errorf("failed encoding values: %w", err)
 		}
-	} else {
-		ok, value, err = appCtx.Store.Get(c.Namespace, c.Key)
-		if err != nil {
-			return err
+	case c.Namespace == "*":
+		for _, ns := range namespaces {
+			fmt.Fprintf(appCtx.Stdout, "%s\x00%s\x00", ns, values[ns])
 		}
+	default:
+		fmt.Fprint(appCtx.Stdout, values[c.Namespace])
+	}
+
+	return nil
+}
+
+// getValue returns the value of the key in the given namespace.
+func (c *Get) getValue(appCtx *actx.Context, rclient *client.Client, namespace string) (string, error) {
+	var (
+		value io.Reader
+		ok    bool
+		err   error
+	)
+
+	if rclient != nil {
+		ok, value, err = rclient.StoreGet(appCtx.Ctx, namespace, c.Key, c.Version)
+	} else if c.Version > 0 {
+		ok, value, err = appCtx.Store.GetVersion(namespace, c.Key, c.Version)
+	} else {
+		ok, value, err = appCtx.Store.Get(namespace, c.Key)
+	}
+	if err != nil {
+		return "", err
 	}
 
 	if !ok {
-		return fmt.Errorf("key '%s' doesn't exist in the '%s' namespace", c.Key, c.Namespace)
+		if c.Version > 0 {
+			return "", fmt.Errorf("version %d of key '%s' doesn't exist in the '%s' namespace",
+				c.Version, c.Key, namespace)
+		}
+		return "", fmt.Errorf("key '%s' doesn't exist in the '%s' namespace", c.Key, namespace)
 	}
 
-	fmt.Fprintf(appCtx.Stdout, "%v", value)
+	data, err := io.ReadAll(value)
+	if err != nil {
+		return "", fmt.Errorf("failed reading value: %w", err)
+	}
 
-	return nil
+	return string(data), nil
+}
+
+// keyNamespaces returns the sorted names of the namespaces that contain the
+// key.
+func (c *Get) keyNamespaces(appCtx *actx.Context, rclient *client.Client) ([]string, error) {
+	var (
+		keysPerNS map[string][]string
+		err       error
+	)
+	if rclient != nil {
+		keysPerNS, err = rclient.StoreList(appCtx.Ctx, "*", c.Key)
+	} else {
+		keysPerNS, err = appCtx.Store.List("*", c.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := []string{}
+	for ns, keys := range keysPerNS {
+		if slices.Contains(keys, c.Key) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	slices.Sort(namespaces)
+
+	return namespaces, nil
 }
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/db/store"
+)
+
+// The History command prints the versions of a key.
+type History struct {
+	Key string `arg:"" help:"The unique key associated with the value."`
+
+	Namespace string `default:"default" help:"The namespace of the key."`
+	Remote    string `help:"The remote Disco node to retrieve the key history from."`
+}
+
+// Run the history command.
+func (c *History) Run(appCtx *actx.Context) error {
+	var versions []*store.Version
+
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		remoteVersions, err := rclient.StoreHistory(appCtx.Ctx, c.Namespace, c.Key)
+		if err != nil {
+			return err
+		}
+		for _, v := range remoteVersions {
+			versions = append(versions, &store.Version{
+				Number: v.Version, CreatedAt: v.CreatedAt, Author: v.Author,
+				Size: v.Size, ContentType: v.ContentType,
+			})
+		}
+	} else {
+		var err error
+		versions, err = appCtx.Store.History(c.Namespace, c.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(versions) == 0 {
+		return fmt.Errorf("key '%s' has no history in the '%s' namespace", c.Key, c.Namespace)
+	}
+
+	data := make([][]string, len(versions))
+	for i, v := range versions {
+		data[i] = []string{
+			strconv.Itoa(v.Number), v.CreatedAt.Local().Format(time.DateTime), v.Author,
+		}
+	}
+
+	header := []string{"Version", "Created", "Author"}
+	newTable(header, data, appCtx.Stdout).Render()
+
+	return nil
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,7 +15,14 @@ import (
 
 // The Init command initializes the Disco data stores and generates a new
 // encryption key.
-type Init struct{}
+type Init struct {
+	// DISCO_PASSPHRASE contains the passphrase itself, so the flag must not be
+	// read from it.
+	Passphrase bool   `env:"-" help:"Protect the encryption key with a passphrase instead of showing it. \n The passphrase is read from DISCO_PASSPHRASE, or prompted for."`
+	KDF        string `enum:"argon2id,scrypt" default:"argon2id" help:"Key derivation function used for the passphrase."`
+	Shares     int    `help:"Split the encryption key into this many shares instead of showing it. \n See the 'unseal' command for reconstructing it."`
+	Threshold  int    `help:"The number of shares required to reconstruct the encryption key."`
+}
 
 // Run the init command.
 func (c *Init) Run(appCtx *actx.Context) error {
@@ -23,6 +31,23 @@ func (c *Init) Run(appCtx *actx.Context) error {
 		return fmt.Errorf("Disco is already initialized with version %s", appCtx.VersionInit)
 	}
 
+	if c.Shares > 0 || c.Threshold > 0 {
+		if c.Threshold < 2 || c.Threshold > c.Shares || c.Shares > 255 {
+			return errors.New("--threshold must be between 2 and --shares, which must be at most 255")
+		}
+	}
+
+	// Read the passphrase first, so that a failure doesn't leave the stores
+	// initialized with a key that was never shown.
+	var passphrase []byte
+	if c.Passphrase {
+		var err error
+		passphrase, err = appCtx.ReadPassphrase("DISCO_PASSPHRASE", "New passphrase", true)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed reading passphrase", err, "")
+		}
+	}
+
 	rndSANb := make([]byte, 16)
 	_, err := rand.Read(rndSANb)
 	if err != nil {
@@ -45,6 +70,33 @@ func (c *Init) Run(appCtx *actx.Context) error {
 		return aerrors.NewRuntimeError("failed initializing store", err, "")
 	}
 
+	if c.Shares > 0 {
+		if err = printKeyShares(appCtx, c.Shares, c.Threshold); err != nil {
+			return err
+		}
+	}
+
+	if c.Passphrase {
+		ks, err := addKeySlot(appCtx, passphrase, crypto.KDF(c.KDF))
+		if err != nil {
+			return err
+		}
+		if c.Shares > 0 {
+			fmt.Fprintln(appCtx.Stdout)
+		}
+		fmt.Fprintf(appCtx.Stdout, `The encryption key is unlocked with the passphrase in key slot %d.
+
+Consider adding another passphrase with 'disco passphrase add', as you won't be
+able to access the data on this node if all passphrases are lost!
+`, ks.ID)
+
+		return nil
+	}
+
+	if c.Shares > 0 {
+		return nil
+	}
+
 	fmt.Fprintf(appCtx.Stdout, `New encryption key: %s
 
 Make sure to store this key in a secure location, such as a password manager.
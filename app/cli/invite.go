@@ -11,7 +11,8 @@ import (
 	actx "go.hackfix.me/disco/app/context"
 	aerrors "go.hackfix.me/disco/app/errors"
 	"go.hackfix.me/disco/db/models"
-	"go.hackfix.me/disco/db/types"
+	dbtypes "go.hackfix.me/disco/db/types"
+	"go.hackfix.me/disco/web/server/types"
 )
 
 // The Invite command manages invitations for remote users.
@@ -30,70 +31,63 @@ type Invite struct {
 		UUID string         `arg:"" help:"The unique invite ID. A short prefix can be specified as long as it's unique."`
 		TTL  *time.Duration `help:"Time duration the invite is valid for."`
 	} `kong:"cmd,help='Update an invite to extend its validity period.'"`
+
+	Remote string `help:"The remote Disco node to manage invites on."`
 }
 
 // Run the invite command.
 func (c *Invite) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	rclient, err := remoteClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
 	dbCtx := appCtx.DB.NewContext()
 
 	switch kctx.Args[1] {
 	case "user":
-		user := &models.User{Name: c.User.Name}
-		if err := user.Load(dbCtx, appCtx.DB); err != nil {
-			return aerrors.NewRuntimeError(
-				fmt.Sprintf("failed loading user '%s'", c.User.Name), err, "")
-		}
-		inv, err := models.NewInvite(user, c.User.TTL, appCtx.UUIDGen, appCtx.User.PrivateKey)
-		if err != nil {
-			return aerrors.NewRuntimeError(
-				fmt.Sprintf("failed creating invite for user '%s'", c.User.Name), err, "")
+		var inv *types.Invite
+		if rclient != nil {
+			inv, err = rclient.InviteCreate(appCtx.Ctx, c.User.Name, c.User.TTL)
+			if err != nil {
+				return aerrors.NewRuntimeError(
+					fmt.Sprintf("failed creating invite for user '%s'", c.User.Name), err, "")
+			}
+		} else if inv, err = c.createInvite(appCtx); err != nil {
+			return err
 		}
 
-		if err := inv.Save(dbCtx, appCtx.DB, false); err != nil {
-			return aerrors.NewRuntimeError(
-				"failed saving invite to the database", err, "")
-		}
-		token, err := inv.TokenComposite()
-		if err != nil {
-			return aerrors.NewRuntimeError("failed generating composite invitation token", err, "")
-		}
 		timeLeft := inv.Expires.Sub(time.Now().UTC())
 		expFmt := fmt.Sprintf("%s (%s)",
 			inv.Expires.Local().Format(time.DateTime),
 			timeLeft.Round(time.Second))
 		fmt.Fprintf(appCtx.Stdout, `Token: %s
 Expires: %s
-	`, token, expFmt)
+	`, inv.Token, expFmt)
 
 	case "ls":
-		now := time.Now().UTC()
-		var filter *types.Filter
-		if !c.Ls.All {
-			filter = types.NewFilter("inv.expires > ?", []any{now})
+		var invites []*types.Invite
+		if rclient != nil {
+			invites, err = rclient.InviteList(appCtx.Ctx, c.Ls.All)
+		} else {
+			invites, err = c.invites(appCtx)
 		}
-		invites, err := models.Invites(dbCtx, appCtx.DB, filter)
 		if err != nil {
 			return aerrors.NewRuntimeError("failed listing invites", err, "")
 		}
 
+		now := time.Now().UTC()
 		expired, active := [][]string{}, [][]string{}
 		for _, inv := range invites {
 			timeLeft := inv.Expires.Sub(now)
-
-			token, err := inv.TokenComposite()
-			if err != nil {
-				return aerrors.NewRuntimeError("failed generating composite invitation token", err, "")
-			}
-
 			if timeLeft > 0 {
 				expFmt := fmt.Sprintf("%s (%s)",
 					inv.Expires.Local().Format(time.DateTime),
 					timeLeft.Round(time.Second))
-				active = append(active, []string{inv.UUID, inv.User.Name, token, expFmt})
+				active = append(active, []string{inv.UUID, inv.User, inv.Token, expFmt})
 			} else {
 				expFmt := fmt.Sprintf("%s (expired)",
 					inv.Expires.Local().Format(time.DateTime))
-				expired = append(expired, []string{inv.UUID, inv.User.Name, token, expFmt})
+				expired = append(expired, []string{inv.UUID, inv.User, inv.Token, expFmt})
 			}
 		}
 
@@ -114,6 +108,12 @@ Expires: %s
 	case "rm":
 		// TODO: Add a bulk deletion method?
 		for _, invUUID := range c.Rm.UUID {
+			if rclient != nil {
+				if err = rclient.InviteDelete(appCtx.Ctx, invUUID); err != nil {
+					return err
+				}
+				continue
+			}
 			inv := &models.Invite{UUID: invUUID}
 			if err := inv.Delete(dbCtx, appCtx.DB); err != nil {
 				return err
@@ -125,6 +125,10 @@ Expires: %s
 			return errors.New("must set a valid TTL")
 		}
 
+		if rclient != nil {
+			return rclient.InviteUpdate(appCtx.Ctx, c.Update.UUID, *c.Update.TTL)
+		}
+
 		newExpiration := time.Now().UTC().Add(*c.Update.TTL)
 		inv := &models.Invite{UUID: c.Update.UUID, Expires: newExpiration}
 		if err := inv.Save(dbCtx, appCtx.DB, true); err != nil {
@@ -134,3 +138,58 @@ Expires: %s
 
 	return nil
 }
+
+// createInvite creates a new invite for the user in the local database.
+func (c *Invite) createInvite(appCtx *actx.Context) (*types.Invite, error) {
+	dbCtx := appCtx.DB.NewContext()
+	user := &models.User{Name: c.User.Name}
+	if err := user.Load(dbCtx, appCtx.DB); err != nil {
+		return nil, aerrors.NewRuntimeError(
+			fmt.Sprintf("failed loading user '%s'", c.User.Name), err, "")
+	}
+	inv, err := models.NewInvite(user, c.User.TTL, appCtx.UUIDGen, appCtx.User.PrivateKey)
+	if err != nil {
+		return nil, aerrors.NewRuntimeError(
+			fmt.Sprintf("failed creating invite for user '%s'", c.User.Name), err, "")
+	}
+
+	if err := inv.Save(dbCtx, appCtx.DB, false); err != nil {
+		return nil, aerrors.NewRuntimeError(
+			"failed saving invite to the database", err, "")
+	}
+	token, err := inv.TokenComposite()
+	if err != nil {
+		return nil, aerrors.NewRuntimeError("failed generating composite invitation token", err, "")
+	}
+
+	return &types.Invite{
+		UUID: inv.UUID, User: user.Name, Token: token,
+		CreatedAt: inv.CreatedAt, Expires: inv.Expires,
+	}, nil
+}
+
+// invites returns the invites in the local database.
+func (c *Invite) invites(appCtx *actx.Context) ([]*types.Invite, error) {
+	var filter *dbtypes.Filter
+	if !c.Ls.All {
+		filter = dbtypes.NewFilter("inv.expires > ?", []any{time.Now().UTC()})
+	}
+	invites, err := models.Invites(appCtx.DB.NewContext(), appCtx.DB, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*types.Invite, len(invites))
+	for i, inv := range invites {
+		token, err := inv.TokenComposite()
+		if err != nil {
+			return nil, fmt.Errorf("failed generating composite invitation token: %w", err)
+		}
+		out[i] = &types.Invite{
+			UUID: inv.UUID, User: inv.User.Name, Token: token,
+			CreatedAt: inv.CreatedAt, Expires: inv.Expires,
+		}
+	}
+
+	return out, nil
+}
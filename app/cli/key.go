@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/alecthomas/kong"
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/nacl/box"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db"
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/db/types"
+)
+
+// The Key command manages the encryption key.
+type Key struct {
+	Rotate struct {
+		Namespace string `help:"Rotate only the data key of this namespace, and re-encrypt its values. \n The encryption key isn't changed."`
+	} `kong:"cmd,help='Replace the encryption key and the data keys of all namespaces with new ones, and re-encrypt all data with them. \n Stop any running servers before rotating the key.'"`
+	Rewrap struct {
+	} `kong:"cmd,help='Encrypt the data keys of all namespaces again with the encryption key.'"`
+	Reshare struct {
+		Shares    int `required:"" help:"Split the encryption key into this many shares."`
+		Threshold int `required:"" help:"The number of shares required to reconstruct the encryption key."`
+	} `kong:"cmd,help='Split the encryption key into a new set of shares, without rotating it. \n Shares issued before remain valid until the key is rotated.'"`
+}
+
+// Run the key command.
+func (c *Key) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	switch kctx.Args[1] {
+	case "rotate":
+		if c.Rotate.Namespace != "" {
+			if err := appCtx.Store.RotateNamespaceKey(c.Rotate.Namespace); err != nil {
+				return aerrors.NewRuntimeError(fmt.Sprintf(
+					"failed rotating data key of namespace '%s'", c.Rotate.Namespace), err, "")
+			}
+			return nil
+		}
+
+		return rotateKey(appCtx)
+	case "rewrap":
+		err := appCtx.Store.RewrapKeys(appCtx.User.PrivateKey, nil, nil)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed re-wrapping data keys", err, "")
+		}
+	case "reshare":
+		return printKeyShares(appCtx, c.Reshare.Shares, c.Reshare.Threshold)
+	}
+
+	return nil
+}
+
+// rotateKey replaces the encryption key with a new one, and prints it.
+func rotateKey(appCtx *actx.Context) error {
+	_, newKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return aerrors.NewRuntimeError("failed generating encryption key", err, "")
+	}
+	newKey = crypto.LockKey(newKey)
+
+	// Values are encrypted with namespace data keys, which are replaced as
+	// well, so that anyone who obtained them with the previous key can't
+	// decrypt the values anymore. This is done in a single transaction along
+	// with re-encrypting the data in the main database, so that an
+	// interruption can't leave it encrypted with different keys.
+	slots, err := models.KeySlots(appCtx.DB.NewContext(), appCtx.DB)
+	if err != nil {
+		return err
+	}
+
+	oldKey := appCtx.User.PrivateKey
+	err = appCtx.Store.RotateKeys(newKey, map[string]string{"disco": appCtx.DB.Path()},
+		func(q types.Querier) error {
+			return db.RotateKey(q.NewContext(), q, "disco", oldKey, newKey)
+		})
+	if err != nil {
+		return aerrors.NewRuntimeError("failed rotating encryption key", err, "")
+	}
+	appCtx.User.PrivateKey = newKey
+
+	fmt.Fprintf(appCtx.Stdout, `New encryption key: %s
+
+Make sure to store this key in a secure location, such as a password manager,
+and to replace the previous key wherever it's used.
+
+It will only be shown once, and you won't be able to access the data on this node without it!
+`, base58.Encode(newKey[:]))
+	if len(slots) > 0 {
+		fmt.Fprintf(appCtx.Stdout, `
+The %d passphrase key slot(s) were deleted. Add them again with 'disco passphrase add'.
+`, len(slots))
+	}
+
+	return nil
+}
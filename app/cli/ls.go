@@ -3,10 +3,11 @@ package cli
 import (
 	"fmt"
 	"slices"
+	"strconv"
+	"time"
 
 	actx "go.hackfix.me/disco/app/context"
-	"go.hackfix.me/disco/db/models"
-	"go.hackfix.me/disco/web/client"
+	"go.hackfix.me/disco/db/store"
 )
 
 // The Ls command prints keys.
@@ -14,29 +15,27 @@ type Ls struct {
 	KeyPrefix string `arg:"" optional:"" help:"An optional key prefix."`
 
 	Namespace string `default:"default" help:"The namespace to retrieve the keys from.\n If '*' is specified, keys in all namespaces are listed. "`
+	Long      bool   `short:"l" help:"Print the key metadata."`
 	Remote    string `help:"The remote Disco node to retrieve key data from."`
 }
 
 // Run the ls command.
 func (c *Ls) Run(appCtx *actx.Context) error {
+	if c.Long {
+		return c.runLong(appCtx)
+	}
+
 	var (
 		keysPerNS map[string][]string
 		listErr   error
 	)
 
-	if c.Remote != "" {
-		r := &models.Remote{Name: c.Remote}
-		if err := r.Load(appCtx.DB.NewContext(), appCtx.DB); err != nil {
-			return err
-		}
-
-		tlsConfig, err := r.ClientTLSConfig(appCtx.User.PrivateKey)
-		if err != nil {
-			return err
-		}
-
-		client := client.New(r.Address, tlsConfig)
-		keysPerNS, listErr = client.StoreList(appCtx.Ctx, c.Namespace, c.KeyPrefix)
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		keysPerNS, listErr = rclient.StoreList(appCtx.Ctx, c.Namespace, c.KeyPrefix)
 	} else {
 		keysPerNS, listErr = appCtx.Store.List(c.Namespace, c.KeyPrefix)
 	}
@@ -49,14 +48,8 @@ func (c *Ls) Run(appCtx *actx.Context) error {
 	}
 
 	if c.Namespace == "*" {
-		namespaces := []string{}
-		for ns := range keysPerNS {
-			namespaces = append(namespaces, ns)
-		}
-		slices.Sort(namespaces)
-
 		data := make([][]string, 0)
-		for _, ns := range namespaces {
+		for _, ns := range sortedKeys(keysPerNS) {
 			for i, key := range keysPerNS[ns] {
 				row := []string{ns, key}
 				if i > 0 {
@@ -78,3 +71,86 @@ func (c *Ls) Run(appCtx *actx.Context) error {
 
 	return nil
 }
+
+// runLong prints the keys along with their metadata.
+func (c *Ls) runLong(appCtx *actx.Context) error {
+	var metaPerNS map[string][]*store.Metadata
+
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		remoteMeta, err := rclient.StoreListMetadata(appCtx.Ctx, c.Namespace, c.KeyPrefix)
+		if err != nil {
+			return err
+		}
+		metaPerNS = make(map[string][]*store.Metadata, len(remoteMeta))
+		for ns, nsMeta := range remoteMeta {
+			for _, m := range nsMeta {
+				meta := &store.Metadata{
+					Key: m.Key, CreatedAt: m.CreatedAt, UpdatedAt: m.UpdatedAt,
+					Author: m.Author, Size: m.Size, ContentType: m.ContentType,
+					Revision: m.Revision,
+				}
+				if m.ExpiresAt != nil {
+					meta.ExpiresAt = *m.ExpiresAt
+				}
+				metaPerNS[ns] = append(metaPerNS[ns], meta)
+			}
+		}
+	} else {
+		var err error
+		metaPerNS, err = appCtx.Store.ListMetadata(c.Namespace, c.KeyPrefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(metaPerNS) == 0 {
+		return nil
+	}
+
+	data := make([][]string, 0)
+	for _, ns := range sortedKeys(metaPerNS) {
+		for i, meta := range metaPerNS[ns] {
+			var rev, size, updated, expires string
+			if !meta.UpdatedAt.IsZero() {
+				rev = strconv.Itoa(meta.Revision)
+				size = strconv.FormatInt(meta.Size, 10)
+				updated = meta.UpdatedAt.Local().Format(time.DateTime)
+			}
+			if !meta.ExpiresAt.IsZero() {
+				// Show the remaining lifetime of the key.
+				expires = max(time.Until(meta.ExpiresAt), 0).Round(time.Second).String()
+			}
+			row := []string{meta.Key, rev, size, meta.ContentType, updated, expires, meta.Author}
+			if c.Namespace == "*" {
+				nsCol := ns
+				if i > 0 {
+					nsCol = ""
+				}
+				row = append([]string{nsCol}, row...)
+			}
+			data = append(data, row)
+		}
+	}
+
+	header := []string{"Key", "Revision", "Size", "Type", "Updated", "Expires", "Author"}
+	if c.Namespace == "*" {
+		header = append([]string{"Namespace"}, header...)
+	}
+	newTable(header, data, appCtx.Stdout).Render()
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/db/store"
+	"go.hackfix.me/disco/web/client"
+)
+
+// The Ns command manages namespaces.
+type Ns struct {
+	Ls struct {
+	} `kong:"cmd,help='List namespaces.'"`
+	Create struct {
+		Name        string `arg:"" help:"The unique name of the namespace."`
+		Description string `help:"A description of the namespace."`
+	} `kong:"cmd,help='Create a new empty namespace.'"`
+	Rm struct {
+		Name  string `arg:"" help:"The name of the namespace."`
+		Force bool   `help:"Remove the namespace even if it contains keys, deleting them as well."`
+	} `kong:"cmd,help='Remove a namespace.'"`
+	Rename struct {
+		Name    string `arg:"" help:"The current name of the namespace."`
+		NewName string `arg:"" help:"The new name of the namespace."`
+	} `kong:"cmd,help='Rename a namespace.'"`
+	Cp struct {
+		Name    string `arg:"" help:"The name of the namespace to copy."`
+		NewName string `arg:"" help:"The name of the new namespace."`
+	} `kong:"cmd,help='Copy all keys in a namespace to a new namespace.'"`
+	Stats struct {
+		Name string `arg:"" optional:"" help:"The name of the namespace. If not specified, statistics of all namespaces are shown."`
+	} `kong:"cmd,help='Show usage statistics of namespaces.'"`
+
+	Remote string `help:"The remote Disco node to manage namespaces on."`
+}
+
+// Run the ns command.
+func (c *Ns) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+
+	switch kctx.Args[1] {
+	case "ls":
+		nss, err := c.namespaces(appCtx, rclient)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed listing namespaces", err, "")
+		}
+
+		data := make([][]string, len(nss))
+		for i, ns := range nss {
+			data[i] = []string{
+				ns.Name, ns.CreatedAt.Local().Format(time.DateTime), ns.Description,
+			}
+		}
+
+		header := []string{"Name", "Created", "Description"}
+		newTable(header, data, appCtx.Stdout).Render()
+	case "create":
+		var err error
+		if rclient != nil {
+			err = rclient.NamespaceCreate(appCtx.Ctx, c.Create.Name, c.Create.Description)
+		} else {
+			err = appCtx.Store.CreateNamespace(c.Create.Name, c.Create.Description)
+		}
+		if err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed creating namespace '%s'", c.Create.Name), err, "")
+		}
+	case "rm":
+		var err error
+		if rclient != nil {
+			err = rclient.NamespaceDelete(appCtx.Ctx, c.Rm.Name, c.Rm.Force)
+		} else {
+			err = appCtx.Store.DeleteNamespace(c.Rm.Name, c.Rm.Force)
+		}
+		if err != nil {
+			var hint string
+			if errors.Is(err, store.ErrNamespaceNotEmpty) {
+				hint = "remove all keys first or pass --force to delete anyway"
+			}
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed removing namespace '%s'", c.Rm.Name), err, hint)
+		}
+	case "rename":
+		var err error
+		if rclient != nil {
+			err = rclient.NamespaceRename(appCtx.Ctx, c.Rename.Name, c.Rename.NewName)
+		} else {
+			err = appCtx.Store.RenameNamespace(c.Rename.Name, c.Rename.NewName)
+		}
+		if err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed renaming namespace '%s'", c.Rename.Name), err, "")
+		}
+	case "cp":
+		var err error
+		if rclient != nil {
+			err = rclient.NamespaceCopy(appCtx.Ctx, c.Cp.Name, c.Cp.NewName)
+		} else {
+			err = appCtx.Store.CopyNamespace(c.Cp.Name, c.Cp.NewName)
+		}
+		if err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed copying namespace '%s'", c.Cp.Name), err, "")
+		}
+	case "stats":
+		names := []string{c.Stats.Name}
+		if c.Stats.Name == "" {
+			nss, err := c.namespaces(appCtx, rclient)
+			if err != nil {
+				return aerrors.NewRuntimeError("failed listing namespaces", err, "")
+			}
+			names = make([]string, len(nss))
+			for i, ns := range nss {
+				names[i] = ns.Name
+			}
+		}
+
+		data := make([][]string, len(names))
+		for i, name := range names {
+			stats, err := c.namespaceStats(appCtx, rclient, name)
+			if err != nil {
+				return aerrors.NewRuntimeError(
+					fmt.Sprintf("failed reading statistics of namespace '%s'", name), err, "")
+			}
+			data[i] = []string{
+				name, strconv.Itoa(stats.Keys), strconv.FormatInt(stats.Size, 10),
+			}
+		}
+
+		header := []string{"Name", "Keys", "Size"}
+		newTable(header, data, appCtx.Stdout).Render()
+	}
+
+	return nil
+}
+
+func (c *Ns) namespaces(appCtx *actx.Context, rclient *client.Client) ([]*store.Namespace, error) {
+	if rclient == nil {
+		return appCtx.Store.Namespaces()
+	}
+
+	remoteNss, err := rclient.NamespaceList(appCtx.Ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nss := make([]*store.Namespace, len(remoteNss))
+	for i, ns := range remoteNss {
+		nss[i] = &store.Namespace{
+			Name: ns.Name, CreatedAt: ns.CreatedAt, Description: ns.Description,
+		}
+	}
+
+	return nss, nil
+}
+
+func (c *Ns) namespaceStats(appCtx *actx.Context, rclient *client.Client, name string) (*store.NamespaceStats, error) {
+	if rclient == nil {
+		return appCtx.Store.NamespaceStats(name)
+	}
+
+	resp, err := rclient.NamespaceStats(appCtx.Ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.NamespaceStats{Keys: resp.Keys, Size: resp.Size}, nil
+}
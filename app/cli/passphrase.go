@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/models"
+)
+
+// The Passphrase command manages the passphrases that unlock the encryption
+// key.
+type Passphrase struct {
+	Add struct {
+		KDF string `enum:"argon2id,scrypt" default:"argon2id" help:"Key derivation function used for the passphrase."`
+	} `kong:"cmd,help='Add a key slot that unlocks the encryption key with a new passphrase. \n The new passphrase is read from DISCO_NEW_PASSPHRASE, or prompted for.'"`
+	Change struct {
+		Slot uint64 `help:"ID of the key slot to change. Defaults to the slot unlocked by the current passphrase."`
+	} `kong:"cmd,help='Change the passphrase of a key slot. \n The new passphrase is read from DISCO_NEW_PASSPHRASE, or prompted for.'"`
+	Rm struct {
+		Slot uint64 `arg:"" help:"ID of the key slot."`
+	} `kong:"cmd,help='Delete a key slot.'"`
+	Ls struct {
+	} `kong:"cmd,help='List key slots.'"`
+}
+
+// Run the passphrase command.
+func (c *Passphrase) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	dbCtx := appCtx.DB.NewContext()
+
+	switch kctx.Args[1] {
+	case "add":
+		passphrase, err := appCtx.ReadPassphrase("DISCO_NEW_PASSPHRASE", "New passphrase", true)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed reading new passphrase", err, "")
+		}
+		ks, err := addKeySlot(appCtx, passphrase, crypto.KDF(c.Add.KDF))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(appCtx.Stdout, "Added key slot %d\n", ks.ID)
+	case "change":
+		slotID := c.Change.Slot
+		if slotID == 0 {
+			slotID = appCtx.KeySlot
+		}
+		if slotID == 0 {
+			return errors.New("the encryption key wasn't unlocked with a passphrase; specify the key slot with --slot")
+		}
+
+		ks, err := loadKeySlot(appCtx, slotID)
+		if err != nil {
+			return err
+		}
+
+		passphrase, err := appCtx.ReadPassphrase("DISCO_NEW_PASSPHRASE", "New passphrase", true)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed reading new passphrase", err, "")
+		}
+		if err = ks.ChangePassphrase(appCtx.User.PrivateKey, passphrase); err != nil {
+			return aerrors.NewRuntimeError("failed changing passphrase", err, "")
+		}
+		if err = ks.Save(dbCtx, appCtx.DB, true); err != nil {
+			return err
+		}
+	case "rm":
+		slots, err := models.KeySlots(dbCtx, appCtx.DB)
+		if err != nil {
+			return err
+		}
+		// The encryption key was never shown if it's only unlocked with
+		// passphrases, so removing the last slot would lock the data away.
+		if len(slots) == 1 && appCtx.KeySlot != 0 {
+			return errors.New("can't delete the last key slot when unlocked with a passphrase")
+		}
+
+		ks := &models.KeySlot{ID: c.Rm.Slot}
+		if err = ks.Delete(dbCtx, appCtx.DB); err != nil {
+			return err
+		}
+	case "ls":
+		slots, err := models.KeySlots(dbCtx, appCtx.DB)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed listing key slots", err, "")
+		}
+
+		data := make([][]string, len(slots))
+		for i, ks := range slots {
+			data[i] = []string{
+				strconv.FormatUint(ks.ID, 10), string(ks.KDF.KDF), ks.KDF.String(),
+				ks.CreatedAt.Local().Format(time.DateTime),
+			}
+		}
+
+		if len(data) > 0 {
+			header := []string{"Slot", "KDF", "Parameters", "Created"}
+			newTable(header, data, appCtx.Stdout).Render()
+		}
+	}
+
+	return nil
+}
+
+// addKeySlot saves a new key slot that unlocks the encryption key with the
+// passphrase.
+func addKeySlot(appCtx *actx.Context, passphrase []byte, kdf crypto.KDF) (*models.KeySlot, error) {
+	ks, err := models.NewKeySlot(appCtx.User.PrivateKey, passphrase, kdf)
+	if err != nil {
+		return nil, aerrors.NewRuntimeError("failed creating key slot", err, "")
+	}
+	if err = ks.Save(appCtx.DB.NewContext(), appCtx.DB, false); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+func loadKeySlot(appCtx *actx.Context, id uint64) (*models.KeySlot, error) {
+	slots, err := models.KeySlots(appCtx.DB.NewContext(), appCtx.DB)
+	if err != nil {
+		return nil, err
+	}
+	for _, ks := range slots {
+		if ks.ID == id {
+			return ks, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key slot %d doesn't exist", id)
+}
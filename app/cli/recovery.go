@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/nacl/box"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/app/keyprovider"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/models"
+)
+
+// The Recovery command manages the recovery keys that allow recipients to
+// recover the encryption key with their private key.
+type Recovery struct {
+	Add struct {
+		Name      string `arg:"" help:"The unique name of the recovery key, e.g. the name of its recipient."`
+		PublicKey string `arg:"" help:"The X25519 public key of the recipient, as printed by 'disco recovery keygen'."`
+	} `kong:"cmd,help='Wrap the encryption key to the public key of a recovery recipient.'"`
+	Rm struct {
+		Name string `arg:"" help:"The name of the recovery key."`
+	} `kong:"cmd,help='Delete a recovery key.'"`
+	Ls struct {
+	} `kong:"cmd,help='List recovery keys.'"`
+	Keygen struct {
+	} `kong:"cmd,help='Generate a key pair for a recovery recipient.'"`
+	Unlock struct {
+		PrivateKey string `help:"The private key of the recovery recipient. \n It can be the value itself, or a key provider URI. If not specified, it's prompted for."`
+		Rotate     bool   `help:"Rotate the encryption key after recovering it, instead of printing it."`
+	} `kong:"cmd,help='Recover the encryption key with the private key of a recovery recipient.'"`
+}
+
+// Run the recovery command.
+func (c *Recovery) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	dbCtx := appCtx.DB.NewContext()
+
+	switch kctx.Args[1] {
+	case "add":
+		pubKey, err := crypto.DecodeKey(c.Add.PublicKey)
+		if err != nil {
+			return aerrors.NewRuntimeError("invalid public key", err, "")
+		}
+		rk, err := models.NewRecoveryKey(c.Add.Name, pubKey, appCtx.User.PrivateKey)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed creating recovery key", err, "")
+		}
+		if err = rk.Save(dbCtx, appCtx.DB, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(appCtx.Stdout, "Added recovery key '%s'\n", rk.Name)
+	case "rm":
+		rk := &models.RecoveryKey{Name: c.Rm.Name}
+		if err := rk.Delete(dbCtx, appCtx.DB); err != nil {
+			return err
+		}
+	case "ls":
+		keys, err := models.RecoveryKeys(dbCtx, appCtx.DB)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed listing recovery keys", err, "")
+		}
+
+		data := make([][]string, len(keys))
+		for i, rk := range keys {
+			data[i] = []string{
+				rk.Name, base58.Encode(rk.PublicKey[:]),
+				rk.CreatedAt.Local().Format(time.DateTime),
+			}
+		}
+
+		if len(data) > 0 {
+			header := []string{"Name", "Public Key", "Created"}
+			newTable(header, data, appCtx.Stdout).Render()
+		}
+	case "keygen":
+		pubKey, privKey, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed generating key pair", err, "")
+		}
+		fmt.Fprintf(appCtx.Stdout, `Private key: %s
+Public key: %s
+
+Store the private key offline in a secure location. Register the public key on
+each node with 'disco recovery add <name> <public key>'.
+`, base58.Encode(privKey[:]), base58.Encode(pubKey[:]))
+	case "unlock":
+		return c.unlock(appCtx)
+	}
+
+	return nil
+}
+
+func (c *Recovery) unlock(appCtx *actx.Context) error {
+	keys, err := models.RecoveryKeys(appCtx.DB.NewContext(), appCtx.DB)
+	if err != nil {
+		return aerrors.NewRuntimeError("failed loading recovery keys", err, "")
+	}
+	if len(keys) == 0 {
+		return aerrors.NewRuntimeError("no recovery keys exist", nil,
+			"Recovery keys must be added with 'disco recovery add' before they can be used.")
+	}
+
+	privKey, err := c.readPrivateKey(appCtx)
+	if err != nil {
+		return err
+	}
+	defer clear(privKey[:])
+
+	var encKey *[32]byte
+	for _, rk := range keys {
+		if encKey, err = rk.Unlock(privKey); err == nil {
+			appCtx.Logger.Debug("unlocked recovery key", "name", rk.Name)
+			break
+		}
+	}
+	if encKey == nil {
+		return aerrors.NewRuntimeError("the private key doesn't unlock any recovery key", nil, "")
+	}
+	if err = appCtx.VerifyEncryptionKey(encKey); err != nil {
+		return aerrors.NewRuntimeError("the recovery key doesn't unlock the encryption key", nil,
+			"It was likely added before the key was rotated outside of Disco.")
+	}
+
+	if c.Unlock.Rotate {
+		appCtx.User.PrivateKey = encKey
+		appCtx.Store.SetEncryptionKey(encKey)
+		return rotateKey(appCtx)
+	}
+
+	fmt.Fprintf(appCtx.Stdout, "Encryption key: %s\n", base58.Encode(encKey[:]))
+
+	return nil
+}
+
+// readPrivateKey returns the recipient's private key from the --private-key
+// option, or prompts for it.
+func (c *Recovery) readPrivateKey(appCtx *actx.Context) (*[32]byte, error) {
+	keyStr := c.Unlock.PrivateKey
+	if keyprovider.IsURI(keyStr) {
+		privKey, err := keyprovider.ReadKey(appCtx, keyStr)
+		if err != nil {
+			return nil, aerrors.NewRuntimeError("failed reading private key", err, "")
+		}
+		return privKey, nil
+	}
+
+	if keyStr == "" {
+		if !appCtx.IsTerminal() {
+			return nil, errors.New("no private key provided; pass it with --private-key or run in a terminal")
+		}
+		input, err := appCtx.PromptSecret("Recovery private key")
+		if err != nil {
+			return nil, aerrors.NewRuntimeError("failed reading private key", err, "")
+		}
+		keyStr = strings.TrimSpace(string(input))
+	}
+
+	privKey, err := crypto.DecodeKey(keyStr)
+	if err != nil {
+		return nil, aerrors.NewRuntimeError("invalid private key", err, "")
+	}
+
+	return privKey, nil
+}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 
 	"github.com/alecthomas/kong"
+	"github.com/mr-tron/base58"
 
 	actx "go.hackfix.me/disco/app/context"
 	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/app/keyprovider"
 	"go.hackfix.me/disco/core"
 	"go.hackfix.me/disco/crypto"
 	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/web/client"
+	"go.hackfix.me/disco/web/server/types"
 )
 
 // The Remote command manages remote Disco nodes.
@@ -20,14 +24,24 @@ type Remote struct {
 		Token   string `arg:"" help:"The invitation token used for authentication, generated by the remote node."`
 	} `kong:"cmd,help='Add a new remote node.'"`
 	Ls struct {
+		Remote string `help:"The remote Disco node to list remotes of."`
 	} `kong:"cmd,help='List remote nodes.'"`
 	Rm struct {
-		Name string `arg:"" help:"The unique name of the remote."`
+		Name   string `arg:"" help:"The unique name of the remote."`
+		Remote string `help:"The remote Disco node to delete the remote from."`
 	} `kong:"cmd,help='Delete a remote node.'"`
 	Update struct {
 		Name    string `arg:"" help:"The unique name of the remote."`
 		Address string `arg:"" help:"The remote address in 'host[:port]' format, where 'host' can be a DNS hostname or an IP address."`
 	} `kong:"cmd,help='Update a remote node.'"`
+	Seal struct {
+		Name string `arg:"" help:"The unique name of the remote."`
+	} `kong:"cmd,help='Seal a remote server, which drops its encryption key from memory.'"`
+	Unseal struct {
+		Name  string   `arg:"" help:"The unique name of the remote."`
+		Key   string   `help:"The encryption key of the remote node. \n It can be the value itself, or a key provider URI, e.g. cmd:<command>."`
+		Share []string `help:"A key share of the remote node. Can be specified multiple times. \n If neither a key nor shares are specified, shares are prompted for."`
+	} `kong:"cmd,help='Unseal a remote server started with --sealed.'"`
 }
 
 // Run the remote command.
@@ -41,11 +55,12 @@ func (r *Remote) Run(kctx *kong.Context, appCtx *actx.Context) error {
 			return err
 		}
 
-		tlsClientCertEnc, err := crypto.EncryptSymInMemory(response.TLSClientCert, appCtx.User.PrivateKey)
+		remotesKey := crypto.DeriveKey(appCtx.User.PrivateKey, crypto.KeyPurposeRemotes)
+		tlsClientCertEnc, err := crypto.EncryptSymInMemory(response.TLSClientCert, remotesKey)
 		if err != nil {
 			return fmt.Errorf("failed encrypting TLS client certificate: %w", err)
 		}
-		tlsClientKeyEnc, err := crypto.EncryptSymInMemory(response.TLSClientKey, appCtx.User.PrivateKey)
+		tlsClientKeyEnc, err := crypto.EncryptSymInMemory(response.TLSClientKey, remotesKey)
 		if err != nil {
 			return fmt.Errorf("failed encrypting TLS client private key: %w", err)
 		}
@@ -58,7 +73,7 @@ func (r *Remote) Run(kctx *kong.Context, appCtx *actx.Context) error {
 			return err
 		}
 	case "ls":
-		remotes, err := models.Remotes(dbCtx, appCtx.DB, nil)
+		remotes, err := r.remotes(appCtx)
 		if err != nil {
 			return aerrors.NewRuntimeError("failed listing remotes", err, "")
 		}
@@ -73,8 +88,142 @@ func (r *Remote) Run(kctx *kong.Context, appCtx *actx.Context) error {
 			newTable(header, data, appCtx.Stdout).Render()
 		}
 	case "rm":
+		rclient, err := remoteClient(appCtx, r.Rm.Remote)
+		if err != nil {
+			return err
+		}
+		if rclient != nil {
+			err = rclient.RemoteDelete(appCtx.Ctx, r.Rm.Name)
+		} else {
+			remote := &models.Remote{Name: r.Rm.Name}
+			err = remote.Delete(dbCtx, appCtx.DB)
+		}
+		if err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed deleting remote '%s'", r.Rm.Name), err, "")
+		}
 	case "update":
+	case "seal":
+		rclient, err := storeClient(appCtx, r.Seal.Name)
+		if err != nil {
+			return err
+		}
+		if _, err = rclient.SysSeal(appCtx.Ctx); err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed sealing remote '%s'", r.Seal.Name), err, "")
+		}
+	case "unseal":
+		return r.unseal(appCtx)
 	}
 
 	return nil
 }
+
+func (r *Remote) remotes(appCtx *actx.Context) ([]*types.Remote, error) {
+	rclient, err := remoteClient(appCtx, r.Ls.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if rclient != nil {
+		return rclient.RemoteList(appCtx.Ctx)
+	}
+
+	remotes, err := models.Remotes(appCtx.DB.NewContext(), appCtx.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Remote, len(remotes))
+	for i, rem := range remotes {
+		resp[i] = &types.Remote{Name: rem.Name, Address: rem.Address}
+	}
+
+	return resp, nil
+}
+
+func (r *Remote) unseal(appCtx *actx.Context) error {
+	rclient, err := storeClient(appCtx, r.Unseal.Name)
+	if err != nil {
+		return err
+	}
+
+	key := r.Unseal.Key
+	if keyprovider.IsURI(key) {
+		encKey, err := keyprovider.ReadKey(appCtx, key)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed reading encryption key", err, "")
+		}
+		key = base58.Encode(encKey[:])
+	}
+
+	shares := r.Unseal.Share
+	prompt := key == "" && len(shares) == 0
+	if prompt && !appCtx.IsTerminal() {
+		return aerrors.NewRuntimeError("no encryption key or key shares provided", nil,
+			"Pass them with --key or --share, or run in a terminal.")
+	}
+
+	for {
+		if prompt {
+			share, err := appCtx.PromptSecret("Key share")
+			if err != nil {
+				return aerrors.NewRuntimeError("failed reading key share", err, "")
+			}
+			shares = []string{string(share)}
+		}
+
+		status, err := rclient.SysUnseal(appCtx.Ctx, key, shares)
+		if err != nil {
+			return aerrors.NewRuntimeError(
+				fmt.Sprintf("failed unsealing remote '%s'", r.Unseal.Name), err, "")
+		}
+		if !status.Sealed {
+			fmt.Fprintf(appCtx.Stdout, "Remote '%s' is unsealed.\n", r.Unseal.Name)
+			return nil
+		}
+
+		fmt.Fprintf(appCtx.Stdout, "Provided %d of %d key shares.\n", status.Progress, status.Threshold)
+		if !prompt {
+			return nil
+		}
+	}
+}
+
+// storeClient returns a client for accessing the store of the remote node with
+// the given name. If name is empty, the client of the local agent is returned
+// if it's used instead of the encryption key, or nil otherwise, in which case
+// the local store should be accessed directly.
+func storeClient(appCtx *actx.Context, name string) (*client.Client, error) {
+	if name == "" && appCtx.AgentSocket != "" {
+		return client.NewAgent(appCtx.AgentSocket), nil
+	}
+
+	return remoteClient(appCtx, name)
+}
+
+// remoteClient returns a client for accessing the remote node with the given
+// name. If name is empty, it returns nil, in which case the local node should
+// be accessed directly.
+func remoteClient(appCtx *actx.Context, name string) (*client.Client, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	if appCtx.User.PrivateKey == nil {
+		return nil, aerrors.NewRuntimeError(
+			"the encryption key is required for accessing remote nodes", nil,
+			"Provide it with --encryption-key when the agent is running.")
+	}
+
+	r := &models.Remote{Name: name}
+	if err := r.Load(appCtx.DB.NewContext(), appCtx.DB); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := r.ClientTLSConfig(appCtx.User.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(r.Address, tlsConfig), nil
+}
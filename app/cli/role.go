@@ -3,6 +3,7 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"slices"
 	"strings"
 
@@ -12,13 +13,16 @@ import (
 	aerrors "go.hackfix.me/disco/app/errors"
 	"go.hackfix.me/disco/db/models"
 	"go.hackfix.me/disco/db/types"
+	"go.hackfix.me/disco/web/client"
 )
 
 // The Role command manages roles.
 type Role struct {
 	Add struct {
 		Name        string              `arg:"" help:"The unique name of the role."`
-		Permissions []models.Permission `arg:"" help:"Permissions to assign to the role. \n Permission format: \"<actions>:<namespaces>:<resource>:<target>\" \n Example: \"rwd:dev,prod:store:myapp/*\""`
+		Permissions []models.Permission `arg:"" help:"Permissions to assign to the role. \n Permission format: \"[!]<actions>:<namespaces>:<resource>:<target>[;<conditions>]\", where \"!\" denies the actions. \n Example: \"rwd:dev,prod:store:myapp/*;cidr=10.0.0.0/8\""`
+		CIDR        []netip.Prefix      `name:"cidr" help:"Only apply the permissions to requests from this IP range. Can be specified multiple times."`
+		Time        []models.TimeWindow `sep:"none" help:"Only apply the permissions within this time window. Can be specified multiple times. \n Format: \"<start>/<end>\" in RFC 3339 format, or \"[<days>] [<HH:MM>-<HH:MM>] [<time zone>]\". \n Example: \"Mon-Fri 09:00-17:00 Europe/Berlin\""`
 	} `kong:"cmd,help='Add a new role.'"`
 	Rm struct {
 		Name  string `arg:"" help:"The unique name of the role."`
@@ -26,24 +30,42 @@ type Role struct {
 	} `kong:"cmd,help='Remove a role.'"`
 	Update struct {
 		Name        string              `arg:"" help:"The unique name of the role."`
-		Permissions []models.Permission `arg:"" help:"Permissions to assign to the role. \n Any existing permissions will be removed and replaced with this set. \n Permission format: \"<actions>:<namespaces>:<resource>:<target>\" \n Example: \"rwd:dev,prod:store:myapp/*\""`
+		Permissions []models.Permission `arg:"" help:"Permissions to assign to the role. \n Any existing permissions will be removed and replaced with this set. \n Permission format: \"[!]<actions>:<namespaces>:<resource>:<target>[;<conditions>]\", where \"!\" denies the actions. \n Example: \"rwd:dev,prod:store:myapp/*;cidr=10.0.0.0/8\""`
+		CIDR        []netip.Prefix      `name:"cidr" help:"Only apply the permissions to requests from this IP range. Can be specified multiple times."`
+		Time        []models.TimeWindow `sep:"none" help:"Only apply the permissions within this time window. Can be specified multiple times. \n Format: \"<start>/<end>\" in RFC 3339 format, or \"[<days>] [<HH:MM>-<HH:MM>] [<time zone>]\". \n Example: \"Mon-Fri 09:00-17:00 Europe/Berlin\""`
 	} `kong:"cmd,help='Change the settings of a role.'"`
 	Ls struct {
 	} `kong:"cmd,help='List roles.'"`
+
+	Remote string `help:"The remote Disco node to manage roles on."`
 }
 
 // Run the role command.
 func (c *Role) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	rclient, err := remoteClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
 	dbCtx := appCtx.DB.NewContext()
 
 	switch kctx.Args[1] {
 	case "add":
-		role := &models.Role{Name: c.Add.Name, Permissions: c.Add.Permissions}
-		if err := role.Save(dbCtx, appCtx.DB, false); err != nil {
+		addConditions(c.Add.Permissions, c.Add.CIDR, c.Add.Time)
+		if rclient != nil {
+			err = rclient.RoleCreate(appCtx.Ctx, c.Add.Name, permissionTexts(c.Add.Permissions))
+		} else {
+			role := &models.Role{Name: c.Add.Name, Permissions: c.Add.Permissions}
+			err = role.Save(dbCtx, appCtx.DB, false)
+		}
+		if err != nil {
 			return aerrors.NewRuntimeError(
 				fmt.Sprintf("failed adding role '%s'", c.Add.Name), err, "")
 		}
 	case "rm":
+		if rclient != nil {
+			return rclient.RoleDelete(appCtx.Ctx, c.Rm.Name, c.Rm.Force)
+		}
+
 		role := &models.Role{Name: c.Rm.Name}
 		err := role.Delete(dbCtx, appCtx.DB, c.Rm.Force)
 
@@ -55,13 +77,19 @@ func (c *Role) Run(kctx *kong.Context, appCtx *actx.Context) error {
 
 		return err
 	case "update":
-		role := &models.Role{Name: c.Update.Name, Permissions: c.Update.Permissions}
-		if err := role.Save(dbCtx, appCtx.DB, true); err != nil {
+		addConditions(c.Update.Permissions, c.Update.CIDR, c.Update.Time)
+		if rclient != nil {
+			err = rclient.RoleUpdate(appCtx.Ctx, c.Update.Name, permissionTexts(c.Update.Permissions))
+		} else {
+			role := &models.Role{Name: c.Update.Name, Permissions: c.Update.Permissions}
+			err = role.Save(dbCtx, appCtx.DB, true)
+		}
+		if err != nil {
 			return aerrors.NewRuntimeError(
 				fmt.Sprintf("failed adding role '%s'", c.Update.Name), err, "")
 		}
 	case "ls":
-		roles, err := models.Roles(dbCtx, appCtx.DB, nil)
+		roles, err := c.roles(appCtx, rclient)
 		if err != nil {
 			return aerrors.NewRuntimeError("failed listing roles", err, "")
 		}
@@ -82,6 +110,9 @@ func (c *Role) Run(kctx *kong.Context, appCtx *actx.Context) error {
 				}
 				slices.Sort(actions)
 				actsJoined := strings.Join(actions, ",")
+				if perm.Deny {
+					actsJoined = "!" + actsJoined
+				}
 
 				target := fmt.Sprintf("%s:%s",
 					perm.Target.Resource, strings.Join(perm.Target.Patterns, ","))
@@ -91,7 +122,10 @@ func (c *Role) Run(kctx *kong.Context, appCtx *actx.Context) error {
 					target = "*"
 				}
 
-				row := []string{role.Name, nsJoined, actsJoined, target}
+				// Conditions are always valid, since they were parsed.
+				conditions, _ := perm.Conditions.MarshalText()
+
+				row := []string{role.Name, nsJoined, actsJoined, target, string(conditions)}
 				if i > 0 {
 					row[0] = ""
 				}
@@ -99,9 +133,53 @@ func (c *Role) Run(kctx *kong.Context, appCtx *actx.Context) error {
 			}
 		}
 
-		header := []string{"Name", "Namespaces", "Actions", "Target"}
+		header := []string{"Name", "Namespaces", "Actions", "Target", "Conditions"}
 		newTable(header, data, appCtx.Stdout).Render()
 	}
 
 	return nil
 }
+
+func (c *Role) roles(appCtx *actx.Context, rclient *client.Client) ([]*models.Role, error) {
+	if rclient == nil {
+		return models.Roles(appCtx.DB.NewContext(), appCtx.DB, nil)
+	}
+
+	remoteRoles, err := rclient.RoleList(appCtx.Ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]*models.Role, len(remoteRoles))
+	for i, r := range remoteRoles {
+		roles[i] = &models.Role{Name: r.Name, Permissions: make([]models.Permission, len(r.Permissions))}
+		for pi, perm := range r.Permissions {
+			if err = roles[i].Permissions[pi].UnmarshalText([]byte(perm)); err != nil {
+				return nil, fmt.Errorf("invalid permission '%s' of role '%s': %w", perm, r.Name, err)
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// addConditions adds the IP range and time window conditions to all
+// permissions.
+func addConditions(perms []models.Permission, cidrs []netip.Prefix, times []models.TimeWindow) {
+	for i := range perms {
+		perms[i].Conditions.CIDRs = append(perms[i].Conditions.CIDRs, cidrs...)
+		perms[i].Conditions.Times = append(perms[i].Conditions.Times, times...)
+	}
+}
+
+// permissionTexts returns the permissions in their text format.
+func permissionTexts(perms []models.Permission) []string {
+	texts := make([]string, len(perms))
+	for i, perm := range perms {
+		// Permissions parsed from the command line are always valid.
+		text, _ := perm.MarshalText()
+		texts[i] = string(text)
+	}
+
+	return texts
+}
@@ -0,0 +1,28 @@
+package cli
+
+import (
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/db/store"
+)
+
+// The Rollback command restores the value of a key from a previous version.
+type Rollback struct {
+	Key     string `arg:"" help:"The unique key associated with the value."`
+	Version int    `arg:"" help:"The version to restore. \n See the 'history' command for the available versions."`
+
+	Namespace string `default:"default" help:"The namespace of the key."`
+	Remote    string `help:"The remote Disco node to restore the value in."`
+}
+
+// Run the rollback command.
+func (c *Rollback) Run(appCtx *actx.Context) error {
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		return rclient.StoreRollback(appCtx.Ctx, c.Namespace, c.Key, c.Version)
+	}
+
+	return appCtx.Store.Rollback(c.Namespace, c.Key, c.Version, store.WithAuthor(appCtx.User.Name))
+}
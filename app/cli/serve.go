@@ -8,23 +8,73 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/app/sandbox"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/store"
 	"go.hackfix.me/disco/web/server"
 )
 
 // Serve starts the web server.
 type Serve struct {
-	Address string `help:"[host]:port to listen on" default:":2020"`
+	Address      string        `help:"[host]:port to listen on" default:":2020"`
+	ReapInterval time.Duration `help:"How often expired keys are deleted." default:"1m"`
+	NoSandbox    bool          `help:"Don't restrict filesystem access and syscalls of the server process. \n On Linux, the server is otherwise sandboxed with Landlock and seccomp after it starts listening."`
+	Sealed       bool          `help:"Start without the encryption key, and reject store requests until the server is unsealed via the API. \n This requires a TLS serving certificate and its private key to be stored unencrypted in the data directory, which is done the first time it's started with the encryption key. \n The key can't sign client certificates, but anyone who can read it can impersonate the server."`
+}
+
+// KeyRequired returns true if the encryption key must be read before running
+// the command. A sealed server only needs it the first time, for storing the
+// TLS serving certificate.
+func (s *Serve) KeyRequired(appCtx *actx.Context) bool {
+	if !s.Sealed {
+		return true
+	}
+	_, err := appCtx.FS.Stat(filepath.Join(appCtx.DataDir, actx.ServerTLSFile))
+	return err != nil
 }
 
 // Run the serve command.
 func (s *Serve) Run(appCtx *actx.Context) error {
-	srv, err := server.New(appCtx, s.Address)
+	if s.ReapInterval <= 0 {
+		return errors.New("reap interval must be a positive duration")
+	}
+
+	// The process holds the encryption key for as long as it runs.
+	if err := crypto.DisableCoreDumps(); err != nil {
+		appCtx.Logger.Warn("failed disabling core dumps", "error", err)
+	}
+
+	if s.Sealed && appCtx.User.PrivateKey != nil {
+		if err := appCtx.SaveServerTLSCert(); err != nil {
+			return err
+		}
+	}
+
+	srv, err := server.New(appCtx, s.Address, s.Sealed)
 	if err != nil {
 		return err
 	}
+	if s.Sealed {
+		appCtx.Logger.Info("server is sealed; unseal it with 'disco remote unseal'")
+	}
+
+	if err = srv.Listen(); err != nil {
+		return err
+	}
+	if err = s.sandbox(appCtx); err != nil {
+		return aerrors.NewRuntimeError("failed sandboxing the server", err,
+			"Run with --no-sandbox to start it without sandboxing.")
+	}
+
+	reapCtx, cancelReap := context.WithCancel(appCtx.Ctx)
+	defer cancelReap()
+	go reapExpiredKeys(reapCtx, appCtx.Store, s.ReapInterval)
 
 	// Gracefully shutdown the server if a process signal is received, or the
 	// main context is done.
@@ -57,3 +107,48 @@ func (s *Serve) Run(appCtx *actx.Context) error {
 
 	return nil
 }
+
+// sandbox restricts the process to accessing the data directory and the
+// encryption key file, unless sandboxing is disabled.
+func (s *Serve) sandbox(appCtx *actx.Context) error {
+	if s.NoSandbox {
+		appCtx.Logger.Debug("sandboxing is disabled")
+		return nil
+	}
+	// Sandboxing a process that uses an in-memory filesystem, i.e. in tests,
+	// would restrict the process running them.
+	if appCtx.FS.Name() == "MemoryFileSystem" {
+		return nil
+	}
+
+	cfg := sandbox.Config{ReadWritePaths: []string{appCtx.DataDir}}
+	if appCtx.KeyFile != "" {
+		cfg.ReadOnlyPaths = append(cfg.ReadOnlyPaths, appCtx.KeyFile)
+	}
+
+	return sandbox.Apply(cfg, appCtx.Logger)
+}
+
+// reapExpiredKeys periodically deletes expired keys from the store until the
+// context is done. Expired keys are already hidden from reads, so this only
+// reclaims their storage.
+func reapExpiredKeys(ctx context.Context, st store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := st.DeleteExpired()
+			if err != nil {
+				slog.Error("failed deleting expired keys", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Debug("deleted expired keys", "count", n)
+			}
+		}
+	}
+}
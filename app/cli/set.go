@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"time"
 
 	actx "go.hackfix.me/disco/app/context"
-	"go.hackfix.me/disco/db/models"
-	"go.hackfix.me/disco/web/client"
+	"go.hackfix.me/disco/db/store"
 )
 
 // The Set command stores the value of a key.
@@ -15,43 +15,49 @@ type Set struct {
 	Key   string `arg:"" help:"The unique key that identifies the value."`
 	Value string `arg:"" help:"The value."`
 
-	Namespace string `default:"default" help:"The namespace to store the value in."`
-	Remote    string `help:"The remote Disco node to store the value in."`
+	Namespace   string        `default:"default" help:"The namespace to store the value in.\n If '*' is specified, the value is stored in all existing namespaces. "`
+	ContentType string        `help:"The media type of the value. If not specified, it's detected from the value."`
+	TTL         time.Duration `help:"Time duration after which the key expires. If not specified, the key never expires."`
+	IfRevision  int           `help:"Only store the value if the current revision of the key is this one."`
+	CreateOnly  bool          `help:"Only store the value if the key doesn't exist."`
+	Remote      string        `help:"The remote Disco node to store the value in."`
 }
 
 // Run the set command.
 func (c *Set) Run(appCtx *actx.Context) error {
-	if c.Namespace == "*" {
-		// TODO: Add support for the wildcard namespace. I.e. set the value in
-		// all existing namespaces. This would require keeping a registry of
-		// existing namespaces.
-		return errors.New("namespace '*' is not supported for the set command")
-	}
-
 	var value io.Reader = bytes.NewReader([]byte(c.Value))
 	if c.Value == "-" {
 		value = appCtx.Stdin
 	}
 
+	if c.TTL < 0 {
+		return errors.New("TTL must be a positive duration")
+	}
+	if c.IfRevision < 0 {
+		return errors.New("revision must be a positive number")
+	}
+	if c.IfRevision > 0 && c.CreateOnly {
+		return errors.New("--if-revision and --create-only can't be used together")
+	}
+
+	opts := []store.SetOption{store.WithTTL(c.TTL), store.IfRevision(c.IfRevision)}
+	if c.CreateOnly {
+		opts = append(opts, store.CreateOnly())
+	}
+	if c.ContentType != "" {
+		opts = append(opts, store.WithContentType(c.ContentType))
+	}
+
 	var setErr error
-	if c.Remote != "" {
-		r := &models.Remote{Name: c.Remote}
-		if err := r.Load(appCtx.DB.NewContext(), appCtx.DB); err != nil {
-			return err
-		}
-
-		tlsConfig, err := r.ClientTLSConfig(appCtx.User.PrivateKey)
-		if err != nil {
-			return err
-		}
-
-		client := client.New(r.Address, tlsConfig)
-		setErr = client.StoreSet(appCtx.Ctx, c.Namespace, c.Key, value)
-		if err != nil {
-			return err
-		}
+	rclient, err := storeClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
+	if rclient != nil {
+		setErr = rclient.StoreSet(appCtx.Ctx, c.Namespace, c.Key, value, opts...)
 	} else {
-		setErr = appCtx.Store.Set(c.Namespace, c.Key, value)
+		opts = append(opts, store.WithAuthor(appCtx.User.Name))
+		setErr = appCtx.Store.Set(c.Namespace, c.Key, value, opts...)
 	}
 
 	return setErr
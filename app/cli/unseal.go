@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mandelsoft/vfs/pkg/vfs"
+	"github.com/mr-tron/base58"
+
+	actx "go.hackfix.me/disco/app/context"
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/crypto"
+)
+
+// The Unseal command reconstructs the encryption key from key shares.
+type Unseal struct {
+	Share   []string `help:"A key share. Can be specified multiple times. \n If fewer shares than the threshold are provided, they're kept until the next call."`
+	Reset   bool     `help:"Discard the key shares provided by previous calls."`
+	Command []string `arg:"" optional:"" passthrough:"" help:"The command to run with the reconstructed encryption key, e.g. 'serve'. \n If not specified, the encryption key is printed."`
+
+	key *[32]byte
+}
+
+// Run the unseal command.
+func (c *Unseal) Run(appCtx *actx.Context) error {
+	c.key = nil
+	if appCtx.VersionInit == "" {
+		return aerrors.NewRuntimeError("Disco is not initialized", nil,
+			"Did you forget to run 'disco init'?")
+	}
+
+	pendingPath := filepath.Join(appCtx.DataDir, "unseal-shares")
+	if c.Reset {
+		if err := appCtx.FS.Remove(pendingPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return aerrors.NewRuntimeError("failed removing key shares", err, "")
+		}
+	}
+
+	shares, err := loadPendingShares(appCtx, pendingPath)
+	if err != nil {
+		return err
+	}
+	pending := len(shares)
+	for _, s := range c.Share {
+		if shares, err = addKeyShare(shares, s); err != nil {
+			if pending > 0 {
+				return aerrors.NewRuntimeError("invalid key share", err,
+					"Run 'disco unseal --reset' to discard the shares provided before.")
+			}
+			return err
+		}
+	}
+
+	if len(c.Share) == 0 && appCtx.IsTerminal() {
+		for len(shares) == 0 || len(shares) < shares[0].Threshold {
+			prompt := "Key share"
+			if len(shares) > 0 {
+				prompt = fmt.Sprintf("Key share (%d of %d)", len(shares)+1, shares[0].Threshold)
+			}
+			s, err := appCtx.PromptSecret(prompt)
+			if err != nil {
+				return aerrors.NewRuntimeError("failed reading key share", err, "")
+			}
+			if shares, err = addKeyShare(shares, string(s)); err != nil {
+				fmt.Fprintf(appCtx.Stderr, "Error: %s\n", err)
+			}
+		}
+	}
+
+	if len(shares) == 0 {
+		return aerrors.NewRuntimeError("no key shares provided", nil,
+			"Pass them with --share, or run in a terminal.")
+	}
+
+	if len(shares) < shares[0].Threshold {
+		// Fewer shares than the threshold reveal nothing about the key, so it's
+		// safe to keep them on disk until the remaining ones are provided.
+		if err = savePendingShares(appCtx, pendingPath, shares); err != nil {
+			return err
+		}
+		fmt.Fprintf(appCtx.Stdout,
+			"Provided %d of %d key shares. Run 'disco unseal' again with another share.\n",
+			len(shares), shares[0].Threshold)
+		return nil
+	}
+
+	// The shares are consumed regardless of the outcome, since it's not
+	// possible to tell which one is invalid.
+	if err = appCtx.FS.Remove(pendingPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return aerrors.NewRuntimeError("failed removing key shares", err, "")
+	}
+
+	key, err := crypto.CombineKeyShares(shares)
+	if err != nil {
+		return aerrors.NewRuntimeError("failed combining key shares", err, "")
+	}
+	if err = appCtx.VerifyEncryptionKey(key); err != nil {
+		return aerrors.NewRuntimeError("the key shares don't reconstruct the encryption key",
+			nil, "Make sure that the shares were issued by this node, and weren't superseded by 'disco key rotate'.")
+	}
+
+	if len(c.Command) == 0 {
+		fmt.Fprintf(appCtx.Stdout, "Encryption key: %s\n", base58.Encode(key[:]))
+		return nil
+	}
+	c.key = crypto.LockKey(key)
+
+	return nil
+}
+
+// Unsealed returns the reconstructed encryption key and the command that should
+// be run with it, if the key shares reached the threshold.
+func (c *Unseal) Unsealed() (*[32]byte, []string) {
+	return c.key, c.Command
+}
+
+// addKeyShare parses the key share, and adds it to shares if it belongs to the
+// same set and wasn't added already.
+func addKeyShare(shares []*crypto.KeyShare, share string) ([]*crypto.KeyShare, error) {
+	ks, err := crypto.ParseKeyShare(strings.TrimSpace(share))
+	if err != nil {
+		return shares, err
+	}
+	if len(shares) > 0 && ks.SetID != shares[0].SetID {
+		return shares, errors.New("the key share belongs to a different set than the other shares")
+	}
+	if slices.ContainsFunc(shares, ks.Equal) {
+		return shares, nil
+	}
+
+	return append(shares, ks), nil
+}
+
+func loadPendingShares(appCtx *actx.Context, path string) ([]*crypto.KeyShare, error) {
+	data, err := vfs.ReadFile(appCtx.FS, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, aerrors.NewRuntimeError("failed reading key shares", err, "")
+	}
+
+	var shares []*crypto.KeyShare
+	for _, s := range strings.Fields(string(data)) {
+		if shares, err = addKeyShare(shares, s); err != nil {
+			return nil, aerrors.NewRuntimeError("failed reading key shares", err,
+				"Run 'disco unseal --reset' to discard them.")
+		}
+	}
+
+	return shares, nil
+}
+
+func savePendingShares(appCtx *actx.Context, path string, shares []*crypto.KeyShare) error {
+	var data strings.Builder
+	for _, ks := range shares {
+		data.WriteString(ks.String() + "\n")
+	}
+	if err := vfs.WriteFile(appCtx.FS, path, []byte(data.String()), 0o600); err != nil {
+		return aerrors.NewRuntimeError("failed saving key shares", err, "")
+	}
+
+	return nil
+}
+
+// printKeyShares splits the encryption key into shares, and prints them.
+func printKeyShares(appCtx *actx.Context, shares, threshold int) error {
+	encoded, err := crypto.SplitKey(appCtx.User.PrivateKey, shares, threshold)
+	if err != nil {
+		return aerrors.NewRuntimeError("failed splitting encryption key", err, "")
+	}
+
+	fmt.Fprintf(appCtx.Stdout, "Key shares (%d of %d are required to reconstruct the encryption key):\n\n",
+		threshold, shares)
+	for i, s := range encoded {
+		fmt.Fprintf(appCtx.Stdout, "Share %d: %s\n", i+1, s)
+	}
+	fmt.Fprint(appCtx.Stdout, `
+Give each share to a different person, and make sure they store it in a secure
+location. The shares will only be shown once, and you won't be able to access the
+data on this node if fewer than the threshold remain!
+`)
+
+	return nil
+}
@@ -27,80 +27,84 @@ type User struct {
 	} `kong:"cmd,help='Update the configuration of a user.'"`
 	Ls struct {
 	} `kong:"cmd,help='List users.'"`
+
+	Remote string `help:"The remote Disco node to manage users on."`
 }
 
 // Run the user command.
 func (c *User) Run(kctx *kong.Context, appCtx *actx.Context) error {
+	rclient, err := remoteClient(appCtx, c.Remote)
+	if err != nil {
+		return err
+	}
 	dbCtx := appCtx.DB.NewContext()
 
 	switch kctx.Args[1] {
 	case "add":
-		var roles []*models.Role
-		for _, roleName := range c.Add.Roles {
-			role := &models.Role{Name: roleName}
-			if err := role.Load(dbCtx, appCtx.DB); err != nil {
-				return err
-			}
-			roles = append(roles, role)
+		if rclient != nil {
+			err = rclient.UserCreate(appCtx.Ctx, c.Add.Name, c.Add.Roles)
+		} else {
+			err = saveUser(appCtx, c.Add.Name, c.Add.Roles, false)
 		}
-
-		user := &models.User{Name: c.Add.Name,
-			// Only remote users can be added for now.
-			Type: models.UserTypeRemote, Roles: roles}
-		if err := user.Save(dbCtx, appCtx.DB, false); err != nil {
+		if err != nil {
 			return aerrors.NewRuntimeError(
 				fmt.Sprintf("failed adding user '%s'", c.Add.Name), err, "")
 		}
 
-		if len(roles) == 0 {
+		if len(c.Add.Roles) == 0 {
 			appCtx.Logger.Warn(fmt.Sprintf(
 				"user '%s' has no assigned roles and won't be able to "+
 					"access any resources", c.Add.Name))
 		}
 	case "rm":
+		if rclient != nil {
+			return rclient.UserDelete(appCtx.Ctx, c.Rm.Name)
+		}
 		user := &models.User{Name: c.Rm.Name}
 		err := user.Delete(dbCtx, appCtx.DB)
 		if err != nil {
 			return err
 		}
 	case "update":
-		var roles []*models.Role
-		for _, roleName := range c.Update.Roles {
-			role := &models.Role{Name: roleName}
-			if err := role.Load(dbCtx, appCtx.DB); err != nil {
-				return err
-			}
-			roles = append(roles, role)
+		if rclient != nil {
+			err = rclient.UserUpdate(appCtx.Ctx, c.Update.Name, c.Update.Roles)
+		} else {
+			err = saveUser(appCtx, c.Update.Name, c.Update.Roles, true)
 		}
-
-		user := &models.User{Name: c.Update.Name,
-			// Only remote users can be updated for now.
-			Type: models.UserTypeRemote, Roles: roles}
-		if err := user.Save(dbCtx, appCtx.DB, true); err != nil {
+		if err != nil {
 			return aerrors.NewRuntimeError(
 				fmt.Sprintf("failed updating user '%s'", c.Update.Name), err, "")
 		}
 
-		if len(roles) == 0 {
+		if len(c.Update.Roles) == 0 {
 			appCtx.Logger.Warn(fmt.Sprintf(
 				"user '%s' has no assigned roles and won't be able to "+
 					"access any resources", c.Update.Name))
 		}
 	case "ls":
-		users, err := models.Users(dbCtx, appCtx.DB,
-			// Local users are hidden for now.
-			types.NewFilter("u.type != ?", []any{models.UserTypeLocal}))
-		if err != nil {
-			return aerrors.NewRuntimeError("failed listing users", err, "")
-		}
-
-		data := make([][]string, len(users))
-		for i, user := range users {
-			roles := make([]string, len(user.Roles))
-			for ri, role := range user.Roles {
-				roles[ri] = role.Name
+		var data [][]string
+		if rclient != nil {
+			users, err := rclient.UserList(appCtx.Ctx)
+			if err != nil {
+				return aerrors.NewRuntimeError("failed listing users", err, "")
+			}
+			for _, user := range users {
+				data = append(data, []string{user.Name, strings.Join(user.Roles, ",")})
+			}
+		} else {
+			users, err := models.Users(dbCtx, appCtx.DB,
+				// Local users are hidden for now.
+				types.NewFilter("u.type != ?", []any{models.UserTypeLocal}))
+			if err != nil {
+				return aerrors.NewRuntimeError("failed listing users", err, "")
+			}
+			for _, user := range users {
+				roles := make([]string, len(user.Roles))
+				for ri, role := range user.Roles {
+					roles[ri] = role.Name
+				}
+				data = append(data, []string{user.Name, strings.Join(roles, ",")})
 			}
-			data[i] = []string{user.Name, strings.Join(roles, ",")}
 		}
 
 		if len(data) > 0 {
@@ -111,3 +115,22 @@ func (c *User) Run(kctx *kong.Context, appCtx *actx.Context) error {
 
 	return nil
 }
+
+// saveUser creates or updates a remote user in the local database.
+func saveUser(appCtx *actx.Context, name string, roleNames []string, update bool) error {
+	dbCtx := appCtx.DB.NewContext()
+	var roles []*models.Role
+	for _, roleName := range roleNames {
+		role := &models.Role{Name: roleName}
+		if err := role.Load(dbCtx, appCtx.DB); err != nil {
+			return err
+		}
+		roles = append(roles, role)
+	}
+
+	user := &models.User{Name: name,
+		// Only remote users can be added for now.
+		Type: models.UserTypeRemote, Roles: roles}
+
+	return user.Save(dbCtx, appCtx.DB, update)
+}
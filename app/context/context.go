@@ -3,13 +3,18 @@ package context
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/mandelsoft/vfs/pkg/vfs"
 	"github.com/mr-tron/base58"
+	"golang.org/x/term"
 
 	aerrors "go.hackfix.me/disco/app/errors"
 	"go.hackfix.me/disco/crypto"
@@ -40,6 +45,13 @@ type Context struct {
 	DB    *db.DB
 	Store store.Store
 	User  *models.User // current app user
+	// ID of the key slot the encryption key was unlocked with, if any.
+	KeySlot uint64
+	// Path of the file the encryption key was read from, if any.
+	KeyFile string
+	// Path of the Unix socket of the agent that store operations are sent to
+	// instead of using the encryption key, if any.
+	AgentSocket string
 
 	// Metadata
 	Version     *VersionInfo
@@ -75,43 +87,171 @@ func (c *Context) LoadLocalUser(encKey *[32]byte) error {
 	}
 
 	if encKey != nil {
-		privKeyHash, privKeyErr := queries.GetEncryptionPrivKeyHash(c.DB.NewContext(), c.DB)
-		if privKeyErr != nil || !privKeyHash.Valid {
-			return aerrors.NewRuntimeError("missing encryption key hash",
-				privKeyErr, "Did you forget to run 'disco init'?")
+		if err = c.VerifyEncryptionKey(encKey); err != nil {
+			return err
 		}
+		c.User.PrivateKey = encKey
+	}
 
-		inPrivKeyHash := crypto.Hash("encryption key hash", encKey[:])
-		inPrivKeyHashEnc := base58.Encode(inPrivKeyHash)
-		if privKeyHash.V != inPrivKeyHashEnc {
-			return aerrors.NewRuntimeError("invalid encryption key", errors.New("hash mismatch"), "")
-		}
+	return nil
+}
 
-		c.User.PrivateKey = encKey
+// VerifyEncryptionKey checks the encryption key against the stored hash.
+func (c *Context) VerifyEncryptionKey(encKey *[32]byte) error {
+	privKeyHash, err := queries.GetEncryptionPrivKeyHash(c.DB.NewContext(), c.DB)
+	if err != nil || !privKeyHash.Valid {
+		return aerrors.NewRuntimeError("missing encryption key hash",
+			err, "Did you forget to run 'disco init'?")
+	}
+
+	inPrivKeyHash := crypto.KeyHash(encKey)
+	inPrivKeyHashEnc := base58.Encode(inPrivKeyHash)
+	if privKeyHash.V != inPrivKeyHashEnc {
+		return aerrors.NewRuntimeError("invalid encryption key", errors.New("hash mismatch"), "")
 	}
 
 	return nil
 }
 
-// ServerTLSInfo returns the TLS certificate, private key and Subject
-// Alternative Name used by the server.
+// ReadPassphrase reads a passphrase from the envVar environment variable, or
+// prompts for it if stdin is a terminal. If confirm is true, the passphrase
+// must be entered twice.
+func (c *Context) ReadPassphrase(envVar, prompt string, confirm bool) ([]byte, error) {
+	if pass := c.Env.Get(envVar); pass != "" {
+		return []byte(pass), nil
+	}
+
+	if !c.IsTerminal() {
+		return nil, fmt.Errorf("no passphrase provided; set %s or run in a terminal", envVar)
+	}
+
+	pass, err := c.PromptSecret(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading passphrase: %w", err)
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("the passphrase is empty")
+	}
+
+	if confirm {
+		passConfirm, err := c.PromptSecret("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed reading passphrase: %w", err)
+		}
+		if string(pass) != string(passConfirm) {
+			return nil, errors.New("the passphrases don't match")
+		}
+	}
+
+	return pass, nil
+}
+
+// IsTerminal returns true if stdin is a terminal.
+func (c *Context) IsTerminal() bool {
+	f, ok := c.Stdin.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// PromptSecret prompts for a secret on stderr, and reads it from stdin without
+// echoing it. Stdin must be a terminal.
+func (c *Context) PromptSecret(prompt string) ([]byte, error) {
+	f, ok := c.Stdin.(*os.File)
+	if !ok {
+		return nil, errors.New("stdin is not a terminal")
+	}
+
+	fmt.Fprintf(c.Stderr, "%s: ", prompt)
+	secret, err := term.ReadPassword(int(f.Fd()))
+	fmt.Fprintln(c.Stderr)
+
+	return secret, err
+}
+
+// ServerTLSFile is the name of the file in the data directory that contains the
+// certificate and unencrypted private key the server uses for serving TLS
+// connections when it's started without the encryption key. The certificate is
+// signed by the server CA certificate, whose private key remains encrypted,
+// since it can sign client certificates for any user.
+const ServerTLSFile = "server-tls.pem"
+
+// legacyServerTLSKeyFile is the file where older versions stored the
+// unencrypted private key of the server CA certificate.
+const legacyServerTLSKeyFile = "server-tls.key"
+
+// ServerTLSInfo returns the TLS certificate the server uses for serving
+// connections, the PEM encoded CA certificate that signs client certificates,
+// and the Subject Alternative Name of the server. If the encryption key is
+// loaded, the CA certificate itself is returned, which can also sign client
+// certificates; otherwise the serving certificate is read from ServerTLSFile.
 func (c *Context) ServerTLSInfo() (
-	cert *tls.Certificate, certPEM []byte, san string, err error,
+	cert *tls.Certificate, caCertPEM []byte, san string, err error,
 ) {
 	certPEMNull, privKeyEncNull, sanNull, err := queries.GetServerTLSInfo(c.DB.NewContext(), c.DB)
 	if err != nil {
 		return nil, nil, "", err
 	}
 
-	privKey, err := crypto.DecryptSymInMemory(privKeyEncNull.V, c.User.PrivateKey)
-	if err != nil {
-		return nil, nil, "", fmt.Errorf("failed decrypting server TLS private key: %w", err)
+	var certPEM, privKey []byte
+	if c.User.PrivateKey != nil {
+		tlsKey := crypto.DeriveKey(c.User.PrivateKey, crypto.KeyPurposeServerTLS)
+		privKey, err = crypto.DecryptSymInMemory(privKeyEncNull.V, tlsKey)
+		crypto.WipeKey(tlsKey)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed decrypting server TLS private key: %w", err)
+		}
+		certPEM = []byte(certPEMNull.V)
+	} else {
+		// The file contains both the certificate and the private key, and
+		// each is parsed from the blocks of its own type.
+		data, err := vfs.ReadFile(c.FS, filepath.Join(c.DataDir, ServerTLSFile))
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed reading server TLS certificate: %w", err)
+		}
+		certPEM, privKey = data, data
 	}
+	defer crypto.Wipe(privKey)
 
-	certPair, err := tls.X509KeyPair([]byte(certPEMNull.V), privKey)
+	certPair, err := tls.X509KeyPair(certPEM, privKey)
 	if err != nil {
 		return nil, nil, "", fmt.Errorf("failed parsing PEM encoded TLS certificate: %w", err)
 	}
 
 	return &certPair, []byte(certPEMNull.V), sanNull.V, nil
 }
+
+// SaveServerTLSCert creates a certificate for serving TLS connections, signed
+// by the server CA certificate, and writes it along with its unencrypted
+// private key to ServerTLSFile, which is only readable by the current user. The
+// encryption key must be loaded. The private key of the CA certificate stored
+// by older versions is removed.
+func (c *Context) SaveServerTLSCert() error {
+	caCert, _, san, err := c.ServerTLSInfo()
+	if err != nil {
+		return err
+	}
+	x509CA, err := x509.ParseCertificate(caCert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed parsing server TLS certificate: %w", err)
+	}
+
+	certPEM, privKey, err := crypto.NewTLSServingCert(
+		x509CA.Subject.CommonName, []string{san}, x509CA.NotAfter, caCert)
+	if err != nil {
+		return fmt.Errorf("failed generating server TLS serving certificate: %w", err)
+	}
+	data := append(certPEM, privKey...)
+	defer crypto.Wipe(privKey)
+	defer crypto.Wipe(data)
+
+	err = vfs.WriteFile(c.FS, filepath.Join(c.DataDir, ServerTLSFile), data, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed writing server TLS certificate: %w", err)
+	}
+
+	err = c.FS.Remove(filepath.Join(c.DataDir, legacyServerTLSKeyFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed removing unencrypted server TLS private key: %w", err)
+	}
+
+	return nil
+}
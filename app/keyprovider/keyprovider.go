@@ -0,0 +1,223 @@
+// Package keyprovider reads keys from external sources, configured by URI.
+//
+// The supported URIs are:
+//   - file:<path>: the contents of a file.
+//   - env:<name>: the value of an environment variable.
+//   - fd:<number>: the data read from an open file descriptor, e.g. fd:3.
+//   - cmd:<command> [<args>...]: the standard output of a command, so that
+//     keys can be fetched from a KMS or password manager with a wrapper script.
+//   - systemd-creds:<name>: a systemd service credential, read from the
+//     directory in $CREDENTIALS_DIRECTORY. The name defaults to
+//     "disco-encryption-key".
+//
+// Providers return the raw data read from the source, so they can be used for
+// reading any key or secret, not only the encryption key. KeyFunc reads the key
+// from the source each time it's needed instead, which the store uses for
+// unwrapping data keys, so that it's not held in memory in between.
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/vfs/pkg/vfs"
+
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/crypto"
+)
+
+// Provider reads a key from an external source.
+type Provider interface {
+	// Read returns the data read from the source.
+	Read(ctx context.Context) ([]byte, error)
+}
+
+// DefaultCredentialName is the name of the systemd credential read when it's
+// not specified in the URI.
+const DefaultCredentialName = "disco-encryption-key"
+
+var providers = map[string]func(appCtx *actx.Context, arg string) (Provider, error){
+	"file": func(appCtx *actx.Context, arg string) (Provider, error) {
+		if arg == "" {
+			return nil, errors.New("the file path is empty")
+		}
+		return &fileProvider{fs: appCtx.FS, path: arg}, nil
+	},
+	"env": func(appCtx *actx.Context, arg string) (Provider, error) {
+		if arg == "" {
+			return nil, errors.New("the environment variable name is empty")
+		}
+		return &envProvider{env: appCtx.Env, name: arg}, nil
+	},
+	"fd": func(_ *actx.Context, arg string) (Provider, error) {
+		fd, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor '%s'", arg)
+		}
+		return &fdProvider{fd: uintptr(fd)}, nil
+	},
+	"cmd": func(_ *actx.Context, arg string) (Provider, error) {
+		args := strings.Fields(arg)
+		if len(args) == 0 {
+			return nil, errors.New("the command is empty")
+		}
+		return &cmdProvider{args: args}, nil
+	},
+	"systemd-creds": func(appCtx *actx.Context, arg string) (Provider, error) {
+		if arg == "" {
+			arg = DefaultCredentialName
+		}
+		if strings.ContainsRune(arg, '/') {
+			return nil, fmt.Errorf("invalid credential name '%s'", arg)
+		}
+		dir := appCtx.Env.Get("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return nil, errors.New("$CREDENTIALS_DIRECTORY is not set; is the service running under systemd?")
+		}
+		return &fileProvider{fs: appCtx.FS, path: filepath.Join(dir, arg)}, nil
+	},
+}
+
+// IsURI returns true if s is a URI with a supported scheme.
+func IsURI(s string) bool {
+	scheme, _, found := strings.Cut(s, ":")
+	_, ok := providers[scheme]
+	return found && ok
+}
+
+// New returns the provider configured by the URI.
+func New(appCtx *actx.Context, uri string) (Provider, error) {
+	scheme, arg, found := strings.Cut(uri, ":")
+	newProvider, ok := providers[scheme]
+	if !found || !ok {
+		return nil, fmt.Errorf("unsupported key provider '%s'", scheme)
+	}
+
+	return newProvider(appCtx, arg)
+}
+
+// ReadKey reads a base58 encoded 32-byte key from the source the URI refers to.
+func ReadKey(appCtx *actx.Context, uri string) (*[32]byte, error) {
+	p, err := New(appCtx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKey(appCtx.Ctx, p)
+}
+
+// KeyFunc returns a function that reads a base58 encoded 32-byte key from the
+// source the URI refers to each time it's called. An error is returned for file
+// descriptors, since they can only be read once.
+func KeyFunc(appCtx *actx.Context, uri string) (func(ctx context.Context) (*[32]byte, error), error) {
+	p, err := New(appCtx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.(*fdProvider); ok {
+		return nil, errors.New("a file descriptor can only be read once")
+	}
+
+	return func(ctx context.Context) (*[32]byte, error) {
+		return readKey(ctx, p)
+	}, nil
+}
+
+func readKey(ctx context.Context, p Provider) (*[32]byte, error) {
+	data, err := p.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	return crypto.DecodeKey(string(bytes.TrimSpace(data)))
+}
+
+// Path returns the path of the file the URI refers to, or an empty string if
+// the provider doesn't read from a file.
+func Path(appCtx *actx.Context, uri string) string {
+	p, err := New(appCtx, uri)
+	if err != nil {
+		return ""
+	}
+	if fp, ok := p.(*fileProvider); ok {
+		return fp.path
+	}
+	return ""
+}
+
+type fileProvider struct {
+	fs   vfs.FileSystem
+	path string
+}
+
+func (p *fileProvider) Read(_ context.Context) ([]byte, error) {
+	data, err := vfs.ReadFile(p.fs, p.path)
+	if err != nil {
+		// The path is already part of the message.
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return nil, fmt.Errorf("failed reading key file '%s': %w", p.path, err)
+	}
+	return data, nil
+}
+
+type envProvider struct {
+	env  actx.Environment
+	name string
+}
+
+func (p *envProvider) Read(_ context.Context) ([]byte, error) {
+	val := p.env.Get(p.name)
+	if val == "" {
+		return nil, fmt.Errorf("environment variable '%s' is not set", p.name)
+	}
+	return []byte(val), nil
+}
+
+type fdProvider struct {
+	fd uintptr
+}
+
+func (p *fdProvider) Read(_ context.Context) ([]byte, error) {
+	f := os.NewFile(p.fd, fmt.Sprintf("fd%d", p.fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", p.fd)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading from file descriptor %d: %w", p.fd, err)
+	}
+	return data, nil
+}
+
+type cmdProvider struct {
+	args []string
+}
+
+func (p *cmdProvider) Read(ctx context.Context) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.args[0], p.args[1:]...) //nolint:gosec // Configured by the user.
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("failed running key command '%s': %w", p.args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,23 @@
+// Package sandbox restricts the privileges of the current process, so that a
+// compromised process can't access more than it needs.
+//
+// On Linux, a Landlock ruleset confines filesystem access to the configured
+// paths, and denies binding and connecting TCP sockets, and a seccomp filter
+// blocks syscalls that a server never needs, such as those for running
+// programs, tracing other processes, or changing the system configuration.
+// On other platforms, Apply does nothing.
+//
+// The restrictions apply to all threads of the process, and can't be lifted
+// once applied, so Apply should be called after all resources that are outside
+// of the sandbox, e.g. listening sockets, are acquired.
+package sandbox
+
+// Config lists the resources the process is allowed to access after it's
+// sandboxed.
+type Config struct {
+	// ReadWritePaths are files and directories the process can read and
+	// write. Access to directories extends to everything beneath them.
+	ReadWritePaths []string
+	// ReadOnlyPaths are files and directories the process can only read.
+	ReadOnlyPaths []string
+}
@@ -0,0 +1,314 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apply sandboxes the process with Landlock and seccomp. If the kernel doesn't
+// support Landlock, filesystem and network access is left unrestricted, and a
+// warning is logged.
+func Apply(cfg Config, logger *slog.Logger) error {
+	// The fallbacks for when the restrictions can't be applied to all threads
+	// at once need to run on the same thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Required for unprivileged processes to use Landlock and seccomp, and
+	// prevents regaining privileges via setuid binaries.
+	if err := allThreadsPrctl(unix.PR_SET_NO_NEW_PRIVS, 1); err != nil {
+		return fmt.Errorf("failed setting no_new_privs: %w", err)
+	}
+
+	if err := restrictAccess(cfg, logger); err != nil {
+		return err
+	}
+
+	return restrictSyscalls(logger)
+}
+
+// Filesystem access rights supported by each Landlock ABI version.
+const (
+	landlockAccessFSABI1     = unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1
+	landlockAccessFSRefer    = 0x2000 // ABI 2
+	landlockAccessFSTruncate = 0x4000 // ABI 3
+)
+
+const (
+	// Rights that only apply to files, and are the only ones that can be
+	// granted on them.
+	landlockAccessFile = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		landlockAccessFSTruncate
+	landlockAccessRead = unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// Rights that aren't granted even on read-write paths.
+	landlockAccessDenied = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK
+)
+
+// restrictAccess confines filesystem access to the configured paths, and denies
+// binding and connecting TCP sockets, using the rights supported by the
+// kernel's Landlock ABI version.
+func restrictAccess(cfg Config, logger *slog.Logger) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+			logger.Warn("Landlock is not supported by the kernel; filesystem access is unrestricted")
+			return nil
+		}
+		return fmt.Errorf("failed getting Landlock ABI version: %w", errno)
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: landlockAccessFSABI1}
+	if abi >= 2 {
+		attr.Access_fs |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		attr.Access_fs |= landlockAccessFSTruncate
+	}
+	if abi >= 4 {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed creating Landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	rwAccess := attr.Access_fs &^ landlockAccessDenied
+	for _, path := range cfg.ReadWritePaths {
+		if err := addPathRule(int(fd), path, rwAccess, logger); err != nil {
+			return err
+		}
+	}
+	for _, path := range cfg.ReadOnlyPaths {
+		if err := addPathRule(int(fd), path, landlockAccessRead, logger); err != nil {
+			return err
+		}
+	}
+
+	if err := allThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			// AllThreadsSyscall isn't supported when cgo is used, and
+			// restricting only some threads would be pointless.
+			logger.Warn("Landlock requires a build without cgo; filesystem access is unrestricted")
+			return nil
+		}
+		return fmt.Errorf("failed enforcing Landlock ruleset: %w", err)
+	}
+
+	logger.Debug("restricted filesystem access", "landlock_abi", abi,
+		"read_write", cfg.ReadWritePaths, "read_only", cfg.ReadOnlyPaths)
+	if attr.Access_net != 0 {
+		logger.Debug("denied binding and connecting TCP sockets")
+	}
+
+	return nil
+}
+
+// addPathRule allows access to the path and everything beneath it. Only
+// file rights are granted if the path is not a directory.
+func addPathRule(rulesetFD int, path string, access uint64, logger *slog.Logger) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			logger.Debug("skipping sandbox rule for missing path", "path", path)
+			return nil
+		}
+		return fmt.Errorf("failed opening '%s': %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err = unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("failed reading '%s': %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockAccessFile
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFD),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed adding Landlock rule for '%s': %w", path, errno)
+	}
+
+	return nil
+}
+
+// Syscalls that are blocked on all architectures. Syscalls that only exist on
+// some architectures are listed in archDeniedSyscalls.
+var deniedSyscalls = map[string]uintptr{
+	// Running programs
+	"execve":   unix.SYS_EXECVE,
+	"execveat": unix.SYS_EXECVEAT,
+	// Inspecting and modifying other processes
+	"ptrace":            unix.SYS_PTRACE,
+	"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+	"kcmp":              unix.SYS_KCMP,
+	// Namespaces and mounts
+	"unshare":       unix.SYS_UNSHARE,
+	"setns":         unix.SYS_SETNS,
+	"mount":         unix.SYS_MOUNT,
+	"umount2":       unix.SYS_UMOUNT2,
+	"pivot_root":    unix.SYS_PIVOT_ROOT,
+	"chroot":        unix.SYS_CHROOT,
+	"open_tree":     unix.SYS_OPEN_TREE,
+	"move_mount":    unix.SYS_MOVE_MOUNT,
+	"fsopen":        unix.SYS_FSOPEN,
+	"fsconfig":      unix.SYS_FSCONFIG,
+	"fsmount":       unix.SYS_FSMOUNT,
+	"fspick":        unix.SYS_FSPICK,
+	"mount_setattr": unix.SYS_MOUNT_SETATTR,
+	// Kernel and system configuration
+	"reboot":          unix.SYS_REBOOT,
+	"kexec_load":      unix.SYS_KEXEC_LOAD,
+	"kexec_file_load": unix.SYS_KEXEC_FILE_LOAD,
+	"init_module":     unix.SYS_INIT_MODULE,
+	"finit_module":    unix.SYS_FINIT_MODULE,
+	"delete_module":   unix.SYS_DELETE_MODULE,
+	"swapon":          unix.SYS_SWAPON,
+	"swapoff":         unix.SYS_SWAPOFF,
+	"acct":            unix.SYS_ACCT,
+	"quotactl":        unix.SYS_QUOTACTL,
+	"sethostname":     unix.SYS_SETHOSTNAME,
+	"setdomainname":   unix.SYS_SETDOMAINNAME,
+	"settimeofday":    unix.SYS_SETTIMEOFDAY,
+	"clock_settime":   unix.SYS_CLOCK_SETTIME,
+	"clock_adjtime":   unix.SYS_CLOCK_ADJTIME,
+	"adjtimex":        unix.SYS_ADJTIMEX,
+	"vhangup":         unix.SYS_VHANGUP,
+	"personality":     unix.SYS_PERSONALITY,
+	// Kernel interfaces with a large attack surface
+	"bpf":               unix.SYS_BPF,
+	"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+	"userfaultfd":       unix.SYS_USERFAULTFD,
+	"io_uring_setup":    unix.SYS_IO_URING_SETUP,
+	"io_uring_enter":    unix.SYS_IO_URING_ENTER,
+	"io_uring_register": unix.SYS_IO_URING_REGISTER,
+	"fanotify_init":     unix.SYS_FANOTIFY_INIT,
+	"lookup_dcookie":    unix.SYS_LOOKUP_DCOOKIE,
+	// Kernel keyring
+	"keyctl":      unix.SYS_KEYCTL,
+	"add_key":     unix.SYS_ADD_KEY,
+	"request_key": unix.SYS_REQUEST_KEY,
+	// Bypassing path based access control
+	"name_to_handle_at": unix.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+}
+
+const seccompSetModeFilter = 1
+
+// restrictSyscalls installs a seccomp filter on all threads that fails the
+// denied syscalls with EPERM.
+func restrictSyscalls(logger *slog.Logger) error {
+	if auditArch == 0 {
+		logger.Warn("seccomp filtering is not supported on this architecture; syscalls are unrestricted",
+			"arch", runtime.GOARCH)
+		return nil
+	}
+
+	denied := make(map[string]uintptr, len(deniedSyscalls)+len(archDeniedSyscalls))
+	for name, nr := range deniedSyscalls {
+		denied[name] = nr
+	}
+	for name, nr := range archDeniedSyscalls {
+		denied[name] = nr
+	}
+	names := make([]string, 0, len(denied))
+	for name := range denied {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	filter := seccompFilter(names, denied)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("failed installing seccomp filter: %w", errno)
+	}
+
+	logger.Debug("blocked syscalls", "syscalls", names)
+
+	return nil
+}
+
+// seccompFilter returns a BPF program that returns EPERM for the syscalls in
+// the order of names, and allows all others. Syscalls made with a different
+// architecture convention than the one the filter was built for kill the
+// process, since their numbers don't match.
+func seccompFilter(names []string, denied map[string]uintptr) []unix.SockFilter {
+	const (
+		offsetNr   = 0 // offsetof(struct seccomp_data, nr)
+		offsetArch = 4 // offsetof(struct seccomp_data, arch)
+	)
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+	}
+	checks := len(names)
+	if syscallNrLimit != 0 {
+		checks++
+	}
+	// The checks jump to the EPERM return, which follows the allow return
+	// after the last check.
+	ret := func(i int) uint8 { return uint8(checks - i) }
+	i := 0
+	if syscallNrLimit != 0 {
+		filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, syscallNrLimit, ret(i), 0))
+		i++
+	}
+	for _, name := range names {
+		filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(denied[name]), ret(i), 0))
+		i++
+	}
+
+	return append(filter,
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+	)
+}
+
+// allThreadsPrctl calls prctl with the option and value on all threads.
+func allThreadsPrctl(option int, value uintptr) error {
+	err := allThreadsSyscall(unix.SYS_PRCTL, uintptr(option), value)
+	if errors.Is(err, syscall.ENOTSUP) {
+		// With cgo, only the calling thread can be changed. This is sufficient
+		// for seccomp, which synchronizes the other threads.
+		err = unix.Prctl(option, value, 0, 0, 0)
+	}
+	return err
+}
+
+func allThreadsSyscall(trap, a1, a2 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
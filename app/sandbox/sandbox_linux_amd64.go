@@ -0,0 +1,19 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const (
+	auditArch = unix.AUDIT_ARCH_X86_64
+	// x32 syscalls use the same architecture, but have this bit set in their
+	// number, so they would bypass the filter.
+	syscallNrLimit = 0x40000000
+)
+
+var archDeniedSyscalls = map[string]uintptr{
+	"iopl":    unix.SYS_IOPL,
+	"ioperm":  unix.SYS_IOPERM,
+	"uselib":  unix.SYS_USELIB,
+	"ustat":   unix.SYS_USTAT,
+	"sysfs":   unix.SYS_SYSFS,
+	"_sysctl": unix.SYS__SYSCTL,
+}
@@ -0,0 +1,10 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const (
+	auditArch      = unix.AUDIT_ARCH_AARCH64
+	syscallNrLimit = 0
+)
+
+var archDeniedSyscalls = map[string]uintptr{}
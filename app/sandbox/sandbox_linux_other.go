@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+// The seccomp filter is only built for the architectures above, since syscall
+// numbers differ between them.
+const (
+	auditArch      = 0
+	syscallNrLimit = 0
+)
+
+var archDeniedSyscalls = map[string]uintptr{}
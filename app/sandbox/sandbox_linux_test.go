@@ -0,0 +1,169 @@
+package sandbox
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"go.hackfix.me/disco/db/types"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	t.Parallel()
+
+	if auditArch == 0 {
+		t.Skip("seccomp filtering is not supported on this architecture")
+	}
+
+	denied := map[string]uintptr{"execve": unix.SYS_EXECVE, "ptrace": unix.SYS_PTRACE}
+	filter := seccompFilter([]string{"execve", "ptrace"}, denied)
+	eperm := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+
+	testCases := []struct {
+		name string
+		arch uint32
+		nr   uint32
+		exp  uint32
+	}{
+		{name: "ok/allowed", arch: auditArch, nr: unix.SYS_GETPID, exp: unix.SECCOMP_RET_ALLOW},
+		{name: "ok/denied_first", arch: auditArch, nr: unix.SYS_EXECVE, exp: eperm},
+		{name: "ok/denied_last", arch: auditArch, nr: unix.SYS_PTRACE, exp: eperm},
+		{name: "err/arch", arch: auditArch + 1, nr: unix.SYS_GETPID, exp: unix.SECCOMP_RET_KILL_PROCESS},
+	}
+	if syscallNrLimit != 0 {
+		testCases = append(testCases, struct {
+			name string
+			arch uint32
+			nr   uint32
+			exp  uint32
+		}{name: "err/nr_limit", arch: auditArch, nr: syscallNrLimit | unix.SYS_GETPID, exp: eperm})
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ret, err := runFilter(filter, tc.nr, tc.arch)
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, ret)
+		})
+	}
+}
+
+// TestApply sandboxes a subprocess running this test, since the restrictions
+// can't be lifted once applied.
+func TestApply(t *testing.T) {
+	if dir := os.Getenv("DISCO_TEST_SANDBOX_DIR"); dir != "" {
+		testApplyChild(t, dir, os.Getenv("TMPDIR"))
+		return
+	}
+
+	t.Parallel()
+
+	dir, tmpDir := t.TempDir(), t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApply$", "-test.v")
+	// TMPDIR is outside the sandbox, so SQLite would fail if it wrote
+	// temporary files there.
+	cmd.Env = append(os.Environ(), "DISCO_TEST_SANDBOX_DIR="+dir, "TMPDIR="+tmpDir)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("sandboxed subprocess skipped:\n%s", out)
+	}
+}
+
+func testApplyChild(t *testing.T, dir, outsideDir string) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	err := Apply(Config{ReadWritePaths: []string{dir}}, logger)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EPERM) {
+		t.Skipf("sandboxing is not permitted in this environment: %v", err)
+	}
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite", types.SQLiteDSN(filepath.Join(dir, "test.db")))
+	require.NoError(t, err)
+	defer db.Close()
+	// A tiny cache makes the sort below spill into temporary storage.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`PRAGMA cache_size = 10`)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 10000)
+		INSERT INTO test SELECT hex(randomblob(64)) FROM n`)
+	require.NoError(t, err)
+	_, err = db.Exec(`VACUUM`)
+	require.NoError(t, err)
+	var count int
+	err = db.QueryRow(`SELECT count(DISTINCT value) FROM (SELECT value FROM test ORDER BY value)`).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 10000, count)
+
+	if auditArch != 0 {
+		// The path doesn't exist, so this fails with ENOENT if execve is
+		// allowed.
+		err = syscall.Exec(filepath.Join(dir, "missing"), nil, nil)
+		assert.ErrorIs(t, err, syscall.EPERM)
+	}
+
+	if strings.Contains(logBuf.String(), "Landlock") {
+		t.Logf("skipping filesystem checks: %s", logBuf.String())
+		return
+	}
+	err = os.WriteFile(filepath.Join(dir, "allowed"), []byte("ok"), 0o600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(outsideDir, "denied"), []byte("nope"), 0o600)
+	assert.ErrorIs(t, err, syscall.EACCES)
+}
+
+// runFilter evaluates the classic BPF program against a seccomp_data struct
+// with the syscall number and architecture, and returns the result. Only the
+// instructions used by seccompFilter are supported.
+func runFilter(filter []unix.SockFilter, nr, arch uint32) (uint32, error) {
+	data := make([]byte, 16)
+	binary.NativeEndian.PutUint32(data[0:], nr)
+	binary.NativeEndian.PutUint32(data[4:], arch)
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.NativeEndian.Uint32(data[ins.K:])
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K, nil
+		default:
+			return 0, fmt.Errorf("unsupported instruction %#x at %d", ins.Code, pc)
+		}
+	}
+
+	return 0, errors.New("program ended without returning")
+}
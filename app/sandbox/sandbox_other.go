@@ -0,0 +1,11 @@
+//go:build !linux
+
+package sandbox
+
+import "log/slog"
+
+// Apply does nothing, since sandboxing is only supported on Linux.
+func Apply(_ Config, logger *slog.Logger) error {
+	logger.Debug("sandboxing is only supported on Linux; skipping")
+	return nil
+}
@@ -52,7 +52,7 @@ func newTestApp(ctx context.Context, options ...Option) (*testApp, error) {
 
 	s, err := initKVStore(ctx,
 		fmt.Sprintf("file:store-%x?mode=memory&cache=shared", rndName),
-		localUser.PrivateKey)
+		localUser.PrivateKey, nil, crypto.DefaultCipher)
 	if err != nil {
 		return nil, err
 	}
@@ -185,13 +185,18 @@ func (hw *hookWriter) waitFor(rxPat string, matchIdx int, wCh chan string) {
 	hw.mx.Unlock()
 
 	go func() {
+		// Keep receiving after a match, so that later writes aren't blocked.
+		matched := false
 		for {
 			select {
 			case d := <-ch:
+				if matched {
+					continue
+				}
 				match := rx.FindStringSubmatch(string(d))
 				if len(match)-1 >= matchIdx {
 					wCh <- match[matchIdx]
-					return
+					matched = true
 				}
 			case <-hw.ctx.Done():
 				return
@@ -206,7 +211,8 @@ func (hw *hookWriter) Write(p []byte) (n int, err error) {
 		return
 	}
 	select {
-	case hw.w <- p:
+	// The caller may reuse p after Write returns.
+	case hw.w <- bytes.Clone(p):
 	case <-hw.ctx.Done():
 	}
 	return
@@ -219,7 +225,7 @@ func createLocalUser() (*models.User, error) {
 	}
 
 	var privKeyHashEnc sql.Null[string]
-	privKeyHash := crypto.Hash("encryption key hash", privKey[:])
+	privKeyHash := crypto.KeyHash(privKey)
 	privKeyHashEnc.V = base58.Encode(privKeyHash)
 	privKeyHashEnc.Valid = true
 
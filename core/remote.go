@@ -61,9 +61,12 @@ func RemoteAuth(ctx context.Context, address, token string) (
 	var sharedKeyArr [32]byte
 	copy(sharedKeyArr[:], sharedKey)
 	joinRespJSON, err := crypto.DecryptSymInMemory(joinRespEnc, &sharedKeyArr)
+	clear(sharedKeyArr[:])
 	if err != nil {
 		return nil, fmt.Errorf("failed decrypting join response payload: %w", err)
 	}
+	// The payload contains the TLS client private key.
+	defer crypto.Wipe(joinRespJSON)
 
 	joinResp := &types.RemoteJoinResponsePayload{}
 	err = json.Unmarshal(joinRespJSON, joinResp)
@@ -2,163 +2,120 @@ package crypto
 
 import (
 	"bytes"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 
 	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/nacl/box"
-	"golang.org/x/crypto/nacl/secretbox"
 )
 
 const (
-	// Maximum size of each encrypted chunk of data. NaCl is recommended for
-	// encrypting "small" messages, so large data is split into 16KB chunks.
+	// Size of each chunk of plaintext data in ciphertext written by previous
+	// versions of Disco, which didn't have a header.
 	chunkSize = 16 * 1024 // 16KB
 	nonceSize = 24
 )
 
-// EncryptSym performs symmetric encryption of the plaintext data using NaCl
-// primitives (Curve25519, XSalsa20 and Poly1305).
-func EncryptSym(plaintext io.Reader, secretKey *[32]byte) (io.Reader, error) {
-	return encrypt(plaintext, nil, secretKey)
+// EncryptSym performs symmetric encryption of the plaintext data. The returned
+// reader encrypts the data as it's read, using the streaming format described
+// in stream.go. The cipher defaults to XSalsa20-Poly1305 and can be changed
+// with the WithCipher option.
+func EncryptSym(plaintext io.Reader, secretKey *[32]byte, opts ...Option) (io.Reader, error) {
+	return newEncryptReader(plaintext, secretKey, opts...)
 }
 
 // EncryptSymInMemory performs symmetric encryption of the plaintext data in
-// memory using NaCl primitives (Curve25519, XSalsa20 and Poly1305).
-func EncryptSymInMemory(plaintext []byte, key *[32]byte) ([]byte, error) {
-	ciphertextR, err := encrypt(bytes.NewBuffer(plaintext), nil, key)
+// memory.
+func EncryptSymInMemory(plaintext []byte, key *[32]byte, opts ...Option) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := NewEncryptWriter(&out, key, opts...)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext, err := io.ReadAll(ciphertextR)
-	if err != nil {
+	if _, err = w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
 		return nil, err
 	}
 
-	return ciphertext, nil
+	return out.Bytes(), nil
 }
 
-// DecryptSymInMemory performs symmetric decryption of the plaintext data in
-// memory using NaCl primitives (Curve25519, XSalsa20 and Poly1305).
-func DecryptSymInMemory(ciphertext []byte, key *[32]byte) ([]byte, error) {
-	plaintextR, err := decrypt(bytes.NewBuffer(ciphertext), nil, key)
-	if err != nil {
-		return nil, err
-	}
-	plaintext, err := io.ReadAll(plaintextR)
+// DecryptSymInMemory performs symmetric decryption of the ciphertext data in
+// memory. The plaintext is read into a single buffer, which is never larger
+// than the ciphertext, so that no partial copies of it are left behind. It's
+// the caller's responsibility to wipe it after use.
+func DecryptSymInMemory(ciphertext []byte, key *[32]byte, opts ...Option) ([]byte, error) {
+	plaintextR, err := NewDecryptReader(bytes.NewReader(ciphertext), key, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return plaintext, nil
-}
-
-// EncryptAsym performs asymmetric encryption of the plaintext data using NaCl
-// primitives (Curve25519, XSalsa20 and Poly1305).
-func EncryptAsym(plaintext io.Reader, publicKey, privateKey *[32]byte) (io.Reader, error) {
-	return encrypt(plaintext, publicKey, privateKey)
-}
-
-func encrypt(in io.Reader, publicKey, privateKey *[32]byte) (io.Reader, error) {
-	var encrypt func(out, message []byte, nonce *[nonceSize]byte) []byte
-	if publicKey == nil {
-		encrypt = func(out, message []byte, nonce *[nonceSize]byte) []byte {
-			return secretbox.Seal(out, message, nonce, privateKey)
-		}
-	} else {
-		encrypt = func(out, message []byte, nonce *[nonceSize]byte) []byte {
-			return box.Seal(out, message, nonce, publicKey, privateKey)
-		}
+	plaintext := make([]byte, len(ciphertext))
+	n, err := io.ReadFull(plaintextR, plaintext)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		clear(plaintext)
+		return nil, err
 	}
-
-	var (
-		buf = make([]byte, chunkSize)
-		out = &bytes.Buffer{}
-	)
-
-	for {
-		n, err := in.Read(buf)
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
-
-		nonce, err := generateNonce()
-		if err != nil {
-			return nil, fmt.Errorf("failed generating nonce: %w", err)
-		}
-
-		encrypted := encrypt(nonce[:], buf[:n], nonce)
-
-		_, err = out.Write(encrypted)
-		if err != nil {
-			return nil, fmt.Errorf("failed writing encrypted data: %w", err)
+	// Make sure the ciphertext was fully authenticated.
+	if _, err = plaintextR.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		clear(plaintext)
+		if err == nil {
+			err = errors.New("plaintext is larger than the ciphertext")
 		}
+		return nil, err
 	}
 
-	return out, nil
+	return plaintext[:n], nil
 }
 
-// DecryptSym performs symmetric decryption of the in ciphertext data using NaCl
-// primitives (Curve25519, XSalsa20 and Poly1305).
-func DecryptSym(ciphertext io.Reader, secretKey *[32]byte) (io.Reader, error) {
-	return decrypt(ciphertext, nil, secretKey)
+// EncryptAsym performs asymmetric encryption of the plaintext data. The data is
+// encrypted with the key shared between the X25519 key pairs, as computed by
+// NaCl box.
+func EncryptAsym(plaintext io.Reader, publicKey, privateKey *[32]byte, opts ...Option) (io.Reader, error) {
+	sharedKey := precomputeKey(publicKey, privateKey)
+	// The stream key is derived when the reader is created, so the shared key
+	// isn't needed afterwards.
+	defer WipeKey(sharedKey)
+	return newEncryptReader(plaintext, sharedKey, opts...)
 }
 
-// DecryptAsym performs asymmetric decryption of the ciphertext data using NaCl
-// primitives (Curve25519, XSalsa20 and Poly1305).
-func DecryptAsym(ciphertext io.Reader, publicKey, privateKey *[32]byte) (io.Reader, error) {
-	return decrypt(ciphertext, publicKey, privateKey)
+// DecryptSym performs symmetric decryption of the ciphertext data. The returned
+// reader decrypts the data as it's read.
+func DecryptSym(ciphertext io.Reader, secretKey *[32]byte, opts ...Option) (io.Reader, error) {
+	return NewDecryptReader(ciphertext, secretKey, opts...)
 }
 
-func decrypt(in io.Reader, publicKey, privateKey *[32]byte) (io.Reader, error) {
-	var decrypt func(out, data []byte, nonce *[nonceSize]byte) ([]byte, bool)
-	if publicKey == nil {
-		decrypt = func(out, data []byte, nonce *[nonceSize]byte) ([]byte, bool) {
-			return secretbox.Open(out, data, nonce, privateKey)
-		}
-	} else {
-		decrypt = func(out, data []byte, nonce *[nonceSize]byte) ([]byte, bool) {
-			return box.Open(out, data, nonce, publicKey, privateKey)
-		}
+// DecryptAsym performs asymmetric decryption of the ciphertext data. The
+// returned reader should be closed once it's no longer needed, which wipes the
+// keys it holds if it wasn't fully read.
+func DecryptAsym(ciphertext io.Reader, publicKey, privateKey *[32]byte, opts ...Option) (io.ReadCloser, error) {
+	sharedKey := precomputeKey(publicKey, privateKey)
+	r, err := NewDecryptReader(ciphertext, sharedKey, opts...)
+	if err != nil {
+		WipeKey(sharedKey)
+		return nil, err
 	}
-
-	var (
-		buf = make([]byte, nonceSize+chunkSize+box.Overhead)
-		out = &bytes.Buffer{}
-	)
-
-	for {
-		// Read the payload
-		n, err := in.Read(buf)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed reading payload from buffer: %w", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		// Unpack the payload
-		var nonce [nonceSize]byte
-		copy(nonce[:], buf[:nonceSize])
-		encrypted := buf[nonceSize:n]
-
-		decrypted, ok := decrypt(nil, encrypted, &nonce)
-		if !ok {
-			return nil, errors.New("failed decrypting chunk")
-		}
-
-		_, err = out.Write(decrypted)
-		if err != nil {
-			return nil, fmt.Errorf("failed writing decrypted data: %w", err)
-		}
+	if lr, ok := r.(*legacyDecryptReader); ok {
+		// Legacy ciphertext is decrypted with the shared key itself, so it
+		// can only be wiped once the reader is done or closed.
+		lr.ownsKey = true
+		return lr, nil
 	}
+	WipeKey(sharedKey)
 
-	return out, nil
+	return r.(io.ReadCloser), nil
+}
+
+// precomputeKey returns the key shared between the X25519 key pairs, as
+// computed by NaCl box, in memory returned by LockedBytes. It should be wiped
+// with WipeKey once it's not needed.
+func precomputeKey(publicKey, privateKey *[32]byte) *[32]byte {
+	sharedKey := (*[32]byte)(LockedBytes(32))
+	box.Precompute(sharedKey, publicKey, privateKey)
+	return sharedKey
 }
 
 // DecodeKey decodes and validates an encryption key.
@@ -176,13 +133,3 @@ func DecodeKey(keyEnc string) (*[32]byte, error) {
 
 	return &key, nil
 }
-
-func generateNonce() (*[nonceSize]byte, error) {
-	nonce := new([nonceSize]byte)
-	_, err := io.ReadFull(rand.Reader, nonce[:])
-	if err != nil {
-		return nil, err
-	}
-
-	return nonce, nil
-}
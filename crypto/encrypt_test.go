@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestEncryptDecryptSym(t *testing.T) {
+	t.Parallel()
+
+	key := newTestKey(t)
+	sizes := []int{0, 1, 1000, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 7}
+
+	for _, c := range []Cipher{CipherXSalsa20Poly1305, CipherXChaCha20Poly1305} {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%s/%d", c, size), func(t *testing.T) {
+				t.Parallel()
+
+				plaintext := make([]byte, size)
+				_, err := rand.Read(plaintext)
+				require.NoError(t, err)
+
+				encR, err := EncryptSym(bytes.NewReader(plaintext), key, WithCipher(c))
+				require.NoError(t, err)
+				ciphertext, err := io.ReadAll(encR)
+				require.NoError(t, err)
+				assert.Equal(t, byte(c), ciphertext[len(streamMagic)+1])
+
+				// The writer must produce the same format.
+				var wbuf bytes.Buffer
+				w, err := NewEncryptWriter(&wbuf, key, WithCipher(c))
+				require.NoError(t, err)
+				_, err = w.Write(plaintext)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				assert.Equal(t, len(ciphertext), wbuf.Len())
+
+				for _, ct := range [][]byte{ciphertext, wbuf.Bytes()} {
+					decR, err := DecryptSym(bytes.NewReader(ct), key)
+					require.NoError(t, err)
+					decrypted, err := io.ReadAll(decR)
+					require.NoError(t, err)
+					assert.True(t, bytes.Equal(plaintext, decrypted))
+				}
+			})
+		}
+	}
+}
+
+func TestDecryptSymErrors(t *testing.T) {
+	t.Parallel()
+
+	key := newTestKey(t)
+	plaintext := bytes.Repeat([]byte("a"), 2*streamChunkSize+10)
+	ciphertext, err := EncryptSymInMemory(plaintext, key)
+	require.NoError(t, err)
+	lastChunkStart := streamHeaderSize + 2*(frameSize+streamChunkSize+secretbox.Overhead)
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[streamHeaderSize+frameSize+10] ^= 1
+
+	badVersion := bytes.Clone(ciphertext)
+	badVersion[len(streamMagic)] = 2
+
+	testCases := []struct {
+		name       string
+		ciphertext []byte
+		key        *[32]byte
+		expErr     string
+	}{
+		{name: "wrong_key", ciphertext: ciphertext, key: newTestKey(t), expErr: "failed decrypting chunk"},
+		{name: "tampered", ciphertext: tampered, key: key, expErr: "failed decrypting chunk"},
+		{name: "bad_version", ciphertext: badVersion, key: key, expErr: "unsupported ciphertext version 2"},
+		{name: "truncated_chunk", ciphertext: ciphertext[:len(ciphertext)-1], key: key, expErr: "ciphertext is truncated"},
+		{name: "missing_final_chunk", ciphertext: ciphertext[:lastChunkStart], key: key, expErr: "ciphertext is truncated"},
+		{name: "trailing_data", ciphertext: append(bytes.Clone(ciphertext), 0), key: key, expErr: "trailing data after the final chunk"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			decR, err := DecryptSym(bytes.NewReader(tc.ciphertext), tc.key)
+			if err == nil {
+				_, err = io.ReadAll(decR)
+			}
+			assert.EqualError(t, err, tc.expErr)
+		})
+	}
+}
+
+func TestAssociatedData(t *testing.T) {
+	t.Parallel()
+
+	key := newTestKey(t)
+	for _, c := range []Cipher{CipherXSalsa20Poly1305, CipherXChaCha20Poly1305} {
+		ciphertext, err := EncryptSymInMemory([]byte("secret"), key,
+			WithCipher(c), WithAssociatedData([]byte("prod/db_password")))
+		require.NoError(t, err)
+
+		plaintext, err := DecryptSymInMemory(ciphertext, key,
+			WithAssociatedData([]byte("prod/db_password")))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(plaintext))
+
+		_, err = DecryptSymInMemory(ciphertext, key,
+			WithAssociatedData([]byte("prod/public_banner")))
+		assert.EqualError(t, err, "failed decrypting chunk")
+
+		_, err = DecryptSymInMemory(ciphertext, key)
+		assert.EqualError(t, err, "failed decrypting chunk")
+	}
+}
+
+func TestDecryptSymLegacy(t *testing.T) {
+	t.Parallel()
+
+	key := newTestKey(t)
+	plaintext := bytes.Repeat([]byte("legacy"), chunkSize/3)
+
+	// Ciphertext written by previous versions: a sequence of 16KB chunks sealed
+	// with secretbox, each prefixed by a random nonce.
+	var ciphertext []byte
+	for data := plaintext; len(data) > 0; {
+		n := min(chunkSize, len(data))
+		var nonce [nonceSize]byte
+		_, err := rand.Read(nonce[:])
+		require.NoError(t, err)
+		ciphertext = secretbox.Seal(append(ciphertext, nonce[:]...), data[:n], &nonce, key)
+		data = data[n:]
+	}
+
+	decrypted, err := DecryptSymInMemory(ciphertext, key)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(plaintext, decrypted))
+
+	decrypted, err = DecryptSymInMemory(nil, key)
+	require.NoError(t, err)
+	assert.Empty(t, decrypted)
+
+	_, err = DecryptSymInMemory(ciphertext, key, WithAssociatedData([]byte("ad")))
+	assert.EqualError(t, err, "legacy ciphertext can't be authenticated with associated data")
+}
+
+func TestEncryptDecryptAsym(t *testing.T) {
+	t.Parallel()
+
+	pubKey1, privKey1, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pubKey2, privKey2, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	plaintext := bytes.Repeat([]byte("asym"), chunkSize/2)
+
+	encR, err := EncryptAsym(bytes.NewReader(plaintext), pubKey2, privKey1)
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(encR)
+	require.NoError(t, err)
+
+	decR, err := DecryptAsym(bytes.NewReader(ciphertext), pubKey1, privKey2)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(decR)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(plaintext, decrypted))
+
+	_, err = DecryptAsym(bytes.NewReader(ciphertext), pubKey2, privKey2)
+	assert.EqualError(t, err, "failed decrypting chunk")
+
+	// Legacy ciphertext is decrypted with the shared key, which is wiped once
+	// it's fully read.
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pubKey2, privKey1)
+	var nonce [nonceSize]byte
+	_, err = rand.Read(nonce[:])
+	require.NoError(t, err)
+	legacyCiphertext := secretbox.Seal(nonce[:], plaintext[:chunkSize], &nonce, &sharedKey)
+
+	decR, err = DecryptAsym(bytes.NewReader(legacyCiphertext), pubKey1, privKey2)
+	require.NoError(t, err)
+	lr, ok := decR.(*legacyDecryptReader)
+	require.True(t, ok)
+	assert.NotNil(t, lr.secretKey)
+	decrypted, err = io.ReadAll(decR)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(plaintext[:chunkSize], decrypted))
+	assert.Nil(t, lr.secretKey)
+
+	// Closing the reader before it's fully read also wipes the shared key.
+	decR, err = DecryptAsym(bytes.NewReader(legacyCiphertext), pubKey1, privKey2)
+	require.NoError(t, err)
+	lr, ok = decR.(*legacyDecryptReader)
+	require.True(t, ok)
+	_, err = decR.Read(make([]byte, 1))
+	require.NoError(t, err)
+	require.NoError(t, decR.Close())
+	assert.Nil(t, lr.secretKey)
+	_, err = decR.Read(make([]byte, 1))
+	assert.EqualError(t, err, "read from closed decryption reader")
+}
+
+func newTestKey(t *testing.T) *[32]byte {
+	t.Helper()
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	require.NoError(t, err)
+	return &key
+}
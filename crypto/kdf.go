@@ -0,0 +1,47 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyPurpose identifies what a key derived from the encryption key is used
+// for. Each purpose gets an independent subkey, so that the compromise of one
+// class of ciphertext doesn't weaken the others.
+type KeyPurpose string
+
+const (
+	// KeyPurposeStore is used for wrapping the data keys of store namespaces.
+	KeyPurposeStore KeyPurpose = "store"
+	// KeyPurposeServerTLS is used for encrypting the server TLS private key.
+	KeyPurposeServerTLS KeyPurpose = "server tls"
+	// KeyPurposeInvites is used for encrypting the private keys of invites.
+	KeyPurposeInvites KeyPurpose = "invites"
+	// KeyPurposeRemotes is used for encrypting the TLS client certificates
+	// and private keys of remotes.
+	KeyPurposeRemotes KeyPurpose = "remotes"
+	// KeyPurposeVerification is used for verifying that the encryption key is
+	// correct.
+	KeyPurposeVerification KeyPurpose = "verification hash"
+)
+
+// DeriveKey derives a 32-byte subkey for the purpose from key using
+// HKDF-SHA256.
+func DeriveKey(key *[32]byte, purpose KeyPurpose) *[32]byte {
+	r := hkdf.New(sha256.New, key[:], nil, []byte("disco subkey:"+string(purpose)))
+	subkey := new([32]byte)
+	if _, err := io.ReadFull(r, subkey[:]); err != nil {
+		// HKDF can only fail if more than 255 blocks are requested.
+		panic(err)
+	}
+
+	return subkey
+}
+
+// KeyHash returns a hash that can be stored for verifying the encryption key,
+// without revealing it or any of its other subkeys.
+func KeyHash(key *[32]byte) []byte {
+	return Hash("encryption key hash", DeriveKey(key, KeyPurposeVerification)[:])
+}
@@ -0,0 +1,43 @@
+package crypto
+
+// LockedBytes returns a zeroed buffer of n bytes for holding secrets. On Linux
+// it's allocated outside of the Go heap, in memory that is locked into RAM so
+// that it isn't swapped to disk, excluded from core dumps, and surrounded by
+// inaccessible guard pages. Elsewhere, or if such memory can't be allocated,
+// it's a regular buffer. It should be released with Wipe once it's not needed.
+func LockedBytes(n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	return lockedBytes(n)
+}
+
+// Wipe zeroes b, and releases it if it was returned by LockedBytes, in which
+// case it must not be accessed afterwards.
+func Wipe(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	clear(b)
+	releaseLocked(b)
+}
+
+// LockKey returns a copy of the key in memory returned by LockedBytes, and
+// wipes the original. It returns nil if key is nil.
+func LockKey(key *[32]byte) *[32]byte {
+	if key == nil {
+		return nil
+	}
+	locked := (*[32]byte)(LockedBytes(len(key)))
+	copy(locked[:], key[:])
+	clear(key[:])
+
+	return locked
+}
+
+// WipeKey zeroes the key, and releases it if it was returned by LockKey.
+func WipeKey(key *[32]byte) {
+	if key != nil {
+		Wipe(key[:])
+	}
+}
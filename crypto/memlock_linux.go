@@ -0,0 +1,144 @@
+//go:build linux
+
+package crypto
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedUnit is the allocation granularity of locked memory, which fits a
+// single key.
+const lockedUnit = 32
+
+// lockedArena is an anonymous memory mapping that holds secrets. Its data pages
+// are locked and excluded from core dumps, and are surrounded by guard pages
+// that can't be accessed, so that an out-of-bounds access crashes instead of
+// leaking adjacent memory.
+type lockedArena struct {
+	mem  []byte // the whole mapping, including the guard pages
+	data []byte
+	used []bool // whether each unit of data is allocated
+}
+
+var (
+	lockedMx     sync.Mutex
+	lockedArenas []*lockedArena
+)
+
+func newLockedArena(size int) (*lockedArena, error) {
+	pageSize := os.Getpagesize()
+	dataSize := (size + pageSize - 1) / pageSize * pageSize
+	mem, err := unix.Mmap(-1, 0, dataSize+2*pageSize,
+		unix.PROT_NONE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+
+	data := mem[pageSize : pageSize+dataSize]
+	if err = unix.Mprotect(data, unix.PROT_READ|unix.PROT_WRITE); err != nil {
+		_ = unix.Munmap(mem)
+		return nil, err
+	}
+	// Both are best-effort. Locking fails if RLIMIT_MEMLOCK would be exceeded,
+	// in which case the memory is still excluded from core dumps.
+	_ = unix.Mlock(data)
+	_ = unix.Madvise(data, unix.MADV_DONTDUMP)
+
+	return &lockedArena{mem: mem, data: data, used: make([]bool, dataSize/lockedUnit)}, nil
+}
+
+// alloc returns n bytes of free consecutive units, or nil if they're not
+// available.
+func (a *lockedArena) alloc(n int) []byte {
+	units := (n + lockedUnit - 1) / lockedUnit
+	free := 0
+	for i, used := range a.used {
+		if used {
+			free = 0
+			continue
+		}
+		free++
+		if free < units {
+			continue
+		}
+
+		start := i - units + 1
+		for j := start; j <= i; j++ {
+			a.used[j] = true
+		}
+		offset := start * lockedUnit
+		return a.data[offset : offset+n : offset+n]
+	}
+
+	return nil
+}
+
+// release frees the units of b, and returns false if b wasn't allocated from
+// the arena.
+func (a *lockedArena) release(b []byte) bool {
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(a.data)))
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	if p < start || p >= start+uintptr(len(a.data)) {
+		return false
+	}
+
+	first := int(p-start) / lockedUnit
+	units := (len(b) + lockedUnit - 1) / lockedUnit
+	for i := first; i < first+units && i < len(a.used); i++ {
+		a.used[i] = false
+	}
+
+	return true
+}
+
+func lockedBytes(n int) []byte {
+	lockedMx.Lock()
+	defer lockedMx.Unlock()
+
+	for _, a := range lockedArenas {
+		if b := a.alloc(n); b != nil {
+			return b
+		}
+	}
+
+	a, err := newLockedArena(n)
+	if err != nil {
+		return make([]byte, n)
+	}
+	lockedArenas = append(lockedArenas, a)
+
+	return a.alloc(n)
+}
+
+func releaseLocked(b []byte) {
+	lockedMx.Lock()
+	defer lockedMx.Unlock()
+
+	for i, a := range lockedArenas {
+		if !a.release(b) {
+			continue
+		}
+		if !slices.Contains(a.used, true) {
+			_ = unix.Munlock(a.data)
+			_ = unix.Munmap(a.mem)
+			lockedArenas = slices.Delete(lockedArenas, i, i+1)
+		}
+		return
+	}
+}
+
+// DisableCoreDumps prevents the process from writing core dumps, which could
+// contain secrets held in memory that isn't locked. This also prevents other
+// processes of the same user from attaching to it with ptrace.
+func DisableCoreDumps() error {
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{}); err != nil {
+		return err
+	}
+
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package crypto
+
+func lockedBytes(n int) []byte {
+	return make([]byte, n)
+}
+
+func releaseLocked([]byte) {}
+
+// DisableCoreDumps prevents the process from writing core dumps. It's only
+// supported on Linux, and does nothing elsewhere.
+func DisableCoreDumps() error {
+	return nil
+}
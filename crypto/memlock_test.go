@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockedBytes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok/keys", func(t *testing.T) {
+		t.Parallel()
+
+		// More keys than fit in a single page.
+		keys := make([]*[32]byte, 200)
+		for i := range keys {
+			key := newTestKey(t)
+			orig := *key
+			keys[i] = LockKey(key)
+			assert.Equal(t, [32]byte{}, *key, "the original key must be wiped")
+			assert.Equal(t, orig, *keys[i])
+		}
+
+		// Keys don't overlap.
+		for i, key := range keys {
+			key[0] = byte(i)
+			key[31] = byte(i)
+		}
+		for i, key := range keys {
+			assert.Equal(t, byte(i), key[0])
+			assert.Equal(t, byte(i), key[31])
+		}
+
+		for _, key := range keys {
+			WipeKey(key)
+		}
+		assert.Nil(t, LockKey(nil))
+	})
+
+	t.Run("ok/large", func(t *testing.T) {
+		t.Parallel()
+
+		size := os.Getpagesize() + 100
+		b := LockedBytes(size)
+		require.Len(t, b, size)
+		assert.Equal(t, make([]byte, size), b)
+
+		copy(b, bytes.Repeat([]byte{0xff}, size))
+		Wipe(b)
+	})
+
+	t.Run("ok/reuse", func(t *testing.T) {
+		t.Parallel()
+
+		// Keep the arena mapped while the memory is released.
+		keep := LockedBytes(32)
+		defer Wipe(keep)
+
+		b := LockedBytes(64)
+		copy(b, bytes.Repeat([]byte{0xff}, 64))
+		Wipe(b)
+
+		// Released memory is zeroed before it's reused.
+		for range 10 {
+			b = LockedBytes(64)
+			assert.Equal(t, make([]byte, 64), b)
+			Wipe(b)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is a key derivation function used for deriving keys from passphrases.
+type KDF string
+
+const (
+	KDFArgon2id KDF = "argon2id"
+	KDFScrypt   KDF = "scrypt"
+)
+
+// KDFParams are the parameters of a passphrase key derivation. They must be
+// stored along with the data encrypted with the derived key, so that it can be
+// derived again.
+type KDFParams struct {
+	KDF  KDF
+	Salt []byte
+
+	// Argon2id parameters. Memory is in KiB.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+
+	// scrypt parameters.
+	N, R, P int
+}
+
+// NewKDFParams returns the recommended parameters for the KDF, with a new
+// random salt.
+func NewKDFParams(kdf KDF) (*KDFParams, error) {
+	p := &KDFParams{KDF: kdf, Salt: make([]byte, 16)}
+	switch kdf {
+	case KDFArgon2id:
+		// The second recommended option of RFC 9106.
+		p.Time, p.Memory, p.Threads = 3, 64*1024, 4
+	case KDFScrypt:
+		p.N, p.R, p.P = 1<<15, 8, 1
+	default:
+		return nil, fmt.Errorf("unsupported KDF '%s'", kdf)
+	}
+
+	if _, err := rand.Read(p.Salt); err != nil {
+		return nil, fmt.Errorf("failed generating salt: %w", err)
+	}
+
+	return p, nil
+}
+
+// ParseKDFParams parses the cost parameters of the KDF in the format returned
+// by KDFParams.String.
+func ParseKDFParams(kdf KDF, params string, salt []byte) (*KDFParams, error) {
+	p := &KDFParams{KDF: kdf, Salt: salt}
+	var err error
+	switch kdf {
+	case KDFArgon2id:
+		_, err = fmt.Sscanf(params, "t=%d,m=%d,p=%d", &p.Time, &p.Memory, &p.Threads)
+	case KDFScrypt:
+		_, err = fmt.Sscanf(params, "N=%d,r=%d,p=%d", &p.N, &p.R, &p.P)
+	default:
+		return nil, fmt.Errorf("unsupported KDF '%s'", kdf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s parameters '%s': %w", kdf, params, err)
+	}
+
+	return p, nil
+}
+
+// String returns the cost parameters of the KDF.
+func (p *KDFParams) String() string {
+	switch p.KDF {
+	case KDFArgon2id:
+		return fmt.Sprintf("t=%d,m=%d,p=%d", p.Time, p.Memory, p.Threads)
+	case KDFScrypt:
+		return fmt.Sprintf("N=%d,r=%d,p=%d", p.N, p.R, p.P)
+	default:
+		return ""
+	}
+}
+
+// DeriveKey derives a 32-byte key from the passphrase.
+func (p *KDFParams) DeriveKey(passphrase []byte) (*[32]byte, error) {
+	var keyData []byte
+	switch p.KDF {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Threads == 0 {
+			return nil, fmt.Errorf("invalid %s parameters '%s'", p.KDF, p)
+		}
+		keyData = argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, 32)
+	case KDFScrypt:
+		var err error
+		keyData, err = scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameters '%s': %w", p.KDF, p, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported KDF '%s'", p.KDF)
+	}
+
+	key := new([32]byte)
+	copy(key[:], keyData)
+
+	return key, nil
+}
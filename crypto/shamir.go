@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/mr-tron/base58"
+)
+
+// SplitSecret splits the secret into the given number of shares using Shamir's
+// secret sharing scheme over GF(2^8), so that any threshold of them can
+// reconstruct it, but fewer reveal nothing about it. Each share is the
+// evaluation of a random polynomial per secret byte, followed by the x
+// coordinate it was evaluated at.
+func SplitSecret(secret []byte, shares, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return nil, fmt.Errorf(
+			"invalid shares %d and threshold %d; must be 2 <= threshold <= shares <= 255",
+			shares, threshold)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("the secret is empty")
+	}
+
+	out := make([][]byte, shares)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][len(secret)] = byte(i + 1)
+	}
+
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed generating polynomial: %w", err)
+		}
+		for i := range out {
+			x := out[i][len(secret)]
+			// Horner's method
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coeffs[c]
+			}
+			out[i][b] = y
+		}
+	}
+	clear(coeffs)
+
+	return out, nil
+}
+
+// CombineSecret reconstructs the secret from shares returned by SplitSecret.
+// The result is only correct if at least as many shares as the threshold are
+// provided, which can't be verified here.
+func CombineSecret(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are required")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("invalid share length")
+	}
+	xs := make([]byte, len(shares))
+	for i, s := range shares {
+		if len(s) != size {
+			return nil, errors.New("all shares must have the same length")
+		}
+		x := s[size-1]
+		if x == 0 || bytes.IndexByte(xs[:i], x) != -1 {
+			return nil, errors.New("duplicate or invalid share")
+		}
+		xs[i] = x
+	}
+
+	// Lagrange interpolation at x = 0. Addition and subtraction are both XOR
+	// in GF(2^8).
+	secret := make([]byte, size-1)
+	for i, s := range shares {
+		var basis byte = 1
+		for j := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xs[j], xs[i]^xs[j]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(s[b], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+// gfMul multiplies a and b in GF(2^8) with the AES reduction polynomial. It
+// doesn't branch on its inputs, to avoid leaking them through timing.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides a by b in GF(2^8). b must not be 0.
+func gfDiv(a, b byte) byte {
+	// The inverse of b is b^254.
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = gfMul(gfMul(inv, inv), b)
+	}
+	return gfMul(a, gfMul(inv, inv))
+}
+
+// KeyShare is a share of an encryption key split with SplitKey.
+type KeyShare struct {
+	// SetID identifies the set of shares produced by a single split. Shares
+	// of different sets can't be combined.
+	SetID     string
+	Threshold int
+	share     []byte
+	encoded   string
+}
+
+// String returns the encoded key share.
+func (ks *KeyShare) String() string {
+	return ks.encoded
+}
+
+// Equal returns true if both key shares are the same.
+func (ks *KeyShare) Equal(other *KeyShare) bool {
+	return ks.encoded == other.encoded
+}
+
+const (
+	keyShareSetIDLen    = 4
+	keyShareChecksumLen = 4
+	keyShareLen         = keyShareSetIDLen + 1 + 32 + 1 + keyShareChecksumLen
+)
+
+// SplitKey splits the key into the given number of shares, any threshold of
+// which can be combined with CombineKeyShares to reconstruct it. The shares are
+// encoded as base58 strings that include the threshold, an ID of the set they
+// belong to, and a checksum for detecting typos.
+func SplitKey(key *[32]byte, shares, threshold int) ([]string, error) {
+	parts, err := SplitSecret(key[:], shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	setID := make([]byte, keyShareSetIDLen)
+	if _, err = rand.Read(setID); err != nil {
+		return nil, fmt.Errorf("failed generating share set ID: %w", err)
+	}
+
+	encoded := make([]string, len(parts))
+	for i, p := range parts {
+		data := make([]byte, 0, keyShareLen)
+		data = append(data, setID...)
+		data = append(data, byte(threshold))
+		data = append(data, p...)
+		sum := sha256.Sum256(data)
+		data = append(data, sum[:keyShareChecksumLen]...)
+		encoded[i] = base58.Encode(data)
+		clear(data)
+		clear(p)
+	}
+
+	return encoded, nil
+}
+
+// ParseKeyShare decodes a key share returned by SplitKey.
+func ParseKeyShare(s string) (*KeyShare, error) {
+	data, err := base58.Decode(s)
+	if err != nil || len(data) != keyShareLen {
+		return nil, errors.New("invalid key share")
+	}
+
+	payload := data[:len(data)-keyShareChecksumLen]
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(sum[:keyShareChecksumLen], data[len(payload):]) {
+		return nil, errors.New("invalid key share checksum")
+	}
+
+	return &KeyShare{
+		SetID:     base58.Encode(payload[:keyShareSetIDLen]),
+		Threshold: int(payload[keyShareSetIDLen]),
+		share:     payload[keyShareSetIDLen+1:],
+		encoded:   s,
+	}, nil
+}
+
+// CombineKeyShares reconstructs the key from at least as many shares of the
+// same set as its threshold.
+func CombineKeyShares(shares []*KeyShare) (*[32]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares provided")
+	}
+
+	parts := make([][]byte, len(shares))
+	for i, s := range shares {
+		if s.SetID != shares[0].SetID {
+			return nil, errors.New("the key shares belong to different sets")
+		}
+		parts[i] = s.share
+	}
+	if len(shares) < shares[0].Threshold {
+		return nil, fmt.Errorf("%d key shares are required, but only %d were provided",
+			shares[0].Threshold, len(shares))
+	}
+
+	secret, err := CombineSecret(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	key := new([32]byte)
+	copy(key[:], secret)
+	clear(secret)
+
+	return key, nil
+}
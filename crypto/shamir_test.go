@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCombineSecret(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("a secret that is longer than a single byte")
+
+	for _, tc := range []struct{ shares, threshold int }{
+		{2, 2}, {3, 2}, {5, 3}, {255, 255},
+	} {
+		t.Run(fmt.Sprintf("%d_of_%d", tc.threshold, tc.shares), func(t *testing.T) {
+			t.Parallel()
+
+			parts, err := SplitSecret(secret, tc.shares, tc.threshold)
+			require.NoError(t, err)
+			require.Len(t, parts, tc.shares)
+
+			// Any threshold of shares reconstruct the secret.
+			for start := 0; start+tc.threshold <= tc.shares; start++ {
+				got, err := CombineSecret(parts[start : start+tc.threshold])
+				require.NoError(t, err)
+				assert.Equal(t, secret, got)
+			}
+
+			// Fewer don't.
+			if tc.threshold > 2 {
+				got, err := CombineSecret(parts[:tc.threshold-1])
+				require.NoError(t, err)
+				assert.NotEqual(t, secret, got)
+			}
+		})
+	}
+}
+
+func TestSplitSecretErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct{ shares, threshold int }{
+		{1, 1}, {3, 1}, {2, 3}, {256, 2},
+	} {
+		_, err := SplitSecret([]byte("secret"), tc.shares, tc.threshold)
+		assert.ErrorContains(t, err, "invalid shares")
+	}
+
+	_, err := SplitSecret(nil, 3, 2)
+	assert.EqualError(t, err, "the secret is empty")
+}
+
+func TestCombineSecretErrors(t *testing.T) {
+	t.Parallel()
+
+	parts, err := SplitSecret([]byte("secret"), 3, 2)
+	require.NoError(t, err)
+
+	_, err = CombineSecret(parts[:1])
+	assert.EqualError(t, err, "at least 2 shares are required")
+
+	_, err = CombineSecret([][]byte{parts[0], parts[0]})
+	assert.EqualError(t, err, "duplicate or invalid share")
+
+	_, err = CombineSecret([][]byte{parts[0], parts[1][1:]})
+	assert.EqualError(t, err, "all shares must have the same length")
+}
+
+func TestSplitCombineKey(t *testing.T) {
+	t.Parallel()
+
+	key := newTestKey(t)
+	shares, err := SplitKey(key, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+
+	parse := func(t *testing.T, shares ...string) []*KeyShare {
+		t.Helper()
+		parsed := make([]*KeyShare, len(shares))
+		for i, s := range shares {
+			var err error
+			parsed[i], err = ParseKeyShare(s)
+			require.NoError(t, err)
+		}
+		return parsed
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		parsed := parse(t, shares[4], shares[0], shares[2])
+		assert.Equal(t, 3, parsed[0].Threshold)
+
+		got, err := CombineKeyShares(parsed)
+		require.NoError(t, err)
+		assert.Equal(t, key, got)
+	})
+
+	t.Run("err/threshold", func(t *testing.T) {
+		_, err := CombineKeyShares(parse(t, shares[0], shares[1]))
+		assert.EqualError(t, err, "3 key shares are required, but only 2 were provided")
+	})
+
+	t.Run("err/different_sets", func(t *testing.T) {
+		otherShares, err := SplitKey(key, 5, 3)
+		require.NoError(t, err)
+
+		_, err = CombineKeyShares(parse(t, shares[0], shares[1], otherShares[2]))
+		assert.EqualError(t, err, "the key shares belong to different sets")
+	})
+
+	t.Run("err/parse", func(t *testing.T) {
+		_, err := ParseKeyShare("invalid")
+		assert.EqualError(t, err, "invalid key share")
+
+		// Change a single character.
+		typo := []byte(shares[0])
+		if typo[10] == 'a' {
+			typo[10] = 'b'
+		} else {
+			typo[10] = 'a'
+		}
+		_, err = ParseKeyShare(string(typo))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,575 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The streaming ciphertext format is modeled after the STREAM construction, as
+// used by age. It consists of a header followed by a sequence of chunks:
+//
+//	header = magic || version || cipher ID || salt
+//	chunk  = uint32 length of sealed data || sealed data
+//
+// Every stream is encrypted with a unique key derived with HKDF-SHA256 from the
+// secret key and the header. Optional associated data is authenticated by
+// mixing it into the key derivation, which also works for ciphers that don't
+// support it natively, such as secretbox. Chunk nonces are built from a
+// big-endian counter and a flag that is set only for the final chunk, so that
+// reordering, dropping or truncating chunks is detected on decryption.
+const (
+	streamMagic      = "\x89DSC"
+	streamVersion    = 1
+	streamSaltSize   = 16
+	streamHeaderSize = len(streamMagic) + 2 + streamSaltSize
+	streamChunkSize  = 64 * 1024 // 64KB
+	streamKeyInfo    = "disco stream key"
+
+	// Size of a length frame preceding each sealed chunk.
+	frameSize = 4
+	// Flag set in the last byte of the nonce of the final chunk.
+	lastChunkFlag = 0x01
+)
+
+var (
+	errTruncated    = errors.New("ciphertext is truncated")
+	errTrailingData = errors.New("trailing data after the final chunk")
+	errChunkOpen    = errors.New("failed decrypting chunk")
+)
+
+// Cipher is the authenticated encryption algorithm used to encrypt data.
+type Cipher byte
+
+const (
+	// CipherXSalsa20Poly1305 is the NaCl secretbox construction.
+	CipherXSalsa20Poly1305 Cipher = iota + 1
+	// CipherXChaCha20Poly1305 is the ChaCha20-Poly1305 AEAD with an extended
+	// 24-byte nonce.
+	CipherXChaCha20Poly1305
+)
+
+// DefaultCipher is the cipher used when one isn't specified.
+const DefaultCipher = CipherXSalsa20Poly1305
+
+var cipherNames = map[Cipher]string{
+	CipherXSalsa20Poly1305:  "xsalsa20-poly1305",
+	CipherXChaCha20Poly1305: "xchacha20-poly1305",
+}
+
+// String returns the name of the cipher.
+func (c Cipher) String() string {
+	if name, ok := cipherNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown cipher %d", byte(c))
+}
+
+// ParseCipher returns the cipher with the given name.
+func ParseCipher(name string) (Cipher, error) {
+	for c, cName := range cipherNames {
+		if cName == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher '%s'", name)
+}
+
+// Option is a function that allows configuring encryption and decryption.
+type Option func(*options)
+
+type options struct {
+	cipher         Cipher
+	associatedData []byte
+}
+
+func newOptions(opts ...Option) *options {
+	options := &options{cipher: DefaultCipher}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithCipher sets the cipher used for encryption. It's ignored on decryption,
+// since the cipher is read from the ciphertext header.
+func WithCipher(c Cipher) Option {
+	return func(opts *options) {
+		opts.cipher = c
+	}
+}
+
+// WithAssociatedData sets data that is authenticated, but not encrypted.
+// Decryption fails unless the same associated data used for encryption is
+// provided.
+func WithAssociatedData(ad []byte) Option {
+	return func(opts *options) {
+		opts.associatedData = ad
+	}
+}
+
+// NewEncryptWriter returns a writer that encrypts the data written to it
+// with the secret key, and writes the ciphertext to w. The header is written
+// immediately. Close must be called to write the final chunk; it doesn't close
+// the underlying writer.
+func NewEncryptWriter(w io.Writer, secretKey *[32]byte, opts ...Option) (io.WriteCloser, error) {
+	options := newOptions(opts...)
+
+	header := make([]byte, streamHeaderSize)
+	copy(header, streamMagic)
+	header[len(streamMagic)] = streamVersion
+	header[len(streamMagic)+1] = byte(options.cipher)
+	if _, err := io.ReadFull(rand.Reader, header[streamHeaderSize-streamSaltSize:]); err != nil {
+		return nil, fmt.Errorf("failed generating salt: %w", err)
+	}
+
+	aead, err := streamAEAD(options.cipher, secretKey, header, options.associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(header); err != nil {
+		wipeAEAD(aead)
+		return nil, fmt.Errorf("failed writing header: %w", err)
+	}
+
+	return &encryptWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+// NewDecryptReader returns a reader that decrypts the ciphertext read from r
+// with the secret key. The header and the first chunk are read and
+// authenticated before returning. Ciphertext written by previous versions of
+// Disco, which lacks a header, is also supported, unless associated data is
+// given.
+func NewDecryptReader(r io.Reader, secretKey *[32]byte, opts ...Option) (io.Reader, error) {
+	options := newOptions(opts...)
+	br := bufio.NewReader(r)
+	header, err := br.Peek(streamHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed reading header: %w", err)
+	}
+	if !bytes.HasPrefix(header, []byte(streamMagic)) {
+		if options.associatedData != nil {
+			return nil, errors.New("legacy ciphertext can't be authenticated with associated data")
+		}
+		lr := &legacyDecryptReader{r: br, secretKey: secretKey}
+		if err = lr.readChunk(); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return lr, nil
+	}
+
+	if v := header[len(streamMagic)]; v != streamVersion {
+		return nil, fmt.Errorf("unsupported ciphertext version %d", v)
+	}
+	header = bytes.Clone(header)
+	if _, err = br.Discard(streamHeaderSize); err != nil {
+		return nil, err
+	}
+
+	aead, err := streamAEAD(Cipher(header[len(streamMagic)+1]), secretKey, header, options.associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &decryptReader{
+		r:      br,
+		aead:   aead,
+		nonce:  make([]byte, aead.NonceSize()),
+		sealed: make([]byte, streamChunkSize+aead.Overhead()),
+	}
+	if err = dr.readChunk(); err != nil {
+		wipeAEAD(aead)
+		return nil, err
+	}
+
+	return dr, nil
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	buf     []byte // pending plaintext
+	out     []byte // framed sealed chunk
+	err     error
+}
+
+// Write encrypts p. A chunk is only sealed once more data is written after it,
+// since it's not known in advance which chunk is the final one.
+func (ew *encryptWriter) Write(p []byte) (n int, err error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+
+	for len(p) > 0 {
+		if len(ew.buf) == streamChunkSize {
+			if err = ew.writeChunk(false); err != nil {
+				ew.err = err
+				wipeAEAD(ew.aead)
+				return n, err
+			}
+		}
+		c := copy(ew.buf[len(ew.buf):streamChunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close writes the final chunk. It doesn't close the underlying writer.
+func (ew *encryptWriter) Close() error {
+	if ew.err != nil {
+		return ew.err
+	}
+	defer wipeAEAD(ew.aead)
+	if err := ew.writeChunk(true); err != nil {
+		ew.err = err
+		return err
+	}
+	ew.err = errors.New("write to closed encryption writer")
+
+	return nil
+}
+
+func (ew *encryptWriter) writeChunk(last bool) error {
+	if err := setChunkNonce(ew.nonce, ew.counter, last); err != nil {
+		return err
+	}
+
+	ew.out = ew.aead.Seal(append(ew.out[:0], 0, 0, 0, 0), ew.nonce, ew.buf, nil)
+	binary.BigEndian.PutUint32(ew.out, uint32(len(ew.out)-frameSize))
+	if _, err := ew.w.Write(ew.out); err != nil {
+		return fmt.Errorf("failed writing encrypted data: %w", err)
+	}
+
+	ew.counter++
+	clear(ew.buf)
+	ew.buf = ew.buf[:0]
+
+	return nil
+}
+
+// encryptReader encrypts the data read from an underlying reader on demand.
+type encryptReader struct {
+	r     io.Reader
+	w     io.WriteCloser
+	out   *bytes.Buffer // ciphertext ready to be read
+	chunk []byte
+	done  bool
+}
+
+func newEncryptReader(r io.Reader, secretKey *[32]byte, opts ...Option) (io.Reader, error) {
+	out := &bytes.Buffer{}
+	w, err := NewEncryptWriter(out, secretKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{r: r, w: w, out: out, chunk: make([]byte, streamChunkSize)}, nil
+}
+
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for er.out.Len() == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(er.r, er.chunk)
+		_, werr := er.w.Write(er.chunk[:n])
+		clear(er.chunk[:n])
+		if werr != nil {
+			return 0, werr
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			er.done = true
+			if err = er.w.Close(); err != nil {
+				return 0, err
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	return er.out.Read(p)
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	sealed  []byte
+	buf     []byte // decrypted chunk, wiped once it's read
+	plain   []byte // decrypted data not yet read
+	last    bool
+	err     error
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.last {
+			if _, err := dr.r.Peek(1); errors.Is(err, io.EOF) {
+				dr.err = io.EOF
+			} else if err != nil {
+				dr.err = err
+			} else {
+				dr.err = errTrailingData
+			}
+			wipeAEAD(dr.aead)
+			continue
+		}
+		if dr.err = dr.readChunk(); dr.err != nil {
+			wipeAEAD(dr.aead)
+		}
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	if len(dr.plain) == 0 {
+		clear(dr.buf)
+	}
+
+	return n, nil
+}
+
+// Close wipes the decrypted data that wasn't read yet, and the key of the
+// AEAD. Reading afterwards fails.
+func (dr *decryptReader) Close() error {
+	clear(dr.buf)
+	dr.plain = nil
+	wipeAEAD(dr.aead)
+	if dr.err == nil {
+		dr.err = errors.New("read from closed decryption reader")
+	}
+
+	return nil
+}
+
+// readChunk reads and decrypts the next chunk. A chunk shorter than the maximum
+// size can only be the final one. A full-size chunk is usually followed by
+// another, but might also be the final one.
+func (dr *decryptReader) readChunk() error {
+	var frame [frameSize]byte
+	if _, err := io.ReadFull(dr.r, frame[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errTruncated
+		}
+		return err
+	}
+
+	size := int(binary.BigEndian.Uint32(frame[:]))
+	if size < dr.aead.Overhead() || size > len(dr.sealed) {
+		return fmt.Errorf("invalid chunk size %d", size)
+	}
+	sealed := dr.sealed[:size]
+	if _, err := io.ReadFull(dr.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errTruncated
+		}
+		return err
+	}
+
+	var err error
+	if size == len(dr.sealed) {
+		if err = setChunkNonce(dr.nonce, dr.counter, false); err != nil {
+			return err
+		}
+		dr.buf, err = dr.aead.Open(dr.buf[:0], dr.nonce, sealed, nil)
+	}
+	if size < len(dr.sealed) || err != nil {
+		if err = setChunkNonce(dr.nonce, dr.counter, true); err != nil {
+			return err
+		}
+		dr.buf, err = dr.aead.Open(dr.buf[:0], dr.nonce, sealed, nil)
+		if err != nil {
+			return errChunkOpen
+		}
+		if len(dr.buf) == 0 && dr.counter > 0 {
+			return errors.New("final chunk is empty")
+		}
+		dr.last = true
+	}
+	dr.plain = dr.buf
+	dr.counter++
+
+	return nil
+}
+
+// legacyDecryptReader decrypts ciphertext without a header, written by previous
+// versions of Disco. It consists of secretbox sealed 16KB chunks, each prefixed
+// by a random nonce.
+type legacyDecryptReader struct {
+	r         io.Reader
+	secretKey *[32]byte
+	// Whether the secret key is wiped once all data is read, or reading fails.
+	ownsKey  bool
+	buf      [nonceSize + chunkSize + secretbox.Overhead]byte
+	plainBuf []byte // decrypted chunk, wiped once it's read
+	plain    []byte // decrypted data not yet read
+	err      error
+}
+
+func (lr *legacyDecryptReader) Read(p []byte) (int, error) {
+	for len(lr.plain) == 0 {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		if lr.err = lr.readChunk(); lr.err != nil {
+			lr.wipeKey()
+		}
+	}
+
+	n := copy(p, lr.plain)
+	lr.plain = lr.plain[n:]
+	if len(lr.plain) == 0 {
+		clear(lr.plainBuf)
+	}
+
+	return n, nil
+}
+
+// Close wipes the decrypted data that wasn't read yet, and the secret key if
+// the reader owns it. Reading afterwards fails.
+func (lr *legacyDecryptReader) Close() error {
+	clear(lr.plainBuf)
+	lr.plain = nil
+	lr.wipeKey()
+	if lr.err == nil {
+		lr.err = errors.New("read from closed decryption reader")
+	}
+
+	return nil
+}
+
+func (lr *legacyDecryptReader) wipeKey() {
+	if lr.ownsKey {
+		WipeKey(lr.secretKey)
+		lr.secretKey = nil
+		lr.ownsKey = false
+	}
+}
+
+func (lr *legacyDecryptReader) readChunk() error {
+	n, err := io.ReadFull(lr.r, lr.buf[:])
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if n < nonceSize+secretbox.Overhead {
+		return errTruncated
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], lr.buf[:nonceSize])
+	var ok bool
+	lr.plainBuf, ok = secretbox.Open(lr.plainBuf[:0], lr.buf[nonceSize:n], &nonce, lr.secretKey)
+	if !ok {
+		return errChunkOpen
+	}
+	lr.plain = lr.plainBuf
+
+	return nil
+}
+
+// streamAEAD returns the AEAD for the given cipher, keyed with a key derived
+// from the secret key, the stream header and the associated data.
+func streamAEAD(c Cipher, secretKey *[32]byte, header, ad []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	defer clear(key)
+	info := append([]byte(streamKeyInfo), ad...)
+	kdf := hkdf.New(sha256.New, secretKey[:], header, info)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, fmt.Errorf("failed deriving stream key: %w", err)
+	}
+
+	switch c {
+	case CipherXSalsa20Poly1305:
+		sb := &secretboxAEAD{}
+		copy(sb.key[:], key)
+		return sb, nil
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher ID %d", byte(c))
+	}
+}
+
+// wipeAEAD wipes the copy of the key held by the AEAD. The AEADs of the standard
+// library don't expose their key, so only secretbox keys can be wiped.
+func wipeAEAD(aead cipher.AEAD) {
+	if sb, ok := aead.(*secretboxAEAD); ok {
+		clear(sb.key[:])
+	}
+}
+
+// setChunkNonce sets the nonce of the chunk with the given counter. The nonce
+// is composed of zeros, followed by the big-endian counter and the final chunk
+// flag.
+func setChunkNonce(nonce []byte, counter uint64, last bool) error {
+	if counter == math.MaxUint64 {
+		return errors.New("chunk counter overflow")
+	}
+
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = lastChunkFlag
+	}
+
+	return nil
+}
+
+// secretboxAEAD adapts NaCl secretbox to the cipher.AEAD interface. Additional
+// data isn't supported.
+type secretboxAEAD struct {
+	key [32]byte
+}
+
+var _ cipher.AEAD = &secretboxAEAD{}
+
+func (sb *secretboxAEAD) NonceSize() int { return nonceSize }
+
+func (sb *secretboxAEAD) Overhead() int { return secretbox.Overhead }
+
+func (sb *secretboxAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(additionalData) > 0 {
+		panic("secretbox: additional data is not supported")
+	}
+	return secretbox.Seal(dst, plaintext, (*[nonceSize]byte)(nonce), &sb.key)
+}
+
+func (sb *secretboxAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(additionalData) > 0 {
+		panic("secretbox: additional data is not supported")
+	}
+	out, ok := secretbox.Open(dst, ciphertext, (*[nonceSize]byte)(nonce), &sb.key)
+	if !ok {
+		return nil, errChunkOpen
+	}
+	return out, nil
+}
@@ -49,24 +49,12 @@ func DefaultTLSConfig() *tls.Config {
 func NewTLSCert(
 	subjectName string, san []string, expiration time.Time, parent *tls.Certificate,
 ) (certPEM, privateKeyPEM []byte, err error) {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed generating serial number: %w", err)
-	}
-
-	var isCA bool
-	if parent == nil {
-		isCA = true
-	}
-
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
+	template := &x509.Certificate{
 		Subject: pkix.Name{
 			Organization: []string{"HACKfixme"},
 			CommonName:   subjectName,
 		},
-		IsCA:      isCA,
+		IsCA:      parent == nil,
 		DNSNames:  san,
 		NotBefore: time.Now(),
 		NotAfter:  expiration,
@@ -77,6 +65,49 @@ func NewTLSCert(
 		BasicConstraintsValid: true,
 	}
 
+	return createTLSCert(template, parent)
+}
+
+// NewTLSServingCert creates a X.509 v3 certificate for serving TLS connections,
+// signed by the parent certificate. Unlike the certificates created by
+// NewTLSCert, it can't sign other certificates, or authenticate clients, so its
+// private key doesn't grant access to servers that trust the parent.
+func NewTLSServingCert(
+	subjectName string, san []string, expiration time.Time, parent *tls.Certificate,
+) (certPEM, privateKeyPEM []byte, err error) {
+	if parent == nil {
+		return nil, nil, errors.New("no parent certificate provided")
+	}
+
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"HACKfixme"},
+			CommonName:   subjectName,
+		},
+		DNSNames:              san,
+		NotBefore:             time.Now(),
+		NotAfter:              expiration,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	return createTLSCert(template, parent)
+}
+
+// createTLSCert creates a certificate from the template with a new Ed25519
+// private key, and signs it with the parent certificate, or self-signs it if
+// parent is nil. It returns the certificate and private key encoded in PEM
+// format.
+func createTLSCert(
+	template *x509.Certificate, parent *tls.Certificate,
+) (certPEM, privateKeyPEM []byte, err error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	template.SerialNumber, err = rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed generating serial number: %w", err)
+	}
+
 	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed generating Ed25519 key pair: %w", err)
@@ -96,13 +127,13 @@ func NewTLSCert(
 			return nil, nil, fmt.Errorf("failed parsing X.509 certificate from parent: %w", err)
 		}
 
-		// Client cert signed by the parent (CA) cert
-		certDER, certErr = x509.CreateCertificate(rand.Reader, &template,
+		// Cert signed by the parent (CA) cert
+		certDER, certErr = x509.CreateCertificate(rand.Reader, template,
 			x509Cert, pubKey, parent.PrivateKey)
 	} else {
 		// Self-signed cert used by the server (CA)
-		certDER, certErr = x509.CreateCertificate(rand.Reader, &template,
-			&template, pubKey, privKey)
+		certDER, certErr = x509.CreateCertificate(rand.Reader, template,
+			template, pubKey, privKey)
 	}
 	if certErr != nil {
 		return nil, nil, fmt.Errorf("failed creating X.509 certificate: %w", certErr)
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"embed"
 	"io/fs"
+	"log/slog"
 
 	_ "github.com/glebarez/go-sqlite"
 	"go.hackfix.me/disco/db/migrator"
@@ -17,18 +18,19 @@ var migrationsFS embed.FS
 type DB struct {
 	*sql.DB
 	ctx        context.Context
+	path       string
 	migrations []*migrator.Migration
 }
 
 var _ types.Querier = &DB{}
 
 func Open(ctx context.Context, path string) (*DB, error) {
-	sqliteDB, err := sql.Open("sqlite", path)
+	sqliteDB, err := sql.Open("sqlite", types.SQLiteDSN(path))
 	if err != nil {
 		return nil, err
 	}
 
-	d := &DB{DB: sqliteDB, ctx: ctx}
+	d := &DB{DB: sqliteDB, ctx: ctx, path: path}
 
 	migrationsDir, err := fs.Sub(migrationsFS, "migrations")
 	if err != nil {
@@ -43,9 +45,17 @@ func Open(ctx context.Context, path string) (*DB, error) {
 	return d, nil
 }
 
-// NewContext returns a new child context of the main database context.
+// NewContext returns the main database context.
 func (d *DB) NewContext() context.Context {
-	// TODO: Return cancel func?
-	ctx, _ := context.WithCancel(d.ctx)
-	return ctx
+	return d.ctx
+}
+
+// Path returns the path of the database file.
+func (d *DB) Path() string {
+	return d.path
+}
+
+// Migrate applies any pending migrations to an initialized database.
+func (d *DB) Migrate(logger *slog.Logger) error {
+	return migrator.RunMigrations(d, d.migrations, migrator.MigrationUp, "all", logger)
 }
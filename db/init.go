@@ -35,14 +35,15 @@ func (d *DB) Init(
 		return nil, err
 	}
 
-	serverTLSKeyEnc, err := crypto.EncryptSymInMemory(serverTLSKey, localUser.PrivateKey)
+	serverTLSKeyEnc, err := crypto.EncryptSymInMemory(serverTLSKey,
+		crypto.DeriveKey(localUser.PrivateKey, crypto.KeyPurposeServerTLS))
 	if err != nil {
 		return nil, fmt.Errorf("failed encrypting TLS private key: %w", err)
 	}
 
 	_, err = d.ExecContext(dbCtx,
-		`INSERT INTO _meta (version, server_tls_cert, server_tls_key_enc, server_tls_san)
-		VALUES (?, ?, ?, ?)`, appVersion, serverTLSCert, serverTLSKeyEnc, serverTLSSAN)
+		`INSERT INTO _meta (version, server_tls_cert, server_tls_key_enc, server_tls_san, key_version)
+		VALUES (?, ?, ?, ?, ?)`, appVersion, serverTLSCert, serverTLSKeyEnc, serverTLSSAN, keyVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +74,14 @@ func createRoles(ctx context.Context, d types.Querier) (map[string]*models.Role,
 						Patterns: []string{"*"},
 					},
 				},
+				{
+					Namespaces: map[string]struct{}{"*": {}},
+					Actions:    map[models.Action]struct{}{models.ActionRead: {}},
+					Target: models.PermissionTarget{
+						Resource: models.ResourceNamespace,
+						Patterns: []string{"*"},
+					},
+				},
 			},
 		},
 		{
@@ -86,6 +95,14 @@ func createRoles(ctx context.Context, d types.Querier) (map[string]*models.Role,
 						Patterns: []string{"*"},
 					},
 				},
+				{
+					Namespaces: map[string]struct{}{"*": {}},
+					Actions:    map[models.Action]struct{}{models.ActionAny: {}},
+					Target: models.PermissionTarget{
+						Resource: models.ResourceNamespace,
+						Patterns: []string{"*"},
+					},
+				},
 			},
 		},
 	}
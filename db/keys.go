@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/curve25519"
+
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/db/queries"
+	"go.hackfix.me/disco/db/types"
+)
+
+// keyVersion is the version of the scheme used for encrypting data with the
+// local user's private key. Since version 2, data is encrypted with subkeys
+// derived for each purpose, instead of with the private key itself.
+const keyVersion = 2
+
+// encryptedColumns are the columns whose values are encrypted with a subkey of
+// the local user's private key.
+var encryptedColumns = []struct {
+	table, column string
+	purpose       crypto.KeyPurpose
+}{
+	{"_meta", "server_tls_key_enc", crypto.KeyPurposeServerTLS},
+	{"invites", "privkey_enc", crypto.KeyPurposeInvites},
+	{"remotes", "tls_client_cert_enc", crypto.KeyPurposeRemotes},
+	{"remotes", "tls_client_key_enc", crypto.KeyPurposeRemotes},
+}
+
+// RotateKey re-encrypts all data encrypted with subkeys of the local user's
+// private key using subkeys of newKey, updates the public key and private key
+// hash of the local user, deletes all passphrase key slots, and wraps the new
+// key for all recovery keys. schema is the name of the database schema, which
+// allows running it on a connection where the database is attached.
+func RotateKey(ctx context.Context, q types.Querier, schema string, oldKey, newKey *[32]byte) error {
+	err := reencryptColumns(ctx, q, schema,
+		func(p crypto.KeyPurpose) *[32]byte { return crypto.DeriveKey(oldKey, p) },
+		func(p crypto.KeyPurpose) *[32]byte { return crypto.DeriveKey(newKey, p) })
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := curve25519.X25519(newKey[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, fmt.Sprintf(`UPDATE "%s".users
+		SET public_key = ?, private_key_hash = ?
+		WHERE type = ?`, schema),
+		base58.Encode(pubKey), base58.Encode(crypto.KeyHash(newKey)), models.UserTypeLocal)
+	if err != nil {
+		return fmt.Errorf("failed updating local user keys: %w", err)
+	}
+
+	// Key slots wrap the previous key, and their passphrases aren't known.
+	_, err = q.ExecContext(ctx, fmt.Sprintf(`DELETE FROM "%s".key_slots`, schema))
+	if err != nil {
+		return fmt.Errorf("failed deleting key slots: %w", err)
+	}
+
+	// Recovery keys only need the public keys of their recipients, so they're
+	// wrapped again. The table only exists in the Disco database, so it's
+	// found without the schema name.
+	recKeys, err := models.RecoveryKeys(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, rk := range recKeys {
+		if err = rk.Wrap(newKey); err != nil {
+			return fmt.Errorf("failed wrapping recovery key '%s': %w", rk.Name, err)
+		}
+		if err = rk.Save(ctx, q, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpgradeKeys re-encrypts all data encrypted with a previous version of the
+// key scheme. The private key hash must match encKey, which is verified with
+// the hash format of the previous version.
+func (d *DB) UpgradeKeys(encKey *[32]byte, logger *slog.Logger) error {
+	var version int
+	err := d.QueryRowContext(d.ctx, `SELECT key_version FROM _meta`).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if version >= keyVersion {
+		return nil
+	}
+
+	privKeyHash, err := queries.GetEncryptionPrivKeyHash(d.ctx, d)
+	if err != nil {
+		return fmt.Errorf("failed loading encryption key hash: %w", err)
+	}
+	legacyHash := crypto.Hash("encryption key hash", encKey[:])
+	if privKeyHash.V != base58.Encode(legacyHash) {
+		return errors.New("invalid encryption key")
+	}
+
+	return d.withTx(func(tx *tx) error {
+		ctx := tx.NewContext()
+		// Data was encrypted with the private key itself.
+		err := reencryptColumns(ctx, tx, "main",
+			func(crypto.KeyPurpose) *[32]byte { key := *encKey; return &key },
+			func(p crypto.KeyPurpose) *[32]byte { return crypto.DeriveKey(encKey, p) })
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET private_key_hash = ? WHERE type = ?`,
+			base58.Encode(crypto.KeyHash(encKey)), models.UserTypeLocal)
+		if err != nil {
+			return fmt.Errorf("failed updating local user key hash: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE _meta SET key_version = ?`, keyVersion)
+		if err != nil {
+			return err
+		}
+
+		logger.Debug("upgraded the key scheme", "from_version", version, "to_version", keyVersion)
+
+		return nil
+	})
+}
+
+// reencryptColumns re-encrypts the values of all encrypted columns in the
+// schema, decrypting them with the oldKey and encrypting them with the newKey
+// returned for the purpose of the column. The returned keys are wiped once the
+// column is re-encrypted.
+func reencryptColumns(
+	ctx context.Context, q types.Querier, schema string,
+	oldKey, newKey func(crypto.KeyPurpose) *[32]byte,
+) error {
+	for _, c := range encryptedColumns {
+		table := fmt.Sprintf(`"%s".%s`, schema, c.table)
+		oldSubkey, newSubkey := oldKey(c.purpose), newKey(c.purpose)
+		err := reencryptColumn(ctx, q, table, c.column, oldSubkey, newSubkey)
+		crypto.WipeKey(oldSubkey)
+		crypto.WipeKey(newSubkey)
+		if err != nil {
+			return fmt.Errorf("failed re-encrypting %s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	return nil
+}
+
+// reencryptColumn decrypts the values of the column in all rows of the table
+// with oldKey, and encrypts them with newKey.
+func reencryptColumn(ctx context.Context, q types.Querier, table, column string, oldKey, newKey *[32]byte) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`SELECT rowid, %s FROM %s WHERE %s IS NOT NULL`,
+		column, table, column))
+	if err != nil {
+		return err
+	}
+
+	values := map[int64][]byte{}
+	for rows.Next() {
+		var (
+			rowID int64
+			value []byte
+		)
+		if err = rows.Scan(&rowID, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		values[rowID] = value
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for rowID, value := range values {
+		plaintext, err := crypto.DecryptSymInMemory(value, oldKey)
+		if err != nil {
+			return err
+		}
+		newValue, err := crypto.EncryptSymInMemory(plaintext, newKey)
+		crypto.Wipe(plaintext)
+		if err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column),
+			newValue, rowID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1 @@
+ALTER TABLE _meta DROP COLUMN key_version;
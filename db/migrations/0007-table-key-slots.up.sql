@@ -0,0 +1,8 @@
+CREATE TABLE key_slots (
+  id          INTEGER       PRIMARY KEY,
+  created_at  TIMESTAMP     NOT NULL,
+  kdf         VARCHAR(16)   NOT NULL,
+  kdf_params  VARCHAR(64)   NOT NULL,
+  salt        BLOB          NOT NULL,
+  key_enc     BLOB          NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE recovery_keys (
+  id                   INTEGER       PRIMARY KEY,
+  name                 VARCHAR(32)   UNIQUE NOT NULL,
+  created_at           TIMESTAMP     NOT NULL,
+  public_key           VARCHAR(64)   NOT NULL,
+  ephemeral_public_key VARCHAR(64)   NOT NULL,
+  key_enc              BLOB          NOT NULL
+);
@@ -0,0 +1,3 @@
+DELETE FROM role_permissions
+  WHERE target = 'namespace:*'
+    AND role_id IN (SELECT id FROM roles WHERE name IN ('node', 'user'));
@@ -0,0 +1,6 @@
+-- Namespaces became a separate resource, so grant the default roles the same
+-- access to them that they had through the store resource.
+INSERT INTO role_permissions (role_id, namespaces, actions, target)
+  SELECT id, '*', 'r', 'namespace:*' FROM roles WHERE name = 'node';
+INSERT INTO role_permissions (role_id, namespaces, actions, target)
+  SELECT id, '*', '*', 'namespace:*' FROM roles WHERE name = 'user';
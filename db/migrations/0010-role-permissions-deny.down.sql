@@ -0,0 +1 @@
+ALTER TABLE role_permissions DROP COLUMN deny;
@@ -0,0 +1 @@
+ALTER TABLE role_permissions ADD COLUMN deny BOOLEAN NOT NULL DEFAULT FALSE;
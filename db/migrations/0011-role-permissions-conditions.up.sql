@@ -0,0 +1 @@
+ALTER TABLE role_permissions ADD COLUMN conditions VARCHAR(512) NOT NULL DEFAULT '';
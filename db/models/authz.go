@@ -0,0 +1,277 @@
+package models
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zpatrick/rbac"
+)
+
+// Decision is the result of an authorization check, along with the reason for
+// it.
+type Decision struct {
+	Allowed bool
+	// The role and permission that decided the result. These are only set if a
+	// permission allowed or denied the action; otherwise, the action is denied
+	// because no permission allowed it.
+	Role       string
+	Permission *Permission
+	// Permissions that didn't allow the action, but would have with fewer
+	// changes, ordered from closest to farthest. These are only set if no
+	// permission allowed or denied the action.
+	Candidates []*Candidate
+}
+
+// Candidate is a permission that didn't match an authorization check.
+type Candidate struct {
+	Role       string
+	Permission *Permission
+	// The parts of the permission that didn't match: "action", "namespace",
+	// "target" or "conditions".
+	Mismatch []string
+}
+
+// maxCandidates is the maximum number of candidates returned by User.Check.
+const maxCandidates = 5
+
+// Check returns whether the user is allowed to perform the action on the
+// target, in the given access context, and explains the decision. The target is
+// in "<namespace>:<resource>:<pattern>" format.
+func (u *User) Check(action, target string, actx *AccessContext) (*Decision, error) {
+	for _, role := range u.Roles {
+		perm, err := role.deniedBy(action, target, actx)
+		if err != nil {
+			return nil, err
+		}
+		if perm != nil {
+			return &Decision{Role: role.Name, Permission: perm}, nil
+		}
+	}
+
+	for _, role := range u.Roles {
+		perm, err := role.allowedBy(action, target, actx)
+		if err != nil {
+			return nil, err
+		}
+		if perm != nil {
+			return &Decision{Allowed: true, Role: role.Name, Permission: perm}, nil
+		}
+	}
+
+	candidates, err := u.candidates(action, target, actx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decision{Candidates: candidates}, nil
+}
+
+// CanAll returns true if the user is allowed to perform the action on all
+// targets matched by the target glob pattern, in the given access context.
+// Unlike Can, a deny permission that applies to any of these targets denies the
+// action, even if it doesn't match the pattern itself.
+func (u *User) CanAll(action, target string, actx *AccessContext) (bool, error) {
+	for _, role := range u.Roles {
+		if role.deniedWithin(action, target, actx) != nil {
+			return false, nil
+		}
+	}
+
+	// The pattern is matched as a literal string by the allow permissions, so
+	// one only matches it if it matches every target the pattern does.
+	return u.Can(action, target, actx)
+}
+
+// CheckGrant returns an error if the user isn't allowed to grant the allow
+// permission perm, along with the other granted permissions. This requires an
+// allow permission of the user that matches every action and target of perm in
+// the access context, and has conditions that are met whenever those of perm
+// are. Additionally, the deny permissions of the user that may apply to any of
+// these targets must also be granted, so that they can't be bypassed.
+func (u *User) CheckGrant(perm *Permission, granted []Permission, actx *AccessContext) error {
+	grantedRole := &Role{Permissions: granted}
+	grantedRole.compile()
+
+	for act := range perm.Actions {
+		for ns := range perm.Namespaces {
+			for _, pat := range perm.Target.Patterns {
+				target := fmt.Sprintf("%s:%s:%s", ns, perm.Target.Resource, pat)
+				if err := u.checkGrant(string(act), target, perm.Conditions, grantedRole.deny, actx); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (u *User) checkGrant(
+	action, target string, conds Conditions, grantedDeny globPermissions, actx *AccessContext,
+) error {
+	var allowed, laxer bool
+roles:
+	for _, role := range u.Roles {
+		role.compile()
+		for _, p := range role.allow {
+			if !p.applies(actx) {
+				continue
+			}
+			if ok, err := p.perm(action, target); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			if !conds.Within(p.source.Conditions) {
+				laxer = true
+				continue
+			}
+			allowed = true
+			break roles
+		}
+	}
+	if !allowed {
+		if laxer {
+			return fmt.Errorf("user '%s' is only authorized to %s %s under stricter conditions",
+				u.Name, action, target)
+		}
+		return fmt.Errorf("user '%s' is not authorized to %s %s", u.Name, action, target)
+	}
+
+	for _, role := range u.Roles {
+		for _, d := range role.deny {
+			if !globsOverlap(d.action, action) || !targetsOverlap(d.target, target) {
+				continue
+			}
+			// The granted deny permission must match everything the user's
+			// permission does, whenever it applies.
+			covered := slices.ContainsFunc(grantedDeny, func(g globPermission) bool {
+				ok, _ := g.perm(d.action, d.target)
+				return ok && d.source.Conditions.Within(g.source.Conditions)
+			})
+			if !covered {
+				permText, err := d.source.MarshalText()
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("user '%s' is denied by permission '%s' of role '%s', which must also be granted",
+					u.Name, permText, role.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// candidates returns the allow permissions for the resource of the target that
+// are closest to allowing the action on it.
+func (u *User) candidates(action, target string, actx *AccessContext) ([]*Candidate, error) {
+	parts := strings.SplitN(target, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid target '%s'", target)
+	}
+	namespace, resource, object := parts[0], Resource(parts[1]), parts[2]
+
+	anyMatch := func(value string, patterns []string) bool {
+		for _, pat := range patterns {
+			if ok, _ := rbac.GlobMatch(pat)(value); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidates := []*Candidate{}
+	for _, role := range u.Roles {
+		for i, perm := range role.Permissions {
+			if perm.Deny || (perm.Target.Resource != resource && perm.Target.Resource != ResourceAny) {
+				continue
+			}
+
+			actions := make([]string, 0, len(perm.Actions))
+			for act := range perm.Actions {
+				actions = append(actions, string(act))
+			}
+			namespaces := make([]string, 0, len(perm.Namespaces))
+			for ns := range perm.Namespaces {
+				namespaces = append(namespaces, ns)
+			}
+
+			mismatch := []string{}
+			if !anyMatch(action, actions) {
+				mismatch = append(mismatch, "action")
+			}
+			if !anyMatch(namespace, namespaces) {
+				mismatch = append(mismatch, "namespace")
+			}
+			if !anyMatch(object, perm.Target.Patterns) {
+				mismatch = append(mismatch, "target")
+			}
+			if !perm.Conditions.Match(actx) {
+				mismatch = append(mismatch, "conditions")
+			}
+
+			candidates = append(candidates, &Candidate{
+				Role: role.Name, Permission: &role.Permissions[i], Mismatch: mismatch,
+			})
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b *Candidate) int {
+		return cmp.Compare(len(a.Mismatch), len(b.Mismatch))
+	})
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	return candidates, nil
+}
+
+// targetsOverlap returns true if there's a target matched by both target glob
+// patterns, in "<namespace>:<resource>:<pattern>" format. The parts are
+// compared separately, since a wildcard namespace doesn't span the others.
+func targetsOverlap(a, b string) bool {
+	aParts, bParts := strings.SplitN(a, ":", 3), strings.SplitN(b, ":", 3)
+	if len(aParts) != 3 || len(bParts) != 3 {
+		return globsOverlap(a, b)
+	}
+	for i := range aParts {
+		if !globsOverlap(aParts[i], bParts[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// globsOverlap returns true if there's a string matched by both glob patterns,
+// where '*' matches any sequence of characters.
+func globsOverlap(a, b string) bool {
+	// seen records the positions in both patterns that were already visited.
+	seen := make(map[[2]int]struct{})
+	var match func(i, j int) bool
+	match = func(i, j int) bool {
+		if _, ok := seen[[2]int{i, j}]; ok {
+			return false
+		}
+		seen[[2]int{i, j}] = struct{}{}
+
+		switch {
+		case i == len(a) && j == len(b):
+			return true
+		case i < len(a) && a[i] == '*':
+			// The wildcard matches nothing more, or the next character of b.
+			return match(i+1, j) || (j < len(b) && match(i, j+1))
+		case j < len(b) && b[j] == '*':
+			return match(i, j+1) || (i < len(a) && match(i+1, j))
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			return match(i+1, j+1)
+		}
+
+		return false
+	}
+
+	return match(0, 0)
+}
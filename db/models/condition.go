@@ -0,0 +1,429 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	// Embed the time zone database, since the system one might not be
+	// accessible when the server is sandboxed.
+	_ "time/tzdata"
+)
+
+// AccessContext contains the attributes of an access request that permission
+// conditions are evaluated against.
+type AccessContext struct {
+	Addr netip.Addr // source IP address; the zero value if unknown
+	Time time.Time
+}
+
+// Conditions restrict when a permission applies. A permission applies only if
+// all of its conditions are met. An empty set of conditions is always met.
+type Conditions struct {
+	// The source address must be in one of these IP ranges.
+	CIDRs []netip.Prefix
+	// The access must happen within one of these time windows.
+	Times []TimeWindow
+}
+
+// Match returns true if the access context meets the conditions. If actx is
+// nil, only an empty set of conditions is met. Conditions on attributes that
+// are unknown, such as a missing source address, are not met.
+func (c Conditions) Match(actx *AccessContext) bool {
+	return c.match(actx, false)
+}
+
+// MayMatch is like Match, except that conditions on unknown attributes are
+// met. Deny permissions are evaluated with it, so that they fail closed.
+func (c Conditions) MayMatch(actx *AccessContext) bool {
+	return c.match(actx, true)
+}
+
+func (c Conditions) match(actx *AccessContext, unknown bool) bool {
+	if c.IsZero() {
+		return true
+	}
+	if actx == nil {
+		return unknown
+	}
+
+	if len(c.CIDRs) > 0 {
+		addr := actx.Addr.Unmap()
+		if !addr.IsValid() {
+			if !unknown {
+				return false
+			}
+		} else if !slices.ContainsFunc(c.CIDRs, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			return false
+		}
+	}
+
+	if len(c.Times) > 0 {
+		if !slices.ContainsFunc(c.Times, func(tw TimeWindow) bool { return tw.Contains(actx.Time) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Within returns true if the conditions are only met when the other conditions
+// are also met, i.e. if they're at least as strict.
+func (c Conditions) Within(other Conditions) bool {
+	if len(other.CIDRs) > 0 {
+		if len(c.CIDRs) == 0 {
+			return false
+		}
+		for _, p := range c.CIDRs {
+			if !slices.ContainsFunc(other.CIDRs, func(op netip.Prefix) bool {
+				return op.Bits() <= p.Bits() && op.Contains(p.Addr())
+			}) {
+				return false
+			}
+		}
+	}
+
+	if len(other.Times) > 0 {
+		if len(c.Times) == 0 {
+			return false
+		}
+		for _, tw := range c.Times {
+			if !slices.ContainsFunc(other.Times, tw.within) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// IsZero returns true if there are no conditions.
+func (c Conditions) IsZero() bool {
+	return len(c.CIDRs) == 0 && len(c.Times) == 0
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for Conditions.
+// Conditions are separated by semicolons, e.g.
+// "cidr=10.0.0.0/8,192.168.1.0/24;time=Mon-Fri 09:00-17:00".
+func (c Conditions) MarshalText() ([]byte, error) {
+	conds := []string{}
+	if len(c.CIDRs) > 0 {
+		cidrs := make([]string, len(c.CIDRs))
+		for i, p := range c.CIDRs {
+			cidrs[i] = p.String()
+		}
+		conds = append(conds, "cidr="+strings.Join(cidrs, ","))
+	}
+	for _, tw := range c.Times {
+		conds = append(conds, "time="+tw.String())
+	}
+
+	return []byte(strings.Join(conds, ";")), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for
+// Conditions.
+func (c *Conditions) UnmarshalText(text []byte) error {
+	conds := Conditions{}
+	if len(text) == 0 {
+		*c = conds
+		return nil
+	}
+
+	for _, cond := range strings.Split(string(text), ";") {
+		key, val, ok := strings.Cut(cond, "=")
+		if !ok {
+			return fmt.Errorf("invalid condition '%s': must be in 'key=value' format", cond)
+		}
+		switch key {
+		case "cidr":
+			for _, cidr := range strings.Split(val, ",") {
+				var p netip.Prefix
+				if err := p.UnmarshalText([]byte(cidr)); err != nil {
+					return fmt.Errorf("invalid CIDR condition: %w", err)
+				}
+				conds.CIDRs = append(conds.CIDRs, p)
+			}
+		case "time":
+			var tw TimeWindow
+			if err := tw.UnmarshalText([]byte(val)); err != nil {
+				return err
+			}
+			conds.Times = append(conds.Times, tw)
+		default:
+			return fmt.Errorf("invalid condition '%s'", key)
+		}
+	}
+
+	*c = conds
+
+	return nil
+}
+
+// TimeWindow is a period of time. It's either an absolute period between two
+// points in time, or a period that recurs on certain days of the week and/or
+// at certain times of the day.
+type TimeWindow struct {
+	// Absolute period. A zero value means the period is unbounded on that side.
+	Start, End time.Time
+
+	// Recurring period. If Days is empty, the period recurs every day. If
+	// From and To are both zero, it lasts the whole day. If To is not after
+	// From, the period ends on the next day.
+	Recurring bool
+	Days      []time.Weekday
+	From, To  time.Duration // offsets from midnight
+	Location  *time.Location
+}
+
+// Contains returns true if t is within the time window.
+func (tw TimeWindow) Contains(t time.Time) bool {
+	if !tw.Recurring {
+		return (tw.Start.IsZero() || !t.Before(tw.Start)) &&
+			(tw.End.IsZero() || t.Before(tw.End))
+	}
+
+	t = t.In(tw.Location)
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	dayOK := func(d time.Weekday) bool {
+		return len(tw.Days) == 0 || slices.Contains(tw.Days, d)
+	}
+
+	if tw.From == 0 && tw.To == 0 {
+		return dayOK(t.Weekday())
+	}
+	if tw.From < tw.To {
+		return tod >= tw.From && tod < tw.To && dayOK(t.Weekday())
+	}
+
+	// The period spans midnight, so it's either the part on the day it
+	// started, or the part on the following day.
+	return (tod >= tw.From && dayOK(t.Weekday())) ||
+		(tod < tw.To && dayOK((t.Weekday()+6)%7))
+}
+
+// within returns true if the time window is contained in the other one.
+// Recurring windows are only compared for equality.
+func (tw TimeWindow) within(other TimeWindow) bool {
+	if tw.Recurring || other.Recurring {
+		return tw.String() == other.String()
+	}
+
+	return (other.Start.IsZero() || (!tw.Start.IsZero() && !tw.Start.Before(other.Start))) &&
+		(other.End.IsZero() || (!tw.End.IsZero() && !tw.End.After(other.End)))
+}
+
+// String returns the text representation of the time window. Absolute windows
+// are formatted as "<start>/<end>" in RFC 3339 format, and recurring ones as
+// "[<days>] [<from>-<to>] [<location>]".
+func (tw TimeWindow) String() string {
+	if !tw.Recurring {
+		var start, end string
+		if !tw.Start.IsZero() {
+			start = tw.Start.Format(time.RFC3339)
+		}
+		if !tw.End.IsZero() {
+			end = tw.End.Format(time.RFC3339)
+		}
+		return start + "/" + end
+	}
+
+	parts := []string{}
+	if len(tw.Days) > 0 {
+		parts = append(parts, formatDays(tw.Days))
+	}
+	if tw.From != 0 || tw.To != 0 {
+		parts = append(parts, fmt.Sprintf("%s-%s", formatTimeOfDay(tw.From), formatTimeOfDay(tw.To)))
+	}
+	if tw.Location != time.UTC {
+		parts = append(parts, tw.Location.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for TimeWindow.
+func (tw TimeWindow) MarshalText() ([]byte, error) {
+	return []byte(tw.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for
+// TimeWindow. See TimeWindow.String for the supported formats. Days are
+// specified as a comma-separated list of day names or ranges, e.g.
+// "Mon-Fri,Sun". Times of day are in 24-hour "HH:MM" format, and are in UTC,
+// unless a time zone name, e.g. "Europe/Berlin", is specified.
+func (tw *TimeWindow) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	errInvalid := func(err error) error {
+		return fmt.Errorf("invalid time window '%s': %w", s, err)
+	}
+
+	if start, end, ok := strings.Cut(s, "/"); ok && !strings.Contains(s, " ") {
+		if abs, err := parseAbsoluteWindow(start, end); err == nil {
+			*tw = abs
+			return nil
+		} else if start == "" || start[0] >= '0' && start[0] <= '9' {
+			return errInvalid(err)
+		}
+	}
+
+	win := TimeWindow{Recurring: true, Location: time.UTC}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return errInvalid(errors.New("empty value"))
+	}
+	var hasDays, hasHours bool
+	for i, f := range fields {
+		switch {
+		case !hasDays && !hasHours && i == 0 && f[0] >= 'A' && !strings.Contains(f, "/"):
+			days, err := parseDays(f)
+			if err != nil {
+				return errInvalid(err)
+			}
+			win.Days = days
+			hasDays = true
+		case !hasHours && strings.Contains(f, ":"):
+			from, to, ok := strings.Cut(f, "-")
+			if !ok {
+				return errInvalid(fmt.Errorf("invalid time range '%s'", f))
+			}
+			var err error
+			if win.From, err = parseTimeOfDay(from); err != nil {
+				return errInvalid(err)
+			}
+			if win.To, err = parseTimeOfDay(to); err != nil {
+				return errInvalid(err)
+			}
+			if win.From == win.To {
+				return errInvalid(errors.New("time range must not be empty"))
+			}
+			hasHours = true
+		case i == len(fields)-1 && (hasDays || hasHours):
+			loc, err := time.LoadLocation(f)
+			if err != nil {
+				return errInvalid(err)
+			}
+			win.Location = loc
+		default:
+			return errInvalid(fmt.Errorf("unexpected '%s'", f))
+		}
+	}
+
+	*tw = win
+
+	return nil
+}
+
+func parseAbsoluteWindow(start, end string) (TimeWindow, error) {
+	var (
+		tw  TimeWindow
+		err error
+	)
+	if start != "" {
+		if tw.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return tw, err
+		}
+	}
+	if end != "" {
+		if tw.End, err = time.Parse(time.RFC3339, end); err != nil {
+			return tw, err
+		}
+	}
+	if start == "" && end == "" {
+		return tw, errors.New("start or end time must be specified")
+	}
+	if !tw.Start.IsZero() && !tw.End.IsZero() && !tw.End.After(tw.Start) {
+		return tw, errors.New("end time must be after start time")
+	}
+
+	return tw, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses a comma-separated list of day names or ranges, and returns
+// the days sorted from Sunday to Saturday.
+func parseDays(s string) ([]time.Weekday, error) {
+	parseDay := func(d string) (time.Weekday, error) {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return 0, fmt.Errorf("invalid day '%s'", d)
+		}
+		return day, nil
+	}
+
+	set := map[time.Weekday]struct{}{}
+	for _, dr := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(dr, "-")
+		start, err := parseDay(from)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = parseDay(to); err != nil {
+				return nil, err
+			}
+		}
+		// Ranges can wrap around the end of the week, e.g. Fri-Mon.
+		for d := start; ; d = (d + 1) % 7 {
+			set[d] = struct{}{}
+			if d == end {
+				break
+			}
+		}
+	}
+
+	days := make([]time.Weekday, 0, len(set))
+	for d := range set {
+		days = append(days, d)
+	}
+	slices.Sort(days)
+
+	return days, nil
+}
+
+// formatDays returns the sorted days as a comma-separated list, where runs of
+// 3 or more consecutive days are shortened to ranges, e.g. "Mon-Fri,Sun".
+func formatDays(days []time.Weekday) string {
+	name := func(d time.Weekday) string { return d.String()[:3] }
+
+	out := []string{}
+	for i := 0; i < len(days); {
+		j := i
+		for j+1 < len(days) && days[j+1] == days[j]+1 {
+			j++
+		}
+		if j-i >= 2 {
+			out = append(out, name(days[i])+"-"+name(days[j]))
+		} else {
+			for _, d := range days[i : j+1] {
+				out = append(out, name(d))
+			}
+		}
+		i = j + 1
+	}
+
+	return strings.Join(out, ",")
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
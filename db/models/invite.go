@@ -34,8 +34,8 @@ type Invite struct {
 // created that must be supplied when authenticating to the server. The token is
 // constructed by concatenating random 32 bytes and an ephemeral X25519
 // public key, encoded as a base 58 string.
-// The encryptionKey is a separate persistent symmetric key used for encrypting
-// the X25519 private key.
+// The encryptionKey is the encryption key of the local user, from which the key
+// used for encrypting the X25519 private key is derived.
 func NewInvite(user *User, ttl time.Duration, uuidgen func() string, encryptionKey *[32]byte) (*Invite, error) {
 	privKey, err := ecdh.X25519().GenerateKey(rand.Reader)
 	if err != nil {
@@ -47,7 +47,8 @@ func NewInvite(user *User, ttl time.Duration, uuidgen func() string, encryptionK
 	}
 
 	privKeyR := bytes.NewReader(privKey.Bytes())
-	privKeyEnc, err := crypto.EncryptSym(privKeyR, encryptionKey)
+	privKeyEnc, err := crypto.EncryptSym(privKeyR,
+		crypto.DeriveKey(encryptionKey, crypto.KeyPurposeInvites))
 	if err != nil {
 		return nil, err
 	}
@@ -186,16 +187,16 @@ func (inv *Invite) TokenComposite() (string, error) {
 	return base58.Encode(slices.Concat(tokenDec, pubKeyDec)), nil
 }
 
-// PrivateKey returns the decrypted X25519 private key.
+// PrivateKey returns the decrypted X25519 private key. The encryptionKey is the
+// encryption key of the local user.
 func (inv *Invite) PrivateKey(encryptionKey *[32]byte) (*ecdh.PrivateKey, error) {
-	privKeyDataR, err := crypto.DecryptSym(bytes.NewReader(inv.privKeyEnc), encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-	privKeyData, err := io.ReadAll(privKeyDataR)
+	invitesKey := crypto.DeriveKey(encryptionKey, crypto.KeyPurposeInvites)
+	defer crypto.WipeKey(invitesKey)
+	privKeyData, err := crypto.DecryptSymInMemory(inv.privKeyEnc, invitesKey)
 	if err != nil {
 		return nil, err
 	}
+	defer crypto.Wipe(privKeyData)
 	privKey, err := ecdh.X25519().NewPrivateKey(privKeyData)
 	if err != nil {
 		return nil, err
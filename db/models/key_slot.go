@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/types"
+)
+
+// KeySlot stores the encryption key wrapped with a key derived from a
+// passphrase. Any of the key slots can be used to unlock the encryption key.
+type KeySlot struct {
+	ID        uint64
+	CreatedAt time.Time
+	KDF       *crypto.KDFParams
+
+	// Encryption key encrypted with the passphrase key
+	keyEnc []byte
+}
+
+// NewKeySlot creates a new key slot that wraps encKey with a key derived from
+// the passphrase using kdf.
+func NewKeySlot(encKey *[32]byte, passphrase []byte, kdf crypto.KDF) (*KeySlot, error) {
+	params, err := crypto.NewKDFParams(kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySlot{CreatedAt: time.Now().UTC(), KDF: params}
+	if err = ks.wrap(encKey, passphrase); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Unlock returns the encryption key wrapped by the key slot. It fails if the
+// passphrase is incorrect.
+func (ks *KeySlot) Unlock(passphrase []byte) (*[32]byte, error) {
+	passKey, err := ks.KDF.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.WipeKey(passKey)
+
+	keyData, err := crypto.DecryptSymInMemory(ks.keyEnc, passKey,
+		crypto.WithAssociatedData([]byte("key slot")))
+	if err != nil {
+		return nil, errors.New("invalid passphrase")
+	}
+	defer crypto.Wipe(keyData)
+	if len(keyData) != 32 {
+		return nil, fmt.Errorf("invalid encryption key length: %d", len(keyData))
+	}
+
+	encKey := new([32]byte)
+	copy(encKey[:], keyData)
+
+	return encKey, nil
+}
+
+// ChangePassphrase wraps encKey with a key derived from the new passphrase,
+// using a new salt.
+func (ks *KeySlot) ChangePassphrase(encKey *[32]byte, passphrase []byte) error {
+	params, err := crypto.NewKDFParams(ks.KDF.KDF)
+	if err != nil {
+		return err
+	}
+	ks.KDF = params
+
+	return ks.wrap(encKey, passphrase)
+}
+
+func (ks *KeySlot) wrap(encKey *[32]byte, passphrase []byte) error {
+	passKey, err := ks.KDF.DeriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	defer crypto.WipeKey(passKey)
+
+	ks.keyEnc, err = crypto.EncryptSymInMemory(encKey[:], passKey,
+		crypto.WithAssociatedData([]byte("key slot")))
+	if err != nil {
+		return fmt.Errorf("failed encrypting encryption key: %w", err)
+	}
+
+	return nil
+}
+
+// Save stores the key slot data in the database. If update is true, the key
+// slot ID must be set for the lookup.
+func (ks *KeySlot) Save(ctx context.Context, d types.Querier, update bool) error {
+	if update {
+		res, err := d.ExecContext(ctx,
+			`UPDATE key_slots SET kdf = ?, kdf_params = ?, salt = ?, key_enc = ? WHERE id = ?`,
+			ks.KDF.KDF, ks.KDF.String(), ks.KDF.Salt, ks.keyEnc, ks.ID)
+		if err != nil {
+			return fmt.Errorf("failed updating key slot %d: %w", ks.ID, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return types.ErrNoResult{Msg: fmt.Sprintf("key slot %d doesn't exist", ks.ID)}
+		}
+
+		return nil
+	}
+
+	res, err := d.ExecContext(ctx,
+		`INSERT INTO key_slots (id, created_at, kdf, kdf_params, salt, key_enc)
+		VALUES (NULL, ?, ?, ?, ?, ?)`,
+		ks.CreatedAt, ks.KDF.KDF, ks.KDF.String(), ks.KDF.Salt, ks.keyEnc)
+	if err != nil {
+		return fmt.Errorf("failed saving new key slot: %w", err)
+	}
+
+	ksID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	ks.ID = uint64(ksID)
+
+	return nil
+}
+
+// Delete removes the key slot record from the database. The key slot ID must
+// be set for the lookup.
+func (ks *KeySlot) Delete(ctx context.Context, d types.Querier) error {
+	res, err := d.ExecContext(ctx, `DELETE FROM key_slots WHERE id = ?`, ks.ID)
+	if err != nil {
+		return fmt.Errorf("failed deleting key slot %d: %w", ks.ID, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNoResult{Msg: fmt.Sprintf("key slot %d doesn't exist", ks.ID)}
+	}
+
+	return nil
+}
+
+// KeySlots returns all key slots from the database.
+func KeySlots(ctx context.Context, d types.Querier) ([]*KeySlot, error) {
+	rows, err := d.QueryContext(ctx,
+		`SELECT id, created_at, kdf, kdf_params, salt, key_enc FROM key_slots ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading key slots: %w", err)
+	}
+	defer rows.Close()
+
+	slots := []*KeySlot{}
+	for rows.Next() {
+		var (
+			ks        = &KeySlot{}
+			kdf       string
+			kdfParams string
+			salt      []byte
+		)
+		err = rows.Scan(&ks.ID, &ks.CreatedAt, &kdf, &kdfParams, &salt, &ks.keyEnc)
+		if err != nil {
+			return nil, fmt.Errorf("failed scanning key slot data: %w", err)
+		}
+		ks.KDF, err = crypto.ParseKDFParams(crypto.KDF(kdf), kdfParams, salt)
+		if err != nil {
+			return nil, fmt.Errorf("key slot %d: %w", ks.ID, err)
+		}
+		slots = append(slots, ks)
+	}
+
+	return slots, rows.Err()
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/types"
+)
+
+// RecoveryKey stores the encryption key wrapped to the X25519 public key of a
+// recovery recipient, e.g. an admin that keeps the private key offline. The
+// recipient can recover access to the data with their private key, without
+// knowing the encryption key.
+type RecoveryKey struct {
+	ID        uint64
+	Name      string
+	CreatedAt time.Time
+	PublicKey *[32]byte
+
+	// Public key of the ephemeral key pair the encryption key was wrapped
+	// with.
+	ephemeralPubKey *[32]byte
+	// Encryption key encrypted with the key shared between the ephemeral key
+	// pair and the recipient's key pair.
+	keyEnc []byte
+}
+
+// NewRecoveryKey creates a new recovery key that wraps encKey to the
+// recipient's public key.
+func NewRecoveryKey(name string, pubKey, encKey *[32]byte) (*RecoveryKey, error) {
+	rk := &RecoveryKey{Name: name, CreatedAt: time.Now().UTC(), PublicKey: pubKey}
+	if err := rk.Wrap(encKey); err != nil {
+		return nil, err
+	}
+
+	return rk, nil
+}
+
+// Wrap encrypts encKey to the recipient's public key with a new ephemeral key
+// pair, replacing the previously wrapped key. Only the recipient's private key
+// can unwrap it.
+func (rk *RecoveryKey) Wrap(encKey *[32]byte) error {
+	ephPubKey, ephPrivKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed generating ephemeral key pair: %w", err)
+	}
+	defer clear(ephPrivKey[:])
+
+	encData, err := crypto.EncryptAsym(bytes.NewReader(encKey[:]), rk.PublicKey, ephPrivKey,
+		crypto.WithAssociatedData([]byte("recovery key")))
+	if err != nil {
+		return fmt.Errorf("failed encrypting encryption key: %w", err)
+	}
+	keyEnc, err := io.ReadAll(encData)
+	if err != nil {
+		return fmt.Errorf("failed encrypting encryption key: %w", err)
+	}
+
+	rk.ephemeralPubKey = ephPubKey
+	rk.keyEnc = keyEnc
+
+	return nil
+}
+
+// Unlock returns the encryption key wrapped by the recovery key. It fails if
+// privKey isn't the recipient's private key.
+func (rk *RecoveryKey) Unlock(privKey *[32]byte) (*[32]byte, error) {
+	pubKey, err := curve25519.X25519(privKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pubKey, rk.PublicKey[:]) {
+		return nil, errors.New("the private key doesn't match the recovery key")
+	}
+
+	decData, err := crypto.DecryptAsym(bytes.NewReader(rk.keyEnc), rk.ephemeralPubKey, privKey,
+		crypto.WithAssociatedData([]byte("recovery key")))
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting encryption key: %w", err)
+	}
+	defer decData.Close()
+	keyData, err := io.ReadAll(decData)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting encryption key: %w", err)
+	}
+	if len(keyData) != 32 {
+		return nil, fmt.Errorf("invalid encryption key length: %d", len(keyData))
+	}
+
+	encKey := new([32]byte)
+	copy(encKey[:], keyData)
+	clear(keyData)
+
+	return encKey, nil
+}
+
+// Save stores the recovery key data in the database. If update is true, the
+// recovery key ID must be set for the lookup, and only the wrapped key is
+// updated.
+func (rk *RecoveryKey) Save(ctx context.Context, d types.Querier, update bool) error {
+	if update {
+		res, err := d.ExecContext(ctx,
+			`UPDATE recovery_keys SET ephemeral_public_key = ?, key_enc = ? WHERE id = ?`,
+			base58.Encode(rk.ephemeralPubKey[:]), rk.keyEnc, rk.ID)
+		if err != nil {
+			return fmt.Errorf("failed updating recovery key '%s': %w", rk.Name, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return types.ErrNoResult{Msg: fmt.Sprintf("recovery key %d doesn't exist", rk.ID)}
+		}
+
+		return nil
+	}
+
+	res, err := d.ExecContext(ctx,
+		`INSERT INTO recovery_keys (id, name, created_at, public_key, ephemeral_public_key, key_enc)
+		VALUES (NULL, ?, ?, ?, ?, ?)`,
+		rk.Name, rk.CreatedAt, base58.Encode(rk.PublicKey[:]),
+		base58.Encode(rk.ephemeralPubKey[:]), rk.keyEnc)
+	if err != nil {
+		return fmt.Errorf("failed saving new recovery key: %w", err)
+	}
+
+	rkID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rk.ID = uint64(rkID)
+
+	return nil
+}
+
+// Delete removes the recovery key record from the database. The recovery key
+// name must be set for the lookup.
+func (rk *RecoveryKey) Delete(ctx context.Context, d types.Querier) error {
+	res, err := d.ExecContext(ctx, `DELETE FROM recovery_keys WHERE name = ?`, rk.Name)
+	if err != nil {
+		return fmt.Errorf("failed deleting recovery key '%s': %w", rk.Name, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNoResult{Msg: fmt.Sprintf("recovery key '%s' doesn't exist", rk.Name)}
+	}
+
+	return nil
+}
+
+// RecoveryKeys returns all recovery keys from the database.
+func RecoveryKeys(ctx context.Context, d types.Querier) ([]*RecoveryKey, error) {
+	rows, err := d.QueryContext(ctx,
+		`SELECT id, name, created_at, public_key, ephemeral_public_key, key_enc
+		FROM recovery_keys ORDER BY name ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading recovery keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*RecoveryKey{}
+	for rows.Next() {
+		var (
+			rk           = &RecoveryKey{}
+			pubKey       string
+			ephPubKeyEnc string
+		)
+		err = rows.Scan(&rk.ID, &rk.Name, &rk.CreatedAt, &pubKey, &ephPubKeyEnc, &rk.keyEnc)
+		if err != nil {
+			return nil, fmt.Errorf("failed scanning recovery key data: %w", err)
+		}
+		if rk.PublicKey, err = crypto.DecodeKey(pubKey); err != nil {
+			return nil, fmt.Errorf("recovery key '%s': invalid public key: %w", rk.Name, err)
+		}
+		if rk.ephemeralPubKey, err = crypto.DecodeKey(ephPubKeyEnc); err != nil {
+			return nil, fmt.Errorf("recovery key '%s': invalid ephemeral public key: %w", rk.Name, err)
+		}
+		keys = append(keys, rk)
+	}
+
+	return keys, rows.Err()
+}
@@ -119,10 +119,29 @@ func (r *Remote) Load(ctx context.Context, d types.Querier) error {
 // Delete removes the remote record from the database. Either the remote ID or
 // name must be set for the lookup.
 func (r *Remote) Delete(ctx context.Context, d types.Querier) error {
+	filter, filterStr, err := r.createFilter(ctx, d, 1)
+	if err != nil {
+		return fmt.Errorf("failed deleting remote: %w", err)
+	}
+
+	stmt := fmt.Sprintf(`DELETE FROM remotes WHERE %s`, filter.Where)
+	res, err := d.ExecContext(ctx, stmt, filter.Args...)
+	if err != nil {
+		return fmt.Errorf("failed deleting remote with %s: %w", filterStr, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNoResult{Msg: fmt.Sprintf("remote with %s doesn't exist", filterStr)}
+	}
+
 	return nil
 }
 
-// ClientTLSConfig returns the TLS client configuration.
+// ClientTLSConfig returns the TLS client configuration. encKey is the
+// encryption key of the local user, from which the key used for decrypting the
+// TLS client certificate is derived.
 func (r *Remote) ClientTLSConfig(encKey *[32]byte) (*tls.Config, error) {
 	tlsConfig := crypto.DefaultTLSConfig()
 
@@ -143,15 +162,18 @@ func (r *Remote) ClientTLSConfig(encKey *[32]byte) (*tls.Config, error) {
 // clientTLSCert returns the unencrypted TLS client certificate and private key
 // pair.
 func (r *Remote) clientTLSCert(encKey *[32]byte) (*tls.Certificate, error) {
-	tlsClientCert, err := crypto.DecryptSymInMemory(r.tlsClientCertEnc, encKey)
+	remotesKey := crypto.DeriveKey(encKey, crypto.KeyPurposeRemotes)
+	defer crypto.WipeKey(remotesKey)
+	tlsClientCert, err := crypto.DecryptSymInMemory(r.tlsClientCertEnc, remotesKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed decrypting TLS client certificate: %w", err)
 	}
 
-	tlsClientKey, err := crypto.DecryptSymInMemory(r.tlsClientKeyEnc, encKey)
+	tlsClientKey, err := crypto.DecryptSymInMemory(r.tlsClientKeyEnc, remotesKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed decrypting TLS client private key: %w", err)
 	}
+	defer crypto.Wipe(tlsClientKey)
 
 	certPair, err := tls.X509KeyPair(tlsClientCert, tlsClientKey)
 	if err != nil {
@@ -46,10 +46,14 @@ func ActionFromString(act string) (Action, error) {
 type Resource string
 
 const (
-	ResourceStore Resource = "store"
-	ResourceUser  Resource = "user"
-	ResourceRole  Resource = "role"
-	ResourceAny   Resource = "*"
+	ResourceStore     Resource = "store"
+	ResourceNamespace Resource = "namespace"
+	ResourceUser      Resource = "user"
+	ResourceRole      Resource = "role"
+	ResourceInvite    Resource = "invite"
+	ResourceRemote    Resource = "remote"
+	ResourceSys       Resource = "sys"
+	ResourceAny       Resource = "*"
 )
 
 // ResourceFromString returns a valid Resource from a string value.
@@ -57,10 +61,18 @@ func ResourceFromString(res string) (Resource, error) {
 	switch res {
 	case "store":
 		return ResourceStore, nil
+	case "namespace":
+		return ResourceNamespace, nil
 	case "user":
 		return ResourceUser, nil
 	case "role":
 		return ResourceRole, nil
+	case "invite":
+		return ResourceInvite, nil
+	case "remote":
+		return ResourceRemote, nil
+	case "sys":
+		return ResourceSys, nil
 	case "*":
 		return ResourceAny, nil
 	default:
@@ -75,7 +87,8 @@ type Role struct {
 	Name        string
 	Permissions []Permission
 
-	role *rbac.Role
+	allow globPermissions
+	deny  globPermissions
 }
 
 // Permission is a combination of access rules. It declares the actions allowed
@@ -84,10 +97,15 @@ type Role struct {
 // The target can either be a static resource name, or a pattern that includes
 // wildcards, e.g. 'store:myapp/*'. Namespaces and actions can also be a
 // wildcard, to allow any action in any namespace (e.g. for admin roles).
+// If Deny is true, the permission denies the actions instead, which takes
+// precedence over any permission that allows them.
+// The permission only applies if its conditions are met.
 type Permission struct {
 	Namespaces map[string]struct{}
 	Actions    map[Action]struct{}
 	Target     PermissionTarget
+	Deny       bool
+	Conditions Conditions
 }
 
 type PermissionTarget struct {
@@ -129,7 +147,7 @@ func (r *Role) Save(ctx context.Context, d types.Querier, update bool) error {
 		}
 	}
 
-	stmt := `INSERT INTO role_permissions (role_id, namespaces, actions, target) VALUES `
+	stmt := `INSERT INTO role_permissions (role_id, namespaces, actions, target, deny, conditions) VALUES `
 	values := []string{}
 	for _, perm := range r.Permissions {
 		namespaces := make([]string, 0, len(perm.Namespaces))
@@ -148,14 +166,20 @@ func (r *Role) Save(ctx context.Context, d types.Querier, update bool) error {
 		}
 		slices.Sort(actions)
 
-		values = append(values, `(:role_id, ?, ?, ?)`)
+		conditions, err := perm.Conditions.MarshalText()
+		if err != nil {
+			return err
+		}
+
+		values = append(values, `(:role_id, ?, ?, ?, ?, ?)`)
 		var target string
 		if perm.Target.Resource == ResourceAny {
 			target = "*"
 		} else {
 			target = fmt.Sprintf("%s:%s", perm.Target.Resource, strings.Join(perm.Target.Patterns, ","))
 		}
-		args = append(args, strings.Join(namespaces, ","), string(actions), target)
+		args = append(args, strings.Join(namespaces, ","), string(actions), target,
+			perm.Deny, string(conditions))
 	}
 
 	stmt = fmt.Sprintf("%s %s", stmt, strings.Join(values, ", "))
@@ -168,25 +192,127 @@ func (r *Role) Save(ctx context.Context, d types.Querier, update bool) error {
 	return nil
 }
 
-// Can returns true if the role is allowed to perform the action on the target.
-func (r *Role) Can(action, target string) (bool, error) {
-	if r.role == nil {
-		perms := []rbac.Permission{}
-		for _, perm := range r.Permissions {
-			for act := range perm.Actions {
-				for ns := range perm.Namespaces {
-					for _, pat := range perm.Target.Patterns {
-						perms = append(perms,
-							rbac.NewGlobPermission(string(act),
-								fmt.Sprintf("%s:%s:%s", ns, perm.Target.Resource, pat)))
-					}
+// Can returns true if the role is allowed to perform the action on the target,
+// and doesn't deny it. Permissions with conditions only apply if the access
+// context meets them.
+func (r *Role) Can(action, target string, actx *AccessContext) (bool, error) {
+	if denied, err := r.Denies(action, target, actx); err != nil || denied {
+		return false, err
+	}
+
+	perm, err := r.allowedBy(action, target, actx)
+
+	return perm != nil, err
+}
+
+// Denies returns true if the role has a deny permission for the action on the
+// target, which applies in the access context.
+func (r *Role) Denies(action, target string, actx *AccessContext) (bool, error) {
+	perm, err := r.deniedBy(action, target, actx)
+
+	return perm != nil, err
+}
+
+// allowedBy returns the first permission of the role that allows the action on
+// the target in the access context, or nil if there isn't one. Deny
+// permissions are not considered.
+func (r *Role) allowedBy(action, target string, actx *AccessContext) (*Permission, error) {
+	r.compile()
+	return r.allow.match(action, target, actx)
+}
+
+// deniedBy returns the first deny permission of the role that applies to the
+// action on the target in the access context, or nil if there isn't one.
+func (r *Role) deniedBy(action, target string, actx *AccessContext) (*Permission, error) {
+	r.compile()
+	return r.deny.match(action, target, actx)
+}
+
+// deniedWithin returns the first deny permission of the role that applies to
+// the action on any of the targets matched by the target glob pattern in the
+// access context, or nil if there isn't one.
+func (r *Role) deniedWithin(action, target string, actx *AccessContext) *Permission {
+	r.compile()
+	return r.deny.overlap(action, target, actx)
+}
+
+// compile converts the role permissions to glob permissions, if it hasn't been
+// done already.
+func (r *Role) compile() {
+	if r.allow != nil && r.deny != nil {
+		return
+	}
+
+	r.allow = globPermissions{}
+	r.deny = globPermissions{}
+	for i, perm := range r.Permissions {
+		gperms := &r.allow
+		if perm.Deny {
+			gperms = &r.deny
+		}
+		for act := range perm.Actions {
+			for ns := range perm.Namespaces {
+				for _, pat := range perm.Target.Patterns {
+					target := fmt.Sprintf("%s:%s:%s", ns, perm.Target.Resource, pat)
+					*gperms = append(*gperms, globPermission{
+						action: string(act),
+						target: target,
+						perm:   rbac.NewGlobPermission(string(act), target),
+						source: &r.Permissions[i],
+					})
 				}
 			}
 		}
-		r.role = &rbac.Role{RoleID: r.Name, Permissions: perms}
 	}
+}
+
+// globPermission is a single action and target glob pattern of a permission.
+type globPermission struct {
+	action, target string
+	perm           rbac.Permission
+	source         *Permission
+}
 
-	return r.role.Can(action, target)
+// applies returns true if the conditions of the permission are met in the
+// access context. The conditions of deny permissions are met if they can't be
+// evaluated in it.
+func (p globPermission) applies(actx *AccessContext) bool {
+	if p.source.Deny {
+		return p.source.Conditions.MayMatch(actx)
+	}
+	return p.source.Conditions.Match(actx)
+}
+
+type globPermissions []globPermission
+
+// match returns the first permission whose conditions are met, and which
+// matches the action on the target, or nil if there isn't one.
+func (gp globPermissions) match(action, target string, actx *AccessContext) (*Permission, error) {
+	for _, p := range gp {
+		if !p.applies(actx) {
+			continue
+		}
+		if ok, err := p.perm(action, target); err != nil {
+			return nil, err
+		} else if ok {
+			return p.source, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// overlap returns the first permission whose conditions are met, and which
+// matches the action on at least one of the targets matched by the target glob
+// pattern, or nil if there isn't one.
+func (gp globPermissions) overlap(action, target string, actx *AccessContext) *Permission {
+	for _, p := range gp {
+		if p.applies(actx) && globsOverlap(p.action, action) && targetsOverlap(p.target, target) {
+			return p.source
+		}
+	}
+
+	return nil
 }
 
 // Load the role data from the database. Either the role ID or Name must be set
@@ -305,7 +431,8 @@ func usersWithRole(ctx context.Context, d types.Querier, filter *types.Filter) (
 // Roles returns one or more roles from the database. An optional filter can be
 // passed to limit the results.
 func Roles(ctx context.Context, d types.Querier, filter *types.Filter) ([]*Role, error) {
-	query := `SELECT r.id, r.name, rp.namespaces, rp.actions, rp.target
+	query := `SELECT r.id, r.name, rp.namespaces, rp.actions, rp.target, rp.deny,
+			rp.conditions
 		FROM roles r
 		LEFT JOIN role_permissions rp
 			ON r.id = rp.role_id
@@ -333,10 +460,13 @@ func Roles(ctx context.Context, d types.Querier, filter *types.Filter) ([]*Role,
 		Namespaces sql.Null[string]
 		Actions    sql.Null[string]
 		Target     sql.Null[string]
+		Deny       sql.Null[bool]
+		Conditions sql.Null[string]
 	}
 	for rows.Next() {
 		r := row{}
-		err := rows.Scan(&r.ID, &r.RoleName, &r.Namespaces, &r.Actions, &r.Target)
+		err := rows.Scan(&r.ID, &r.RoleName, &r.Namespaces, &r.Actions, &r.Target,
+			&r.Deny, &r.Conditions)
 		if err != nil {
 			return nil, fmt.Errorf("failed scanning role data: %w", err)
 		}
@@ -364,7 +494,10 @@ func Roles(ctx context.Context, d types.Querier, filter *types.Filter) ([]*Role,
 			}
 		}
 
-		perm := Permission{Namespaces: namespaces, Actions: actions}
+		perm := Permission{Namespaces: namespaces, Actions: actions, Deny: r.Deny.V}
+		if err := perm.Conditions.UnmarshalText([]byte(r.Conditions.V)); err != nil {
+			return nil, err
+		}
 		if r.Target.Valid {
 			if r.Target.V == "*" {
 				perm.Target = PermissionTarget{Resource: ResourceAny, Patterns: []string{"*"}}
@@ -402,6 +535,10 @@ var actionMap = map[rune]Action{
 func (p Permission) MarshalText() ([]byte, error) {
 	var buf bytes.Buffer
 
+	if p.Deny {
+		buf.WriteByte('!')
+	}
+
 	actions := make([]string, 0, len(p.Actions))
 	for action := range p.Actions {
 		actions = append(actions, string(action))
@@ -428,13 +565,38 @@ func (p Permission) MarshalText() ([]byte, error) {
 	buf.WriteByte(':')
 	buf.WriteString(strings.Join(p.Target.Patterns, ","))
 
+	if !p.Conditions.IsZero() {
+		conditions, err := p.Conditions.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(';')
+		buf.Write(conditions)
+	}
+
 	return buf.Bytes(), nil
 }
 
 var _ encoding.TextMarshaler = &Permission{}
 
 // UnmarshalText implements the encoding.TextUnmarshaler interface for Permission.
+// A '!' prefix makes it a deny permission, and conditions can follow the target
+// after a ';' separator.
 func (p *Permission) UnmarshalText(text []byte) error {
+	deny := false
+	if rest, ok := bytes.CutPrefix(text, []byte("!")); ok {
+		deny = true
+		text = rest
+	}
+
+	var conditions Conditions
+	if perm, conds, ok := bytes.Cut(text, []byte(";")); ok {
+		if err := conditions.UnmarshalText(conds); err != nil {
+			return err
+		}
+		text = perm
+	}
+
 	parts := bytes.Split(text, []byte(":"))
 	if len(parts) < 3 || len(parts) > 4 {
 		return errors.New("invalid permission format: must have 3 or 4 components")
@@ -459,11 +621,13 @@ func (p *Permission) UnmarshalText(text []byte) error {
 		return errors.New("invalid permission: with 3 components, the third must be a wildcard")
 	}
 
+	// A 3 component permission applies to any resource.
 	var (
-		resource       Resource
-		targetPatterns []string
+		resource       = ResourceAny
+		targetPatterns = []string{"*"}
 	)
 	if len(parts) == 4 {
+		targetPatterns = nil
 		var err error
 		resource, err = ResourceFromString(string(parts[2]))
 		if err != nil {
@@ -488,6 +652,8 @@ func (p *Permission) UnmarshalText(text []byte) error {
 	p.Actions = actions
 	p.Namespaces = namespaces
 	p.Target = PermissionTarget{Resource: resource, Patterns: targetPatterns}
+	p.Deny = deny
+	p.Conditions = conditions
 
 	return nil
 }
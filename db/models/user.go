@@ -40,7 +40,7 @@ func (u *User) Save(ctx context.Context, d types.Querier, update bool) error {
 	}
 	var privKeyHashEnc sql.Null[string]
 	if u.PrivateKey != nil {
-		privKeyHash := crypto.Hash("encryption key hash", u.PrivateKey[:])
+		privKeyHash := crypto.KeyHash(u.PrivateKey)
 		privKeyHashEnc.V = base58.Encode(privKeyHash)
 		privKeyHashEnc.Valid = true
 		u.PrivateKeyHashEnc = privKeyHashEnc
@@ -204,22 +204,16 @@ func (u *User) Delete(ctx context.Context, d types.Querier) error {
 	return nil
 }
 
-// Can returns true if the user is allowed to perform the action on the target.
-func (u *User) Can(action, target string) (bool, error) {
-	if len(u.Roles) == 0 {
-		return false, nil
-	}
-	for _, role := range u.Roles {
-		can, err := role.Can(action, target)
-		if err != nil {
-			return false, err
-		}
-		if can {
-			return true, nil
-		}
+// Can returns true if the user is allowed to perform the action on the target,
+// in the given access context. A deny permission in any of the user's roles
+// takes precedence over the permissions of all other roles.
+func (u *User) Can(action, target string, actx *AccessContext) (bool, error) {
+	dec, err := u.Check(action, target, actx)
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	return dec.Allowed, nil
 }
 
 // Users returns one or more users from the database. An optional filter can be
@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"strings"
 
 	"go.hackfix.me/disco/db/models"
 	"go.hackfix.me/disco/db/types"
@@ -57,29 +56,6 @@ func GetServerTLSInfo(ctx context.Context, d types.Querier) (
 	return
 }
 
-func GetAllTables(ctx context.Context, d types.Querier) (map[string]struct{}, error) {
-	allTables := make(map[string]struct{})
-	rows, err := d.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table'`)
-	if err != nil {
-		return nil, err
-	}
-
-	for rows.Next() {
-		var name string
-		err = rows.Scan(&name)
-		if err != nil {
-			return nil, err
-		}
-
-		// Exclude internal tables
-		if !strings.HasPrefix(name, "_") {
-			allTables[name] = struct{}{}
-		}
-	}
-
-	return allTables, nil
-}
-
 func Version(ctx context.Context, d types.Querier) (sql.Null[string], error) {
 	var version sql.Null[string]
 	err := d.QueryRowContext(ctx, `SELECT version FROM _meta`).
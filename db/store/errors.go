@@ -0,0 +1,21 @@
+package store
+
+import "errors"
+
+var (
+	// ErrPreconditionFailed is returned when a conditional write is rejected
+	// because the key is not in the expected state.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrInvalidNamespace is returned when a namespace name is not valid.
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrNamespaceNotFound is returned when a namespace doesn't exist.
+	ErrNamespaceNotFound = errors.New("namespace doesn't exist")
+	// ErrNamespaceExists is returned when creating a namespace that already
+	// exists.
+	ErrNamespaceExists = errors.New("namespace already exists")
+	// ErrNamespaceNotEmpty is returned when deleting a namespace that still
+	// contains keys, unless the deletion is forced.
+	ErrNamespaceNotEmpty = errors.New("namespace is not empty")
+	// ErrVersionNotFound is returned when a version of a key doesn't exist.
+	ErrVersionNotFound = errors.New("version doesn't exist")
+)
@@ -0,0 +1,65 @@
+package store
+
+import "time"
+
+// SetOptions are optional parameters of store write operations.
+type SetOptions struct {
+	// Name of the user writing the value.
+	Author string
+	// MIME type of the value. If empty, it is detected from the value.
+	ContentType string
+	// Duration after which the key expires. If 0, the key never expires.
+	TTL time.Duration
+	// If greater than 0, the write only succeeds if the current revision of
+	// the key is equal to it.
+	IfRevision int
+	// If true, the write only succeeds if the key doesn't exist.
+	CreateOnly bool
+}
+
+// SetOption is a function that allows configuring store write operations.
+type SetOption func(*SetOptions)
+
+// WithAuthor sets the name of the user writing the value.
+func WithAuthor(name string) SetOption {
+	return func(o *SetOptions) {
+		o.Author = name
+	}
+}
+
+// WithContentType sets the MIME type of the value.
+func WithContentType(contentType string) SetOption {
+	return func(o *SetOptions) {
+		o.ContentType = contentType
+	}
+}
+
+// WithTTL sets the duration after which the key expires.
+func WithTTL(ttl time.Duration) SetOption {
+	return func(o *SetOptions) {
+		o.TTL = ttl
+	}
+}
+
+// IfRevision makes the write conditional on the current revision of the key.
+func IfRevision(revision int) SetOption {
+	return func(o *SetOptions) {
+		o.IfRevision = revision
+	}
+}
+
+// CreateOnly makes the write conditional on the key not existing.
+func CreateOnly() SetOption {
+	return func(o *SetOptions) {
+		o.CreateOnly = true
+	}
+}
+
+// NewSetOptions returns SetOptions with the given options applied.
+func NewSetOptions(opts ...SetOption) *SetOptions {
+	o := &SetOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
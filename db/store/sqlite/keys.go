@@ -0,0 +1,556 @@
+package sqlite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	aerrors "go.hackfix.me/disco/app/errors"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/store"
+	"go.hackfix.me/disco/db/types"
+)
+
+// encryptionVersion is the version of the scheme used for encrypting values.
+// Since version 2, values are bound to their namespace and key. Since version
+// 3, values are encrypted with the data key of their namespace, which is
+// wrapped by the encryption key. Since version 4, data keys are wrapped by a
+// subkey derived from the encryption key.
+const encryptionVersion = 4
+
+// dataKey is the key used for encrypting the values of a namespace.
+type dataKey struct {
+	namespace string
+	key       *[32]byte
+	// The data key encrypted with the encryption key, as stored in the
+	// namespace registry.
+	wrapped []byte
+	// Whether the namespace doesn't exist yet.
+	isNew bool
+}
+
+// loadDataKey returns the data key of the namespace. If the namespace doesn't
+// exist and create is true, a new data key is generated, which is stored once
+// the namespace is created by useDataKey.
+func (s *Store) loadDataKey(q types.Querier, namespace string, create bool) (*dataKey, error) {
+	var wrapped []byte
+	err := q.QueryRowContext(q.NewContext(),
+		`SELECT data_key_enc FROM _namespaces WHERE name = ?`, namespace).Scan(&wrapped)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if !create {
+			return nil, fmt.Errorf("%w: %s", store.ErrNamespaceNotFound, namespace)
+		}
+
+		// The namespace doesn't exist, so sanitize it before it's created.
+		if !s.validTableNameRx.Match([]byte(namespace)) {
+			return nil, fmt.Errorf("%w: '%s'", store.ErrInvalidNamespace, namespace)
+		}
+
+		dk, err := s.newDataKey(namespace)
+		if err != nil {
+			return nil, err
+		}
+		dk.isNew = true
+
+		return dk, nil
+	}
+
+	if wrapped == nil {
+		return nil, fmt.Errorf("namespace '%s' has no data key", namespace)
+	}
+
+	encKey, release, err := s.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	key, err := s.unwrapDataKey(encKey, namespace, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{namespace: namespace, key: key, wrapped: wrapped}, nil
+}
+
+// newDataKey generates a new random data key for the namespace.
+func (s *Store) newDataKey(namespace string) (*dataKey, error) {
+	encKey, release, err := s.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return s.generateDataKey(encKey, namespace)
+}
+
+// generateDataKey generates a new random data key for the namespace, wrapped
+// with encKey.
+func (s *Store) generateDataKey(encKey *[32]byte, namespace string) (*dataKey, error) {
+	key := new([32]byte)
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, fmt.Errorf("failed generating data key: %w", err)
+	}
+
+	wrapped, err := s.wrapDataKey(encKey, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{namespace: namespace, key: key, wrapped: wrapped}, nil
+}
+
+// encryptionKey returns the key for wrapping and unwrapping data keys, and a
+// function that must be called once it's no longer needed. If the store has
+// no encryption key set, it's read from the key provider, if any, and the
+// returned function wipes it.
+func (s *Store) encryptionKey() (*[32]byte, func(), error) {
+	if s.encKey != nil || s.keyProvider == nil {
+		return s.encKey, func() {}, nil
+	}
+
+	encKey, err := s.keyProvider(s.ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading the encryption key: %w", err)
+	}
+
+	return encKey, func() { crypto.WipeKey(encKey) }, nil
+}
+
+// useDataKey ensures that values encrypted with the data key loaded before the
+// transaction was started can be written. If the namespace doesn't exist, it's
+// created with the data key. An error is returned if the data key of the
+// namespace was changed in the meantime.
+func (s *Store) useDataKey(tx *tx, dk *dataKey) error {
+	var wrapped []byte
+	err := tx.QueryRowContext(tx.NewContext(),
+		`SELECT data_key_enc FROM _namespaces WHERE name = ?`, dk.namespace).Scan(&wrapped)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if !dk.isNew {
+			return fmt.Errorf("%w: %s", store.ErrNamespaceNotFound, dk.namespace)
+		}
+		return registerNamespace(tx, dk.namespace, "", dk.wrapped)
+	}
+
+	if !bytes.Equal(wrapped, dk.wrapped) {
+		return fmt.Errorf("the data key of namespace '%s' was changed during the write; try again", dk.namespace)
+	}
+
+	return nil
+}
+
+// wrapDataKey encrypts a data key with the store subkey of the encryption key,
+// binding it to the namespace.
+func (s *Store) wrapDataKey(encKey *[32]byte, namespace string, key *[32]byte) ([]byte, error) {
+	if encKey == nil {
+		return nil, errors.New("the encryption key is required")
+	}
+
+	storeKey := crypto.DeriveKey(encKey, crypto.KeyPurposeStore)
+	defer crypto.WipeKey(storeKey)
+	wrapped, err := crypto.EncryptSymInMemory(key[:], storeKey,
+		crypto.WithCipher(s.cipher), crypto.WithAssociatedData(dataKeyAD(namespace)))
+	if err != nil {
+		return nil, fmt.Errorf("failed wrapping data key: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+// unwrapDataKey decrypts a data key with the store subkey of the encryption
+// key.
+func (s *Store) unwrapDataKey(encKey *[32]byte, namespace string, wrapped []byte) (*[32]byte, error) {
+	if encKey == nil {
+		return nil, errors.New("the encryption key is required")
+	}
+
+	storeKey := crypto.DeriveKey(encKey, crypto.KeyPurposeStore)
+	defer crypto.WipeKey(storeKey)
+
+	return openDataKey(storeKey, namespace, wrapped)
+}
+
+// openDataKey decrypts a data key with the key that wrapped it.
+func openDataKey(wrappingKey *[32]byte, namespace string, wrapped []byte) (*[32]byte, error) {
+	keyData, err := crypto.DecryptSymInMemory(wrapped, wrappingKey,
+		crypto.WithAssociatedData(dataKeyAD(namespace)))
+	if err != nil {
+		return nil, aerrors.NewRuntimeError(
+			fmt.Sprintf("failed decrypting data key of namespace '%s'", namespace), err, "")
+	}
+	defer crypto.Wipe(keyData)
+	if len(keyData) != 32 {
+		return nil, fmt.Errorf("invalid data key length of namespace '%s': %d", namespace, len(keyData))
+	}
+
+	key := new([32]byte)
+	copy(key[:], keyData)
+
+	return key, nil
+}
+
+// RewrapKeys encrypts the data keys of all namespaces with newKey in a single
+// transaction, and uses it for subsequent operations. Values aren't
+// re-encrypted. The databases in attach, keyed by schema name, are attached to
+// the transaction, and update is called within it, so that they can be changed
+// atomically along with the data keys. update may be nil.
+func (s *Store) RewrapKeys(
+	newKey *[32]byte, attach map[string]string, update func(q types.Querier) error,
+) error {
+	return s.replaceKeys(newKey, attach, update, false)
+}
+
+// RotateKeys is like RewrapKeys, except that the data keys of all namespaces
+// are replaced with new ones, and all values are re-encrypted with them. This
+// way, values can't be decrypted with data keys that were unwrapped with the
+// previous encryption key.
+func (s *Store) RotateKeys(
+	newKey *[32]byte, attach map[string]string, update func(q types.Querier) error,
+) error {
+	return s.replaceKeys(newKey, attach, update, true)
+}
+
+// replaceKeys wraps the data keys of all namespaces with newKey, replacing
+// them first if rotate is true.
+func (s *Store) replaceKeys(
+	newKey *[32]byte, attach map[string]string, update func(q types.Querier) error, rotate bool,
+) error {
+	// ATTACH can't be run within a transaction, so a dedicated connection is
+	// used to keep the attached databases out of the connection pool.
+	conn, err := s.Conn(s.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for schema, path := range attach {
+		_, err = conn.ExecContext(s.ctx, fmt.Sprintf(`ATTACH DATABASE ? AS "%s"`, schema), path)
+		if err != nil {
+			return fmt.Errorf("failed attaching database '%s': %w", schema, err)
+		}
+		defer conn.ExecContext(s.ctx, fmt.Sprintf(`DETACH DATABASE "%s"`, schema)) //nolint:errcheck
+	}
+
+	err = s.withTxOn(conn, func(tx *tx) error {
+		nss, err := listNamespaces(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, ns := range nss {
+			dk, err := s.loadDataKey(tx, ns.Name, false)
+			if err != nil {
+				return err
+			}
+
+			if !rotate {
+				wrapped, err := s.wrapDataKey(newKey, ns.Name, dk.key)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(tx.NewContext(),
+					`UPDATE _namespaces SET data_key_enc = ? WHERE name = ?`, wrapped, ns.Name)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			newDK, err := s.generateDataKey(newKey, ns.Name)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(tx.NewContext(),
+				`UPDATE _namespaces SET data_key_enc = ? WHERE name = ?`, newDK.wrapped, ns.Name)
+			if err != nil {
+				return err
+			}
+			if _, err = s.reencryptValues(tx, newDK, s.valueDecrypter(dk)); err != nil {
+				return err
+			}
+		}
+
+		if update == nil {
+			return nil
+		}
+
+		return update(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.encKey = newKey
+
+	return nil
+}
+
+// SetEncryptionKey replaces the encryption key used for wrapping data keys,
+// without re-wrapping them. The key provider, if any, is no longer used, so if
+// encKey is nil, values can't be read or written until it's set again.
+func (s *Store) SetEncryptionKey(encKey *[32]byte) {
+	s.encKey = encKey
+	s.keyProvider = nil
+}
+
+// RotateNamespaceKey replaces the data key of a namespace with a new one, and
+// re-encrypts the current and previous values of all keys in it.
+func (s *Store) RotateNamespaceKey(namespace string) error {
+	return s.withTx(func(tx *tx) error {
+		if err := checkNamespaceExists(tx, namespace); err != nil {
+			return err
+		}
+
+		dk, err := s.loadDataKey(tx, namespace, false)
+		if err != nil {
+			return err
+		}
+		newDK, err := s.newDataKey(namespace)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(tx.NewContext(),
+			`UPDATE _namespaces SET data_key_enc = ? WHERE name = ?`, newDK.wrapped, namespace)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.reencryptValues(tx, newDK, s.valueDecrypter(dk))
+		return err
+	})
+}
+
+// upgradeEncryption re-encrypts all values written with a previous version of
+// the encryption scheme. If the encryption key isn't available, this is
+// deferred until the store is opened with it.
+func (s *Store) upgradeEncryption(logger *slog.Logger) error {
+	if s.encKey == nil && s.keyProvider == nil {
+		return nil
+	}
+
+	var version int
+	err := s.QueryRowContext(s.NewContext(),
+		`SELECT encryption_version FROM _meta`).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if version >= encryptionVersion {
+		return nil
+	}
+
+	encKey, release, err := s.encryptionKey()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return s.withTx(func(tx *tx) error {
+		nss, err := listNamespaces(tx)
+		if err != nil {
+			return err
+		}
+
+		var count int
+		for _, ns := range nss {
+			if version == 3 {
+				// Data keys were wrapped by the encryption key itself, so
+				// only they need to be rewrapped.
+				if err = s.rewrapLegacyDataKey(tx, encKey, ns.Name); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Values were encrypted with the encryption key, and before
+			// version 2 without associated data.
+			decrypt := func(key string, encValue []byte) (io.Reader, error) {
+				var opts []crypto.Option
+				if version >= 2 {
+					opts = append(opts, crypto.WithAssociatedData(valueAD(ns.Name, key)))
+				}
+				return crypto.DecryptSym(bytes.NewReader(encValue), encKey, opts...)
+			}
+
+			dk, err := s.generateDataKey(encKey, ns.Name)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(tx.NewContext(),
+				`UPDATE _namespaces SET data_key_enc = ? WHERE name = ?`, dk.wrapped, ns.Name)
+			if err != nil {
+				return err
+			}
+
+			n, err := s.reencryptValues(tx, dk, decrypt)
+			if err != nil {
+				return fmt.Errorf("namespace '%s': %w", ns.Name, err)
+			}
+			count += n
+		}
+
+		_, err = tx.ExecContext(tx.NewContext(),
+			`UPDATE _meta SET encryption_version = ?`, encryptionVersion)
+		if err != nil {
+			return err
+		}
+
+		logger.Debug("upgraded the encryption of values",
+			"from_version", version, "to_version", encryptionVersion, "count", count)
+
+		return nil
+	})
+}
+
+// rewrapLegacyDataKey wraps the data key of the namespace, which was wrapped by
+// the encryption key itself, with the store subkey.
+func (s *Store) rewrapLegacyDataKey(tx *tx, encKey *[32]byte, namespace string) error {
+	var wrapped []byte
+	err := tx.QueryRowContext(tx.NewContext(),
+		`SELECT data_key_enc FROM _namespaces WHERE name = ?`, namespace).Scan(&wrapped)
+	if err != nil {
+		return err
+	}
+
+	key, err := openDataKey(encKey, namespace, wrapped)
+	if err != nil {
+		return err
+	}
+	newWrapped, err := s.wrapDataKey(encKey, namespace, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(tx.NewContext(),
+		`UPDATE _namespaces SET data_key_enc = ? WHERE name = ?`, newWrapped, namespace)
+
+	return err
+}
+
+// encrypt encrypts the value of a key with the data key of its namespace,
+// binding it to the namespace and key.
+func (s *Store) encrypt(dk *dataKey, key string, value io.Reader) ([]byte, error) {
+	encData, err := crypto.EncryptSym(value, dk.key,
+		crypto.WithCipher(s.cipher),
+		crypto.WithAssociatedData(valueAD(dk.namespace, key)))
+	if err != nil {
+		return nil, aerrors.NewRuntimeError("failed encrypting value", err, "")
+	}
+
+	encValue, err := io.ReadAll(encData)
+	if err != nil {
+		return nil, aerrors.NewRuntimeError("failed reading encrypted data", err, "")
+	}
+
+	return encValue, nil
+}
+
+// decryptValue decrypts the value of a key with the data key of its namespace.
+// It fails if the value was encrypted for a different namespace or key.
+func (s *Store) decryptValue(dk *dataKey, key string, encValue []byte) (io.Reader, error) {
+	return crypto.DecryptSym(bytes.NewReader(encValue), dk.key,
+		crypto.WithAssociatedData(valueAD(dk.namespace, key)))
+}
+
+// valueDecrypter returns a function that decrypts values of keys that were
+// encrypted with the data key.
+func (s *Store) valueDecrypter(dk *dataKey) func(key string, encValue []byte) (io.Reader, error) {
+	return func(key string, encValue []byte) (io.Reader, error) {
+		return s.decryptValue(dk, key, encValue)
+	}
+}
+
+// reencryptValues re-encrypts the current and previous values of all keys in
+// the namespace of the data key with it. The values are decrypted with the
+// decrypt function. It returns the number of re-encrypted values.
+func (s *Store) reencryptValues(
+	tx *tx, dk *dataKey, decrypt func(key string, encValue []byte) (io.Reader, error),
+) (int, error) {
+	type encValue struct {
+		key     string
+		version int // 0 for the current value
+		value   []byte
+	}
+
+	var values []*encValue
+	rows, err := tx.QueryContext(tx.NewContext(),
+		fmt.Sprintf(`SELECT key, 0, value FROM "%s"
+		UNION ALL
+		SELECT key, version, value FROM _history WHERE namespace = ?`, dk.namespace),
+		dk.namespace)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		v := &encValue{}
+		if err = rows.Scan(&v.key, &v.version, &v.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, v)
+	}
+	if err = rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, v := range values {
+		value, err := decrypt(v.key, v.value)
+		if err != nil {
+			return 0, aerrors.NewRuntimeError(
+				fmt.Sprintf("failed decrypting value of key '%s'", v.key), err, "")
+		}
+		newValue, err := s.encrypt(dk, v.key, value)
+		if err != nil {
+			return 0, err
+		}
+
+		if v.version == 0 {
+			_, err = tx.ExecContext(tx.NewContext(), fmt.Sprintf(
+				`UPDATE "%s" SET value = ? WHERE key = ?`, dk.namespace), newValue, v.key)
+		} else {
+			_, err = tx.ExecContext(tx.NewContext(),
+				`UPDATE _history SET value = ? WHERE namespace = ? AND key = ? AND version = ?`,
+				newValue, dk.namespace, v.key, v.version)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(values), nil
+}
+
+// valueAD returns the associated data that binds an encrypted value to its
+// namespace and key. The namespace is length-prefixed, so that the encoding is
+// unambiguous.
+//
+// Known limitation: the version isn't bound, since values are encrypted before
+// the write transaction that assigns it is started, so that large values don't
+// hold the database lock while they're read and encrypted. This means that
+// someone with write access to the store database can swap the ciphertexts of
+// different versions of the same key, e.g. to revert it to a previous value,
+// without it being detected.
+func valueAD(namespace, key string) []byte {
+	ad := binary.BigEndian.AppendUint32(nil, uint32(len(namespace)))
+	ad = append(ad, namespace...)
+	return append(ad, key...)
+}
+
+// dataKeyAD returns the associated data that binds a wrapped data key to its
+// namespace.
+func dataKeyAD(namespace string) []byte {
+	return append([]byte("data key:"), namespace...)
+}
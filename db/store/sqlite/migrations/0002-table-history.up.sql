@@ -0,0 +1,9 @@
+CREATE TABLE _history (
+  namespace   VARCHAR      NOT NULL,
+  key         VARCHAR      NOT NULL,
+  version     INTEGER      NOT NULL,
+  value       BLOB,
+  created_at  TIMESTAMP    NOT NULL,
+  author      VARCHAR(32),
+  PRIMARY KEY (namespace, key, version)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE _history DROP COLUMN content_type;
+ALTER TABLE _history DROP COLUMN size;
+DROP TABLE _keys;
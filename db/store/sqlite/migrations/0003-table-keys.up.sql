@@ -0,0 +1,13 @@
+CREATE TABLE _keys (
+  namespace     VARCHAR       NOT NULL,
+  key           VARCHAR       NOT NULL,
+  created_at    TIMESTAMP     NOT NULL,
+  updated_at    TIMESTAMP     NOT NULL,
+  author        VARCHAR(32),
+  size          INTEGER       NOT NULL,
+  content_type  VARCHAR(255)  NOT NULL,
+  PRIMARY KEY (namespace, key)
+);
+
+ALTER TABLE _history ADD COLUMN size INTEGER;
+ALTER TABLE _history ADD COLUMN content_type VARCHAR(255);
@@ -0,0 +1,2 @@
+DROP INDEX _keys_expires_at_idx;
+ALTER TABLE _keys DROP COLUMN expires_at;
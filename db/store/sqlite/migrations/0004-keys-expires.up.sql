@@ -0,0 +1,3 @@
+ALTER TABLE _keys ADD COLUMN expires_at TIMESTAMP;
+
+CREATE INDEX _keys_expires_at_idx ON _keys (expires_at);
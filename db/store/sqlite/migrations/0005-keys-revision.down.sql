@@ -0,0 +1 @@
+ALTER TABLE _keys DROP COLUMN revision;
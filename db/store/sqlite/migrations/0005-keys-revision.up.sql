@@ -0,0 +1,7 @@
+ALTER TABLE _keys ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+
+UPDATE _keys SET revision = (
+  SELECT COALESCE(MAX(h.version), 0)
+  FROM _history h
+  WHERE h.namespace = _keys.namespace AND h.key = _keys.key
+);
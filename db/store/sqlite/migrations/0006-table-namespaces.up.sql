@@ -0,0 +1,13 @@
+CREATE TABLE _namespaces (
+  name          VARCHAR     PRIMARY KEY,
+  created_at    TIMESTAMP   NOT NULL,
+  description   TEXT        NOT NULL DEFAULT ''
+);
+
+-- Register existing namespaces. Tables starting with an underscore are internal.
+INSERT INTO _namespaces (name, created_at)
+SELECT name, strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')
+FROM sqlite_master
+WHERE type = 'table'
+  AND name NOT LIKE '\_%' ESCAPE '\'
+  AND name NOT LIKE 'sqlite\_%' ESCAPE '\';
@@ -0,0 +1 @@
+ALTER TABLE _meta ADD COLUMN encryption_version INTEGER NOT NULL DEFAULT 1;
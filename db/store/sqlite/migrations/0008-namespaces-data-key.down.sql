@@ -0,0 +1 @@
+ALTER TABLE _namespaces DROP COLUMN data_key_enc;
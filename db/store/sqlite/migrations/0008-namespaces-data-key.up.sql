@@ -0,0 +1 @@
+ALTER TABLE _namespaces ADD COLUMN data_key_enc BLOB;
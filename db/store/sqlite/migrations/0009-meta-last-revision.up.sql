@@ -0,0 +1,10 @@
+ALTER TABLE _meta ADD COLUMN last_revision INTEGER NOT NULL DEFAULT 0;
+
+-- Start above all revisions that were derived from the key history.
+UPDATE _meta SET last_revision = (
+  SELECT MAX(r) FROM (
+    SELECT COALESCE(MAX(revision), 0) AS r FROM _keys
+    UNION ALL
+    SELECT COALESCE(MAX(version), 0) FROM _history
+  )
+);
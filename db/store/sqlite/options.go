@@ -1,5 +1,11 @@
 package sqlite
 
+import (
+	"context"
+
+	"go.hackfix.me/disco/crypto"
+)
+
 // Option is a function that allows configuring the store.
 type Option func(*Store) error
 
@@ -10,3 +16,27 @@ func WithEncryptionKey(encKey *[32]byte) Option {
 		return nil
 	}
 }
+
+// KeyProvider returns the store encryption key, e.g. by reading it from an
+// external source.
+type KeyProvider func(ctx context.Context) (*[32]byte, error)
+
+// WithKeyProvider sets the provider the encryption key is read from each time
+// a data key is wrapped or unwrapped, if the store has no encryption key set.
+// The key is wiped once it's used, so it's not held in memory for the lifetime
+// of the store.
+func WithKeyProvider(p KeyProvider) Option {
+	return func(s *Store) error {
+		s.keyProvider = p
+		return nil
+	}
+}
+
+// WithCipher sets the cipher used for encrypting new values. Values encrypted
+// with other ciphers remain readable.
+func WithCipher(c crypto.Cipher) Option {
+	return func(s *Store) error {
+		s.cipher = c
+		return nil
+	}
+}
@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
@@ -10,12 +11,13 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"net/http"
 	"regexp"
+	"time"
 
 	aerrors "go.hackfix.me/disco/app/errors"
 	"go.hackfix.me/disco/crypto"
 	"go.hackfix.me/disco/db/migrator"
-	"go.hackfix.me/disco/db/queries"
 	"go.hackfix.me/disco/db/store"
 	"go.hackfix.me/disco/db/types"
 )
@@ -27,6 +29,8 @@ type Store struct {
 	*sql.DB
 	ctx              context.Context
 	encKey           *[32]byte
+	keyProvider      KeyProvider
+	cipher           crypto.Cipher
 	migrations       []*migrator.Migration
 	validTableNameRx *regexp.Regexp
 }
@@ -34,12 +38,18 @@ type Store struct {
 var _ store.Store = &Store{}
 
 func Open(ctx context.Context, path string, opts ...Option) (*Store, error) {
-	db, err := sql.Open("sqlite", path)
+	db, err := sql.Open("sqlite", types.SQLiteDSN(path))
 	if err != nil {
 		return nil, err
 	}
 
-	d := &Store{DB: db, ctx: ctx, validTableNameRx: regexp.MustCompile(`^[a-zA-Z0-9-_/.]+$`)}
+	d := &Store{
+		DB:     db,
+		ctx:    ctx,
+		cipher: crypto.DefaultCipher,
+		// Table names starting with an underscore are reserved for internal tables.
+		validTableNameRx: regexp.MustCompile(`^[a-zA-Z0-9-/.][a-zA-Z0-9-_/.]*$`),
+	}
 
 	var optErr error
 	for _, opt := range opts {
@@ -67,20 +77,23 @@ func Open(ctx context.Context, path string, opts ...Option) (*Store, error) {
 func (s *Store) Get(namespace, key string) (ok bool, value io.Reader, err error) {
 	// Validate the table name to ensure it actually exists. This prevents
 	// possible SQL injection attacks, since we parametrize the table name below.
-	allTables, err := queries.GetAllTables(s.NewContext(), s)
+	allNamespaces, err := namespaces(s)
 	if err != nil {
 		return false, nil, err
 	}
-	if _, ok := allTables[namespace]; !ok {
+	if _, ok := allNamespaces[namespace]; !ok {
 		return false, nil, nil
 	}
 
 	// Namespaces are stored in different tables, but parameterization is not
 	// supported for table names, so template it manually.
 	var encValue []byte
-	err = s.QueryRowContext(s.ctx, fmt.Sprintf(`SELECT value
-		FROM "%s"
-		WHERE key = ?`, namespace), key).Scan(&encValue)
+	err = s.QueryRowContext(s.ctx, fmt.Sprintf(`SELECT t.value
+		FROM "%s" t
+		LEFT JOIN _keys k
+			ON k.namespace = ? AND k.key = t.key
+		WHERE t.key = ? AND %s`, namespace, notExpired),
+		namespace, key, time.Now().UTC()).Scan(&encValue)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil, nil
@@ -88,7 +101,11 @@ func (s *Store) Get(namespace, key string) (ok bool, value io.Reader, err error)
 		return false, nil, err
 	}
 
-	decValue, err := crypto.DecryptSym(bytes.NewReader(encValue), s.encKey)
+	dk, err := s.loadDataKey(s, namespace, false)
+	if err != nil {
+		return true, nil, err
+	}
+	decValue, err := s.decryptValue(dk, key, encValue)
 	if err != nil {
 		return true, nil, aerrors.NewRuntimeError("failed decrypting value", err, "")
 	}
@@ -96,144 +113,607 @@ func (s *Store) Get(namespace, key string) (ok bool, value io.Reader, err error)
 	return true, decValue, nil
 }
 
-func (s *Store) Set(namespace, key string, value io.Reader) error {
-	// Validate the table name to ensure it actually exists. This prevents
-	// possible SQL injection attacks, since we parametrize the table name below.
-	allTables, err := queries.GetAllTables(s.NewContext(), s)
+// GetVersion returns the value of a specific version of a key within a
+// specific namespace. The returned boolean indicates whether the version was
+// found or not.
+func (s *Store) GetVersion(namespace, key string, version int) (ok bool, value io.Reader, err error) {
+	var encValue []byte
+	err = s.QueryRowContext(s.ctx, fmt.Sprintf(`SELECT h.value
+		FROM _history h
+		LEFT JOIN _keys k
+			ON k.namespace = h.namespace AND k.key = h.key
+		WHERE h.namespace = ? AND h.key = ? AND h.version = ? AND %s`, notExpired),
+		namespace, key, version, time.Now().UTC()).Scan(&encValue)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil, nil
+		}
+		return false, nil, err
 	}
 
-	if _, ok := allTables[namespace]; !ok {
-		// The namespace/table doesn't exist, so sanitize it before creating it.
-		if s.validTableNameRx.Match([]byte(namespace)) {
-			_, err = s.ExecContext(s.NewContext(), fmt.Sprintf(`CREATE TABLE "%s" (
-				key VARCHAR UNIQUE NOT NULL,
-				value BLOB
-			)`, namespace))
-			if err != nil {
-				return aerrors.NewRuntimeError("failed creating namespace", err, "")
-			}
-		} else {
-			return fmt.Errorf("invalid namespace: '%s'", namespace)
-		}
+	dk, err := s.loadDataKey(s, namespace, false)
+	if err != nil {
+		return true, nil, err
+	}
+	decValue, err := s.decryptValue(dk, key, encValue)
+	if err != nil {
+		return true, nil, aerrors.NewRuntimeError("failed decrypting value", err, "")
+	}
+
+	return true, decValue, nil
+}
+
+// Set stores the value of a key within a specific namespace, creating the
+// namespace if it doesn't exist. The previous value of the key is kept in the
+// key history. If the write is conditional and the condition isn't met, an
+// error wrapping store.ErrPreconditionFailed is returned.
+func (s *Store) Set(namespace, key string, value io.Reader, opts ...store.SetOption) error {
+	options := store.NewSetOptions(opts...)
+
+	if namespace == "*" {
+		return s.setAll(key, value, options)
 	}
 
-	encData, err := crypto.EncryptSym(value, s.encKey)
+	dk, err := s.loadDataKey(s, namespace, true)
+	if err != nil {
+		return err
+	}
+	encValue, meta, err := s.encryptValue(dk, key, value, options)
 	if err != nil {
-		return aerrors.NewRuntimeError("failed encrypting value", err, "")
+		return err
 	}
 
-	encValue, err := io.ReadAll(encData)
+	return s.withTx(func(tx *tx) error {
+		if err := s.useDataKey(tx, dk); err != nil {
+			return err
+		}
+
+		if err := checkPreconditions(tx, namespace, key, options); err != nil {
+			return err
+		}
+
+		return setValue(tx, namespace, key, encValue, meta)
+	})
+}
+
+// setAll stores the value of a key in all existing namespaces atomically.
+func (s *Store) setAll(key string, value io.Reader, opts *store.SetOptions) error {
+	// The value is encrypted separately for each namespace, so it must be read
+	// more than once.
+	data, err := io.ReadAll(value)
 	if err != nil {
-		return aerrors.NewRuntimeError("failed reading encrypted data", err, "")
+		return aerrors.NewRuntimeError("failed reading value", err, "")
 	}
-	_, err = s.ExecContext(s.NewContext(), fmt.Sprintf(
-		`INSERT INTO "%s" (key, value)
-		VALUES (:key, :value)
-		ON CONFLICT(key) DO UPDATE SET value = :value`, namespace),
-		sql.Named("key", key), sql.Named("value", encValue))
+	defer crypto.Wipe(data)
+
+	return s.withTx(func(tx *tx) error {
+		nss, err := listNamespaces(tx)
+		if err != nil {
+			return err
+		}
+		if len(nss) == 0 {
+			return errors.New("no namespaces exist")
+		}
+
+		for _, ns := range nss {
+			dk, err := s.loadDataKey(tx, ns.Name, false)
+			if err != nil {
+				return err
+			}
+			encValue, meta, err := s.encryptValue(dk, key, bytes.NewReader(data), opts)
+			if err != nil {
+				return err
+			}
+			if err = checkPreconditions(tx, ns.Name, key, opts); err != nil {
+				return fmt.Errorf("namespace '%s': %w", ns.Name, err)
+			}
+			if err = setValue(tx, ns.Name, key, encValue, meta); err != nil {
+				return fmt.Errorf("namespace '%s': %w", ns.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Rollback sets the current value of a key to the value of a previous version.
+// This creates a new version, so the rollback itself can be reverted.
+func (s *Store) Rollback(namespace, key string, version int, opts ...store.SetOption) error {
+	options := store.NewSetOptions(opts...)
+
+	return s.withTx(func(tx *tx) error {
+		allNamespaces, err := namespaces(tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := allNamespaces[namespace]; !ok {
+			return fmt.Errorf("%w: %s", store.ErrNamespaceNotFound, namespace)
+		}
+
+		if err = checkPreconditions(tx, namespace, key, options); err != nil {
+			return err
+		}
+
+		var (
+			encValue    []byte
+			contentType sql.Null[string]
+			expiresAt   sql.Null[time.Time]
+		)
+		now := time.Now().UTC()
+		err = tx.QueryRowContext(tx.NewContext(), fmt.Sprintf(`SELECT h.value, h.content_type, k.expires_at
+			FROM _history h
+			LEFT JOIN _keys k
+				ON k.namespace = h.namespace AND k.key = h.key
+			WHERE h.namespace = ? AND h.key = ? AND h.version = ? AND %s`, notExpired),
+			namespace, key, version, now).Scan(&encValue, &contentType, &expiresAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: key '%s', version %d", store.ErrVersionNotFound, key, version)
+			}
+			return err
+		}
+
+		// The value is re-encrypted in order to recalculate its metadata,
+		// which isn't available for versions written before metadata tracking
+		// was added.
+		dk, err := s.loadDataKey(tx, namespace, false)
+		if err != nil {
+			return err
+		}
+		value, err := s.decryptValue(dk, key, encValue)
+		if err != nil {
+			return aerrors.NewRuntimeError("failed decrypting value", err, "")
+		}
+		if options.ContentType == "" {
+			options.ContentType = contentType.V
+		}
+
+		newEncValue, meta, err := s.encryptValue(dk, key, value, options)
+		if err != nil {
+			return err
+		}
+		// Rolling back doesn't change when the key expires, unless a new TTL
+		// is given.
+		if options.TTL == 0 {
+			meta.ExpiresAt = expiresAt.V
+		}
+
+		return setValue(tx, namespace, key, newEncValue, meta)
+	})
+}
+
+// History returns the versions of a key within a specific namespace, ordered
+// from oldest to newest.
+func (s *Store) History(namespace, key string) ([]*store.Version, error) {
+	rows, err := s.QueryContext(s.ctx, fmt.Sprintf(`SELECT h.version, h.created_at, h.author, h.size, h.content_type
+		FROM _history h
+		LEFT JOIN _keys k
+			ON k.namespace = h.namespace AND k.key = h.key
+		WHERE h.namespace = ? AND h.key = ? AND %s
+		ORDER BY h.version ASC`, notExpired), namespace, key, time.Now().UTC())
 	if err != nil {
-		return aerrors.NewRuntimeError("failed setting key", err, "")
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
+	versions := []*store.Version{}
+	for rows.Next() {
+		var (
+			v           store.Version
+			author      sql.Null[string]
+			size        sql.Null[int64]
+			contentType sql.Null[string]
+		)
+		if err = rows.Scan(&v.Number, &v.CreatedAt, &author, &size, &contentType); err != nil {
+			return nil, err
+		}
+		v.Author = author.V
+		v.Size = size.V
+		v.ContentType = contentType.V
+		versions = append(versions, &v)
+	}
+
+	return versions, rows.Err()
 }
 
-// Delete a key within a specific namespace. An error is returned if the key
+// Delete a key within a specific namespace, or within all namespaces if
+// namespace is '*', along with its history. An error is returned if the key
 // doesn't exist.
 func (s *Store) Delete(namespace, key string) error {
-	// Validate the table name to ensure it actually exists. This prevents
-	// possible SQL injection attacks, since we parametrize the table name below.
-	allTables, err := queries.GetAllTables(s.NewContext(), s)
-	if err != nil {
+	return s.withTx(func(tx *tx) error {
+		if namespace != "*" {
+			return deleteExistingKey(tx, namespace, key, &store.SetOptions{})
+		}
+
+		nss, err := listNamespaces(tx)
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		for _, ns := range nss {
+			n, err := deleteKey(tx, ns.Name, key)
+			if err != nil {
+				return err
+			}
+			count += n
+		}
+		if count == 0 {
+			return fmt.Errorf("key doesn't exist: %s", key)
+		}
+
+		return nil
+	})
+}
+
+// Namespaces returns all namespaces, ordered by name.
+func (s *Store) Namespaces() ([]*store.Namespace, error) {
+	return listNamespaces(s)
+}
+
+// CreateNamespace creates a new empty namespace. An error is returned if the
+// namespace already exists.
+func (s *Store) CreateNamespace(name, description string) error {
+	return s.withTx(func(tx *tx) error {
+		if err := s.checkNewNamespace(tx, name); err != nil {
+			return err
+		}
+
+		dk, err := s.newDataKey(name)
+		if err != nil {
+			return err
+		}
+
+		return registerNamespace(tx, name, description, dk.wrapped)
+	})
+}
+
+// DeleteNamespace deletes a namespace. If the namespace contains any keys, an
+// error wrapping store.ErrNamespaceNotEmpty is returned, unless force is true,
+// in which case the keys are deleted along with it.
+func (s *Store) DeleteNamespace(name string, force bool) error {
+	return s.withTx(func(tx *tx) error {
+		if err := checkNamespaceExists(tx, name); err != nil {
+			return err
+		}
+
+		stats, err := namespaceStats(tx, name)
+		if err != nil {
+			return err
+		}
+		if stats.Keys > 0 && !force {
+			return fmt.Errorf("%w: %s", store.ErrNamespaceNotEmpty, name)
+		}
+
+		stmts := []struct {
+			query string
+			args  []any
+		}{
+			{fmt.Sprintf(`DROP TABLE "%s"`, name), nil},
+			{`DELETE FROM _history WHERE namespace = ?`, []any{name}},
+			{`DELETE FROM _keys WHERE namespace = ?`, []any{name}},
+			{`DELETE FROM _namespaces WHERE name = ?`, []any{name}},
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.ExecContext(tx.NewContext(), stmt.query, stmt.args...); err != nil {
+				return aerrors.NewRuntimeError("failed deleting namespace", err, "")
+			}
+		}
+
+		return nil
+	})
+}
+
+// RenameNamespace renames a namespace. An error is returned if a namespace with
+// the new name already exists.
+func (s *Store) RenameNamespace(name, newName string) error {
+	return s.withTx(func(tx *tx) error {
+		if err := checkNamespaceExists(tx, name); err != nil {
+			return err
+		}
+		if err := s.checkNewNamespace(tx, newName); err != nil {
+			return err
+		}
+
+		// The data key is kept, but it's bound to the namespace name, so it
+		// must be wrapped again.
+		dk, err := s.loadDataKey(tx, name, false)
+		if err != nil {
+			return err
+		}
+		encKey, release, err := s.encryptionKey()
+		if err != nil {
+			return err
+		}
+		defer release()
+		newDK := &dataKey{namespace: newName, key: dk.key}
+		if newDK.wrapped, err = s.wrapDataKey(encKey, newName, dk.key); err != nil {
+			return err
+		}
+
+		stmts := []struct {
+			query string
+			args  []any
+		}{
+			{fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, name, newName), nil},
+			{`UPDATE _history SET namespace = ? WHERE namespace = ?`, []any{newName, name}},
+			{`UPDATE _keys SET namespace = ? WHERE namespace = ?`, []any{newName, name}},
+			{
+				`UPDATE _namespaces SET name = ?, data_key_enc = ? WHERE name = ?`,
+				[]any{newName, newDK.wrapped, name},
+			},
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.ExecContext(tx.NewContext(), stmt.query, stmt.args...); err != nil {
+				return aerrors.NewRuntimeError("failed renaming namespace", err, "")
+			}
+		}
+
+		// Values are bound to their namespace, so they must be re-encrypted.
+		_, err = s.reencryptValues(tx, newDK, s.valueDecrypter(dk))
+		return err
+	})
+}
+
+// CopyNamespace creates a new namespace with a copy of all keys in an existing
+// namespace, including their metadata and history. An error is returned if a
+// namespace with the new name already exists.
+func (s *Store) CopyNamespace(name, newName string) error {
+	return s.withTx(func(tx *tx) error {
+		if err := checkNamespaceExists(tx, name); err != nil {
+			return err
+		}
+		if err := s.checkNewNamespace(tx, newName); err != nil {
+			return err
+		}
+
+		var description string
+		err := tx.QueryRowContext(tx.NewContext(),
+			`SELECT description FROM _namespaces WHERE name = ?`, name).Scan(&description)
+		if err != nil {
+			return err
+		}
+
+		// The copy gets its own data key, so that it can be rotated or shared
+		// independently of the original namespace.
+		dk, err := s.loadDataKey(tx, name, false)
+		if err != nil {
+			return err
+		}
+		newDK, err := s.newDataKey(newName)
+		if err != nil {
+			return err
+		}
+
+		if err = registerNamespace(tx, newName, description, newDK.wrapped); err != nil {
+			return err
+		}
+
+		stmts := []struct {
+			query string
+			args  []any
+		}{
+			{fmt.Sprintf(`INSERT INTO "%s" (key, value) SELECT key, value FROM "%s"`, newName, name), nil},
+			{`INSERT INTO _history (namespace, key, version, value, created_at, author, size, content_type)
+				SELECT ?, key, version, value, created_at, author, size, content_type
+				FROM _history WHERE namespace = ?`, []any{newName, name}},
+			{`INSERT INTO _keys (namespace, key, created_at, updated_at, author, size, content_type, expires_at, revision)
+				SELECT ?, key, created_at, updated_at, author, size, content_type, expires_at, revision
+				FROM _keys WHERE namespace = ?`, []any{newName, name}},
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.ExecContext(tx.NewContext(), stmt.query, stmt.args...); err != nil {
+				return aerrors.NewRuntimeError("failed copying namespace", err, "")
+			}
+		}
+
+		_, err = s.reencryptValues(tx, newDK, s.valueDecrypter(dk))
 		return err
+	})
+}
+
+// NamespaceStats returns usage statistics of a namespace.
+func (s *Store) NamespaceStats(name string) (*store.NamespaceStats, error) {
+	if err := checkNamespaceExists(s, name); err != nil {
+		return nil, err
 	}
-	if _, ok := allTables[namespace]; !ok {
-		return fmt.Errorf("namespace doesn't exist: %s", namespace)
+
+	return namespaceStats(s, name)
+}
+
+// Txn applies multiple set and delete operations in a single transaction. If
+// any of the operations fails, none of them are applied. If the precondition
+// of an operation isn't met, an error wrapping store.ErrPreconditionFailed is
+// returned.
+func (s *Store) Txn(ops []*store.TxnOp) error {
+	type txnWrite struct {
+		op       *store.TxnOp
+		options  *store.SetOptions
+		dataKey  *dataKey
+		encValue []byte
+		meta     *store.Metadata
 	}
 
-	// Namespaces are stored in different tables, but parameterization is not
-	// supported for table names, so template it manually.
-	res, err := s.ExecContext(s.ctx,
-		fmt.Sprintf(`DELETE FROM "%s" WHERE key = ?`, namespace), key)
-	if err != nil {
-		return err
+	// Encrypt all values before starting the transaction, to keep it short.
+	writes := make([]*txnWrite, len(ops))
+	dataKeys := map[string]*dataKey{}
+	for i, op := range ops {
+		w := &txnWrite{op: op, options: store.NewSetOptions(op.Options...)}
+		switch op.Type {
+		case store.TxnOpSet:
+			if op.Value == nil {
+				return fmt.Errorf("operation %d: value not provided", i+1)
+			}
+			// Values in a new namespace must use the same data key.
+			dk, ok := dataKeys[op.Namespace]
+			if !ok {
+				var err error
+				if dk, err = s.loadDataKey(s, op.Namespace, true); err != nil {
+					return fmt.Errorf("operation %d: %w", i+1, err)
+				}
+				dataKeys[op.Namespace] = dk
+			}
+			var err error
+			w.dataKey = dk
+			w.encValue, w.meta, err = s.encryptValue(dk, op.Key, op.Value, w.options)
+			if err != nil {
+				return fmt.Errorf("operation %d: %w", i+1, err)
+			}
+		case store.TxnOpDelete:
+			if w.options.CreateOnly {
+				return fmt.Errorf("operation %d: the create-only condition is not supported for deletes", i+1)
+			}
+		default:
+			return fmt.Errorf("operation %d: invalid operation type '%s'", i+1, op.Type)
+		}
+		writes[i] = w
 	}
-	n, err := res.RowsAffected()
+
+	return s.withTx(func(tx *tx) error {
+		for i, w := range writes {
+			var err error
+			switch w.op.Type {
+			case store.TxnOpSet:
+				err = s.useDataKey(tx, w.dataKey)
+				if err == nil {
+					err = checkPreconditions(tx, w.op.Namespace, w.op.Key, w.options)
+				}
+				if err == nil {
+					err = setValue(tx, w.op.Namespace, w.op.Key, w.encValue, w.meta)
+				}
+			case store.TxnOpDelete:
+				err = deleteExistingKey(tx, w.op.Namespace, w.op.Key, w.options)
+			}
+			if err != nil {
+				return fmt.Errorf("operation %d: %w", i+1, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteExpired deletes all keys whose TTL has elapsed, along with their
+// history. It returns the number of deleted keys.
+func (s *Store) DeleteExpired() (int, error) {
+	var count int
+	err := s.withTx(func(tx *tx) error {
+		expired, err := expiredKeys(tx, types.NewFilter("1=1", []any{}))
+		if err != nil {
+			return err
+		}
+
+		allNamespaces, err := namespaces(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if _, ok := allNamespaces[k.namespace]; !ok {
+				continue
+			}
+			if _, err = deleteKey(tx, k.namespace, k.key); err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// List returns the keys within a specific namespace, or within all namespaces
+// if namespace is '*', optionally filtered by a key prefix.
+func (s *Store) List(namespace, keyPrefix string) (map[string][]string, error) {
+	metaPerNS, err := s.ListMetadata(namespace, keyPrefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if n == 0 {
-		return fmt.Errorf("key doesn't exist: %s", key)
+
+	keysPerNS := make(map[string][]string, len(metaPerNS))
+	for ns, nsMeta := range metaPerNS {
+		for _, meta := range nsMeta {
+			keysPerNS[ns] = append(keysPerNS[ns], meta.Key)
+		}
 	}
 
-	return nil
+	return keysPerNS, nil
 }
 
-func (s *Store) List(namespace, keyPrefix string) (map[string][]string, error) {
+// ListMetadata returns the metadata of keys within a specific namespace, or
+// within all namespaces if namespace is '*', optionally filtered by a key
+// prefix.
+func (s *Store) ListMetadata(namespace, keyPrefix string) (map[string][]*store.Metadata, error) {
 	// Validate the table name to ensure it actually exists. This prevents
 	// possible SQL injection attacks, since we parametrize the table name below.
-	allTables, err := queries.GetAllTables(s.NewContext(), s)
+	allNamespaces, err := namespaces(s)
 	if err != nil {
 		return nil, err
 	}
 
-	keysPerNS := make(map[string][]string)
+	metaPerNS := make(map[string][]*store.Metadata)
 
 	filter := types.NewFilter("1=1", []any{})
 	if keyPrefix != "" {
-		filter = types.NewFilter("key LIKE ? || '%'", []any{keyPrefix})
+		filter = types.NewFilter("t.key LIKE ? || '%'", []any{keyPrefix})
 	}
 
 	listNamespace := func(ns string) error {
-		query := fmt.Sprintf(
-			`SELECT key
-			FROM "%s"
-			WHERE %s
-			ORDER BY key ASC`, ns, filter.Where)
-		rows, err := s.QueryContext(s.ctx, query, filter.Args...)
+		nsMeta, err := listMetadata(s, ns, filter)
 		if err != nil {
 			return err
 		}
-
-		for rows.Next() {
-			var key string
-			err = rows.Scan(&key)
-			if err != nil {
-				return err
-			}
-			if nsKeys, ok := keysPerNS[ns]; !ok {
-				keysPerNS[ns] = []string{key}
-			} else {
-				nsKeys = append(nsKeys, key)
-				keysPerNS[ns] = nsKeys
-			}
+		if len(nsMeta) > 0 {
+			metaPerNS[ns] = nsMeta
 		}
 
 		return nil
 	}
 
 	if namespace == "*" {
-		for ns := range allTables {
+		for ns := range allNamespaces {
 			if err = listNamespace(ns); err != nil {
 				return nil, err
 			}
 		}
-	} else if _, ok := allTables[namespace]; !ok {
-		return keysPerNS, nil
+	} else if _, ok := allNamespaces[namespace]; !ok {
+		return metaPerNS, nil
 	} else if err = listNamespace(namespace); err != nil {
 		return nil, err
 	}
 
-	return keysPerNS, nil
+	return metaPerNS, nil
+}
+
+// Stat returns the metadata of a key within a specific namespace, or nil if
+// the key doesn't exist.
+func (s *Store) Stat(namespace, key string) (*store.Metadata, error) {
+	// Validate the table name to ensure it actually exists. This prevents
+	// possible SQL injection attacks, since we parametrize the table name below.
+	allNamespaces, err := namespaces(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := allNamespaces[namespace]; !ok {
+		return nil, nil
+	}
+
+	nsMeta, err := listMetadata(s, namespace, types.NewFilter("t.key = ?", []any{key}))
+	if err != nil {
+		return nil, err
+	}
+	if len(nsMeta) == 0 {
+		return nil, nil
+	}
+
+	return nsMeta[0], nil
 }
 
-// NewContext returns a new child context of the main database context.
+// NewContext returns the main database context.
 func (s *Store) NewContext() context.Context {
-	// TODO: Return cancel func?
-	ctx, _ := context.WithCancel(s.ctx)
-	return ctx
+	return s.ctx
 }
 
 // Init creates the database schema and initial records.
@@ -245,10 +725,453 @@ func (s *Store) Init(appVersion string, logger *slog.Logger) error {
 
 	dbCtx := s.NewContext()
 	_, err = s.ExecContext(dbCtx,
-		`INSERT INTO _meta (version) VALUES (?)`, appVersion)
+		`INSERT INTO _meta (version, encryption_version) VALUES (?, ?)`,
+		appVersion, encryptionVersion)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Migrate applies any pending migrations to an initialized store. Values
+// encrypted with a previous encryption scheme are re-encrypted, if the
+// encryption key is available.
+func (s *Store) Migrate(logger *slog.Logger) error {
+	err := migrator.RunMigrations(s, s.migrations, migrator.MigrationUp, "all", logger)
+	if err != nil {
+		return err
+	}
+
+	return s.upgradeEncryption(logger)
+}
+
+// checkNewNamespace returns an error if the namespace already exists, or if its
+// name is invalid.
+func (s *Store) checkNewNamespace(q types.Querier, namespace string) error {
+	allNamespaces, err := namespaces(q)
+	if err != nil {
+		return err
+	}
+	if _, ok := allNamespaces[namespace]; ok {
+		return fmt.Errorf("%w: %s", store.ErrNamespaceExists, namespace)
+	}
+
+	// Sanitize the name, since it's used as a table name.
+	if !s.validTableNameRx.Match([]byte(namespace)) {
+		return fmt.Errorf("%w: '%s'", store.ErrInvalidNamespace, namespace)
+	}
+
+	return nil
+}
+
+// checkNamespaceExists returns an error wrapping store.ErrNamespaceNotFound if
+// the namespace doesn't exist.
+func checkNamespaceExists(q types.Querier, namespace string) error {
+	// Validate the table name to ensure it actually exists. This prevents
+	// possible SQL injection attacks, since the table name is templated in
+	// queries.
+	allNamespaces, err := namespaces(q)
 	if err != nil {
 		return err
 	}
+	if _, ok := allNamespaces[namespace]; !ok {
+		return fmt.Errorf("%w: %s", store.ErrNamespaceNotFound, namespace)
+	}
+
+	return nil
+}
+
+// registerNamespace creates the table of a namespace and adds it to the
+// registry along with its wrapped data key. The namespace name must be
+// validated beforehand.
+func registerNamespace(tx *tx, namespace, description string, dataKeyEnc []byte) error {
+	_, err := tx.ExecContext(tx.NewContext(), fmt.Sprintf(`CREATE TABLE "%s" (
+		key VARCHAR UNIQUE NOT NULL,
+		value BLOB
+	)`, namespace))
+	if err != nil {
+		return aerrors.NewRuntimeError("failed creating namespace", err, "")
+	}
+
+	_, err = tx.ExecContext(tx.NewContext(),
+		`INSERT INTO _namespaces (name, created_at, description, data_key_enc)
+		VALUES (?, ?, ?, ?)`,
+		namespace, time.Now().UTC(), description, dataKeyEnc)
+	if err != nil {
+		return aerrors.NewRuntimeError("failed registering namespace", err, "")
+	}
 
 	return nil
 }
+
+// namespaceStats returns usage statistics of a namespace. The namespace must
+// exist.
+func namespaceStats(q types.Querier, namespace string) (*store.NamespaceStats, error) {
+	var stats store.NamespaceStats
+	err := q.QueryRowContext(q.NewContext(), fmt.Sprintf(
+		`SELECT COUNT(*), COALESCE(SUM(LENGTH(t.value)), 0)
+		FROM "%s" t
+		LEFT JOIN _keys k
+			ON k.namespace = ? AND k.key = t.key
+		WHERE %s`, namespace, notExpired),
+		namespace, time.Now().UTC()).Scan(&stats.Keys, &stats.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading namespace stats: %w", err)
+	}
+
+	return &stats, nil
+}
+
+// namespaces returns the names of all namespaces in the registry.
+func namespaces(q types.Querier) (map[string]struct{}, error) {
+	nss, err := listNamespaces(q)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(nss))
+	for _, ns := range nss {
+		names[ns.Name] = struct{}{}
+	}
+
+	return names, nil
+}
+
+// listNamespaces returns all namespaces in the registry, ordered by name.
+func listNamespaces(q types.Querier) ([]*store.Namespace, error) {
+	rows, err := q.QueryContext(q.NewContext(),
+		`SELECT name, created_at, description FROM _namespaces ORDER BY name ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading namespaces: %w", err)
+	}
+	defer rows.Close()
+
+	nss := []*store.Namespace{}
+	for rows.Next() {
+		var ns store.Namespace
+		if err = rows.Scan(&ns.Name, &ns.CreatedAt, &ns.Description); err != nil {
+			return nil, err
+		}
+		nss = append(nss, &ns)
+	}
+
+	return nss, rows.Err()
+}
+
+// encryptValue encrypts the value of a key with the data key of its namespace,
+// and returns the ciphertext along with the metadata of the unencrypted value.
+func (s *Store) encryptValue(
+	dk *dataKey, key string, value io.Reader, opts *store.SetOptions,
+) ([]byte, *store.Metadata, error) {
+	// http.DetectContentType considers at most 512 bytes.
+	const sniffLen = 512
+
+	br := bufio.NewReaderSize(value, sniffLen)
+	contentType := opts.ContentType
+	if contentType == "" {
+		head, err := br.Peek(sniffLen)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, aerrors.NewRuntimeError("failed reading value", err, "")
+		}
+		contentType = http.DetectContentType(head)
+	}
+
+	cr := &countingReader{r: br}
+	encValue, err := s.encrypt(dk, key, cr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := &store.Metadata{Author: opts.Author, Size: cr.n, ContentType: contentType}
+	if opts.TTL > 0 {
+		meta.ExpiresAt = time.Now().UTC().Add(opts.TTL)
+	}
+
+	return encValue, meta, nil
+}
+
+// setValue writes the encrypted value of a key and its metadata, and records
+// it as a new version in the key history. The namespace must exist.
+func setValue(tx *tx, namespace, key string, encValue []byte, meta *store.Metadata) error {
+	ctx := tx.NewContext()
+	now := time.Now().UTC()
+
+	// An expired key that hasn't been deleted yet is replaced, rather than
+	// updated, so that its history isn't resurrected.
+	expired, err := expiredKeys(tx, types.NewFilter("k.namespace = ? AND k.key = ?", []any{namespace, key}))
+	if err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		if _, err = deleteKey(tx, namespace, key); err != nil {
+			return err
+		}
+	}
+
+	var version sql.Null[int]
+	err = tx.QueryRowContext(ctx, `SELECT MAX(version)
+		FROM _history
+		WHERE namespace = ? AND key = ?`, namespace, key).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed reading key history: %w", err)
+	}
+
+	if !version.Valid {
+		// Keys written before history tracking was added don't have any
+		// versions, so preserve the existing value as the first version.
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(
+			`INSERT INTO _history (namespace, key, version, value, created_at)
+			SELECT ?, key, 1, value, ? FROM "%s" WHERE key = ?`, namespace),
+			namespace, now, key)
+		if err != nil {
+			return fmt.Errorf("failed saving key history: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		version.V = int(n)
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO "%s" (key, value)
+		VALUES (:key, :value)
+		ON CONFLICT(key) DO UPDATE SET value = :value`, namespace),
+		sql.Named("key", key), sql.Named("value", encValue))
+	if err != nil {
+		return aerrors.NewRuntimeError("failed setting key", err, "")
+	}
+
+	var author sql.Null[string]
+	if meta.Author != "" {
+		author = sql.Null[string]{V: meta.Author, Valid: true}
+	}
+
+	var expiresAt sql.Null[time.Time]
+	if !meta.ExpiresAt.IsZero() {
+		expiresAt = sql.Null[time.Time]{V: meta.ExpiresAt.UTC(), Valid: true}
+	}
+
+	// The revision is taken from a counter of the whole store, rather than
+	// derived from the version, which starts over if the key is deleted and
+	// created again. Otherwise, a conditional write could succeed on a
+	// different value that happens to have the same revision.
+	var revision int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE _meta SET last_revision = last_revision + 1 RETURNING last_revision`).Scan(&revision)
+	if err != nil {
+		return fmt.Errorf("failed incrementing revision: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO _keys (namespace, key, created_at, updated_at, author, size, content_type, expires_at, revision)
+		VALUES (:namespace, :key, :now, :now, :author, :size, :content_type, :expires_at, :revision)
+		ON CONFLICT(namespace, key) DO UPDATE
+		SET updated_at = :now, author = :author, size = :size,
+			content_type = :content_type, expires_at = :expires_at, revision = :revision`,
+		sql.Named("namespace", namespace), sql.Named("key", key),
+		sql.Named("now", now), sql.Named("author", author),
+		sql.Named("size", meta.Size), sql.Named("content_type", meta.ContentType),
+		sql.Named("expires_at", expiresAt), sql.Named("revision", revision))
+	if err != nil {
+		return fmt.Errorf("failed saving key metadata: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO _history (namespace, key, version, value, created_at, author, size, content_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		namespace, key, version.V+1, encValue, now, author, meta.Size, meta.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed saving key history: %w", err)
+	}
+
+	return nil
+}
+
+// checkPreconditions returns an error wrapping store.ErrPreconditionFailed if
+// the current state of the key doesn't satisfy the conditions of the write.
+// The namespace must exist.
+func checkPreconditions(tx *tx, namespace, key string, opts *store.SetOptions) error {
+	if !opts.CreateOnly && opts.IfRevision == 0 {
+		return nil
+	}
+
+	nsMeta, err := listMetadata(tx, namespace, types.NewFilter("t.key = ?", []any{key}))
+	if err != nil {
+		return err
+	}
+
+	if opts.CreateOnly && len(nsMeta) > 0 {
+		return fmt.Errorf("%w: key '%s' already exists", store.ErrPreconditionFailed, key)
+	}
+
+	if opts.IfRevision > 0 {
+		if len(nsMeta) == 0 {
+			return fmt.Errorf("%w: key '%s' doesn't exist", store.ErrPreconditionFailed, key)
+		}
+		if rev := nsMeta[0].Revision; rev != opts.IfRevision {
+			return fmt.Errorf("%w: key '%s' is at revision %d, not %d",
+				store.ErrPreconditionFailed, key, rev, opts.IfRevision)
+		}
+	}
+
+	return nil
+}
+
+// deleteExistingKey deletes a key within a namespace, along with its history
+// and metadata. An error is returned if the namespace or key don't exist, or
+// if the preconditions of the delete aren't met.
+func deleteExistingKey(tx *tx, namespace, key string, opts *store.SetOptions) error {
+	// Validate the table name to ensure it actually exists. This prevents
+	// possible SQL injection attacks, since we parametrize the table name below.
+	allNamespaces, err := namespaces(tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := allNamespaces[namespace]; !ok {
+		return fmt.Errorf("%w: %s", store.ErrNamespaceNotFound, namespace)
+	}
+
+	if err = checkPreconditions(tx, namespace, key, opts); err != nil {
+		return err
+	}
+
+	n, err := deleteKey(tx, namespace, key)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("key doesn't exist: %s", key)
+	}
+
+	return nil
+}
+
+// deleteKey deletes a key within a namespace, along with its history and
+// metadata. It returns the number of deleted values, which is 0 if the key
+// doesn't exist. The namespace must exist.
+func deleteKey(tx *tx, namespace, key string) (int64, error) {
+	// Namespaces are stored in different tables, but parameterization is not
+	// supported for table names, so template it manually.
+	res, err := tx.ExecContext(tx.NewContext(),
+		fmt.Sprintf(`DELETE FROM "%s" WHERE key = ?`, namespace), key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(tx.NewContext(),
+		`DELETE FROM _history WHERE namespace = ? AND key = ?`, namespace, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed deleting key history: %w", err)
+	}
+
+	_, err = tx.ExecContext(tx.NewContext(),
+		`DELETE FROM _keys WHERE namespace = ? AND key = ?`, namespace, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed deleting key metadata: %w", err)
+	}
+
+	return n, nil
+}
+
+// notExpired is the SQL condition that excludes expired keys from queries that
+// join the _keys table with the alias k. It must be followed by an argument
+// with the current time.
+const notExpired = "(k.expires_at IS NULL OR k.expires_at > ?)"
+
+type namespacedKey struct {
+	namespace, key string
+}
+
+// expiredKeys returns the keys matching the filter whose TTL has elapsed.
+func expiredKeys(q types.Querier, filter *types.Filter) ([]namespacedKey, error) {
+	query := fmt.Sprintf(`SELECT k.namespace, k.key
+		FROM _keys k
+		WHERE k.expires_at <= ? AND %s`, filter.Where)
+	args := append([]any{time.Now().UTC()}, filter.Args...)
+
+	rows, err := q.QueryContext(q.NewContext(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading expired keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []namespacedKey
+	for rows.Next() {
+		var k namespacedKey
+		if err = rows.Scan(&k.namespace, &k.key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// listMetadata returns the metadata of keys within a namespace that match the
+// filter. The namespace must exist.
+func listMetadata(q types.Querier, namespace string, filter *types.Filter) ([]*store.Metadata, error) {
+	// Namespaces are stored in different tables, but parameterization is not
+	// supported for table names, so template it manually.
+	query := fmt.Sprintf(
+		`SELECT t.key, k.created_at, k.updated_at, k.author, k.size, k.content_type, k.expires_at, k.revision
+		FROM "%s" t
+		LEFT JOIN _keys k
+			ON k.namespace = ? AND k.key = t.key
+		WHERE %s AND %s
+		ORDER BY t.key ASC`, namespace, filter.Where, notExpired)
+	args := append([]any{namespace}, filter.Args...)
+	args = append(args, time.Now().UTC())
+
+	rows, err := q.QueryContext(q.NewContext(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nsMeta := []*store.Metadata{}
+	for rows.Next() {
+		var (
+			meta        store.Metadata
+			createdAt   sql.Null[time.Time]
+			updatedAt   sql.Null[time.Time]
+			author      sql.Null[string]
+			size        sql.Null[int64]
+			contentType sql.Null[string]
+			expiresAt   sql.Null[time.Time]
+			revision    sql.Null[int]
+		)
+		err = rows.Scan(&meta.Key, &createdAt, &updatedAt, &author, &size,
+			&contentType, &expiresAt, &revision)
+		if err != nil {
+			return nil, err
+		}
+		meta.CreatedAt = createdAt.V
+		meta.UpdatedAt = updatedAt.V
+		meta.Author = author.V
+		meta.Size = size.V
+		meta.ContentType = contentType.V
+		meta.ExpiresAt = expiresAt.V
+		meta.Revision = revision.V
+		nsMeta = append(nsMeta, &meta)
+	}
+
+	return nsMeta, rows.Err()
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.hackfix.me/disco/db/types"
+)
+
+// tx is a database transaction that implements the types.Querier interface.
+type tx struct {
+	*sql.Tx
+	ctx context.Context
+}
+
+var _ types.Querier = &tx{}
+
+// NewContext returns the transaction context.
+func (t *tx) NewContext() context.Context {
+	return t.ctx
+}
+
+// txBeginner is implemented by *sql.DB and *sql.Conn.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// withTx runs fn within a database transaction. The transaction is committed
+// if fn returns nil, and rolled back otherwise.
+func (s *Store) withTx(fn func(tx *tx) error) error {
+	return s.withTxOn(s.DB, fn)
+}
+
+// withTxOn is like withTx, but starts the transaction on a specific
+// connection.
+func (s *Store) withTxOn(b txBeginner, fn func(tx *tx) error) error {
+	sqlTx, err := b.BeginTx(s.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed starting transaction: %w", err)
+	}
+
+	if err = fn(&tx{Tx: sqlTx, ctx: s.ctx}); err != nil {
+		_ = sqlTx.Rollback()
+		return err
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed committing transaction: %w", err)
+	}
+
+	return nil
+}
@@ -3,15 +3,99 @@ package store
 import (
 	"io"
 	"log/slog"
+	"time"
+
+	"go.hackfix.me/disco/db/types"
 )
 
 // Store defines the operations data stores must implement to store and retrieve
 // data.
 type Store interface {
 	Init(appVersion string, logger *slog.Logger) error
+	Migrate(logger *slog.Logger) error
 	Close() error
 	Get(namespace, key string) (ok bool, value io.Reader, err error)
-	Set(namespace, key string, value io.Reader) error
+	GetVersion(namespace, key string, version int) (ok bool, value io.Reader, err error)
+	Set(namespace, key string, value io.Reader, opts ...SetOption) error
 	Delete(namespace, key string) error
 	List(namespace, keyPrefix string) (map[string][]string, error)
+	ListMetadata(namespace, keyPrefix string) (map[string][]*Metadata, error)
+	Stat(namespace, key string) (*Metadata, error)
+	History(namespace, key string) ([]*Version, error)
+	Rollback(namespace, key string, version int, opts ...SetOption) error
+	DeleteExpired() (int, error)
+	Txn(ops []*TxnOp) error
+	Namespaces() ([]*Namespace, error)
+	CreateNamespace(name, description string) error
+	DeleteNamespace(name string, force bool) error
+	RenameNamespace(name, newName string) error
+	CopyNamespace(name, newName string) error
+	NamespaceStats(name string) (*NamespaceStats, error)
+	RewrapKeys(newKey *[32]byte, attach map[string]string, update func(q types.Querier) error) error
+	RotateKeys(newKey *[32]byte, attach map[string]string, update func(q types.Querier) error) error
+	RotateNamespaceKey(namespace string) error
+	SetEncryptionKey(encKey *[32]byte)
+}
+
+// Version is a record of a value written to a key. A new version is created
+// each time the key is set, and the value of the latest version is the current
+// value of the key.
+type Version struct {
+	Number      int
+	CreatedAt   time.Time
+	Author      string
+	Size        int64
+	ContentType string
+}
+
+// Metadata is information about a key and its current value. Keys written
+// before metadata tracking was added only have the Key field set.
+type Metadata struct {
+	Key         string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Author      string // name of the user that last wrote the value
+	Size        int64  // size of the unencrypted value in bytes
+	ContentType string
+	ExpiresAt   time.Time // zero if the key never expires
+	// Revision identifies the current value, and can be used for conditional
+	// writes. It changes on every write, and is never reused within the
+	// store, even if the key is deleted and created again. Unlike the version
+	// number, it's not sequential for each key.
+	Revision int
+}
+
+// Namespace is a group of keys.
+type Namespace struct {
+	Name        string
+	CreatedAt   time.Time
+	Description string
+}
+
+// NamespaceStats are usage statistics of a namespace.
+type NamespaceStats struct {
+	Keys int
+	Size int64 // total size of the encrypted values in bytes
+}
+
+// TxnOpType is the type of a transaction operation.
+type TxnOpType string
+
+// Valid transaction operation types.
+const (
+	TxnOpSet    TxnOpType = "set"
+	TxnOpDelete TxnOpType = "delete"
+)
+
+// TxnOp is an operation that is applied atomically along with other operations
+// in a transaction. Either all operations succeed, or none are applied.
+type TxnOp struct {
+	Type      TxnOpType
+	Namespace string
+	Key       string
+	// Value is only used by set operations.
+	Value io.Reader
+	// Options of the write. Delete operations only support the IfRevision
+	// option.
+	Options []SetOption
 }
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.hackfix.me/disco/db/types"
+)
+
+// tx is a database transaction that implements the types.Querier interface.
+type tx struct {
+	*sql.Tx
+	ctx context.Context
+}
+
+var _ types.Querier = &tx{}
+
+// NewContext returns the transaction context.
+func (t *tx) NewContext() context.Context {
+	return t.ctx
+}
+
+// withTx runs fn within a database transaction. The transaction is committed
+// if fn returns nil, and rolled back otherwise.
+func (d *DB) withTx(fn func(tx *tx) error) error {
+	sqlTx, err := d.BeginTx(d.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed starting transaction: %w", err)
+	}
+
+	if err = fn(&tx{Tx: sqlTx, ctx: d.ctx}); err != nil {
+		_ = sqlTx.Rollback()
+		return err
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed committing transaction: %w", err)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"slices"
+	"strings"
 )
 
 // Querier exposes only methods for running SQL queries, and some helper functions.
@@ -39,3 +40,16 @@ func (f1 *Filter) Or(f2 *Filter) *Filter {
 		Args:  slices.Concat(f1.Args, f2.Args),
 	}
 }
+
+// SQLiteDSN returns the data source name for opening the SQLite database at
+// path. Temporary tables and indexes, e.g. those created by VACUUM or large
+// sorts, are kept in memory, so that SQLite doesn't need to write to the system
+// temporary directory, which a sandboxed process isn't allowed to, and so that
+// decrypted data doesn't end up in temporary files.
+func SQLiteDSN(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_pragma=temp_store(memory)"
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NewAgent returns a client of the local agent listening on the Unix socket.
+// Access to the agent is restricted by the socket file permissions, so TLS
+// isn't used.
+func NewAgent(socketPath string) *Client {
+	var dialer net.Dialer
+	return &Client{
+		Client: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+		scheme:  "http",
+		address: "agent",
+	}
+}
+
+// AgentLock stops the agent, which forgets the encryption key.
+func (c *Client) AgentLock(ctx context.Context) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/agent/lock"}
+	return c.sendJSON(ctx, "POST", u, nil, nil)
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// AuthCheck returns whether a user is allowed to perform the action on the
+// target in the namespace of the remote node, and explains the decision. The
+// target is in "<resource>:<pattern>" format. If user is empty, the
+// authenticated user is checked.
+func (c *Client) AuthCheck(ctx context.Context, user, action, namespace, target string) (*types.AuthDecision, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/auth/check"}
+	query := url.Values{}
+	if user != "" {
+		query.Set("user", user)
+	}
+	query.Set("action", action)
+	query.Set("namespace", namespace)
+	query.Set("target", target)
+	u.RawQuery = query.Encode()
+
+	resp := &types.AuthCheckResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
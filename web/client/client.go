@@ -1,15 +1,24 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/web/server/types"
 )
 
 type Client struct {
 	*http.Client
+	scheme  string
 	address string
 }
 
@@ -26,6 +35,95 @@ func New(address string, tlsConfig *tls.Config) *Client {
 				TLSClientConfig:    tlsConfig,
 			},
 		},
+		scheme:  "https",
 		address: address,
 	}
 }
+
+// Ping checks whether the server is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/ping"}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed sending request: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request 'GET %s' failed with status %s", u.String(), resp.Status)
+	}
+
+	return nil
+}
+
+// objectURL returns the URL of an object in an API collection, e.g. a user.
+// The object name is escaped, since it may contain slashes.
+func (c *Client) objectURL(collection, name string) *url.URL {
+	rawPath := fmt.Sprintf("/api/v1/%s/%s", collection, url.PathEscape(name))
+	path, _ := url.PathUnescape(rawPath)
+
+	return &url.URL{Scheme: c.scheme, Host: c.address, Path: path, RawPath: rawPath}
+}
+
+// sendJSON sends a request with the JSON encoded reqBody, if it's not nil, and
+// decodes the JSON response body into respBody. An error with the message
+// received from the server is returned if the response status is not 200 OK.
+func (c *Client) sendJSON(ctx context.Context, method string, u *url.URL, reqBody, respBody any) error {
+	var body io.Reader
+	if reqBody != nil {
+		reqData, err := json.Marshal(reqBody)
+		if err != nil {
+			return fmt.Errorf("failed marshalling request body: %w", err)
+		}
+		body = bytes.NewReader(reqData)
+	}
+
+	reqCtx, cancelReqCtx := context.WithCancel(ctx)
+	defer cancelReqCtx()
+
+	req, err := http.NewRequestWithContext(reqCtx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(method, u, resp, respData)
+	}
+
+	if respBody != nil {
+		if err = json.Unmarshal(respData, respBody); err != nil {
+			return fmt.Errorf("failed unmarshalling response body: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// responseError returns an error with the message received from the server in
+// the response body, or with the response status if there is none.
+func responseError(method string, u *url.URL, resp *http.Response, respData []byte) error {
+	errResp := &types.Response{}
+	if err := json.Unmarshal(respData, errResp); err != nil || errResp.Error == "" {
+		return fmt.Errorf("request '%s %s' failed with status %s", method, u.String(), resp.Status)
+	}
+	return errors.New(errResp.Error)
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// InviteList returns the invites on the remote node for users that the user
+// is allowed to manage. If all is true, expired invites are included.
+func (c *Client) InviteList(ctx context.Context, all bool) ([]*types.Invite, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/invites"}
+	if all {
+		u.RawQuery = "all=true"
+	}
+
+	resp := &types.InviteListResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// InviteCreate creates a new invite for a user of the remote node, valid for
+// the given duration.
+func (c *Client) InviteCreate(ctx context.Context, user string, ttl time.Duration) (*types.Invite, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/invites"}
+	req := &types.InviteCreateRequest{User: user, TTL: ttl.String()}
+
+	resp := &types.InviteCreateResponse{}
+	if err := c.sendJSON(ctx, "POST", u, req, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// InviteUpdate extends the validity period of an invite on the remote node.
+// The UUID may be a prefix, as long as it matches a single invite.
+func (c *Client) InviteUpdate(ctx context.Context, uuid string, ttl time.Duration) error {
+	u := c.objectURL("invites", uuid)
+	req := &types.InviteUpdateRequest{TTL: ttl.String()}
+
+	return c.sendJSON(ctx, "PUT", u, req, &types.InviteUpdateResponse{})
+}
+
+// InviteDelete deletes an invite on the remote node. The UUID may be a prefix,
+// as long as it matches a single invite.
+func (c *Client) InviteDelete(ctx context.Context, uuid string) error {
+	return c.sendJSON(ctx, "DELETE", c.objectURL("invites", uuid), nil, &types.InviteDeleteResponse{})
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// NamespaceList returns the namespaces in the remote store that the user is
+// allowed to read.
+func (c *Client) NamespaceList(ctx context.Context) ([]*types.Namespace, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/namespaces"}
+
+	resp := &types.NamespaceListResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// NamespaceCreate creates a new empty namespace in the remote store.
+func (c *Client) NamespaceCreate(ctx context.Context, name, description string) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/namespaces"}
+	req := &types.NamespaceCreateRequest{Name: name, Description: description}
+
+	return c.sendJSON(ctx, "POST", u, req, &types.NamespaceCreateResponse{})
+}
+
+// NamespaceDelete deletes a namespace in the remote store. If force is true,
+// the namespace is deleted even if it contains keys.
+func (c *Client) NamespaceDelete(ctx context.Context, name string, force bool) error {
+	u := c.namespaceURL(name, "")
+	if force {
+		u.RawQuery = "force=true"
+	}
+
+	return c.sendJSON(ctx, "DELETE", u, nil, &types.NamespaceDeleteResponse{})
+}
+
+// NamespaceRename renames a namespace in the remote store.
+func (c *Client) NamespaceRename(ctx context.Context, name, newName string) error {
+	u := c.namespaceURL(name, "rename")
+	req := &types.NamespaceRenameRequest{Name: newName}
+
+	return c.sendJSON(ctx, "POST", u, req, &types.NamespaceRenameResponse{})
+}
+
+// NamespaceCopy copies all keys in a namespace to a new namespace in the
+// remote store.
+func (c *Client) NamespaceCopy(ctx context.Context, name, newName string) error {
+	u := c.namespaceURL(name, "copy")
+	req := &types.NamespaceRenameRequest{Name: newName}
+
+	return c.sendJSON(ctx, "POST", u, req, &types.NamespaceRenameResponse{})
+}
+
+// NamespaceStats returns usage statistics of a namespace in the remote store.
+func (c *Client) NamespaceStats(ctx context.Context, name string) (*types.NamespaceStatsResponse, error) {
+	u := c.namespaceURL(name, "stats")
+
+	resp := &types.NamespaceStatsResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// namespaceURL returns the URL of a namespace endpoint. The namespace name is
+// escaped, since it may contain slashes.
+func (c *Client) namespaceURL(name, action string) *url.URL {
+	rawPath := "/api/v1/namespaces/" + url.PathEscape(name)
+	if action != "" {
+		rawPath += "/" + action
+	}
+	path, _ := url.PathUnescape(rawPath)
+
+	return &url.URL{Scheme: c.scheme, Host: c.address, Path: path, RawPath: rawPath}
+}
@@ -65,3 +65,21 @@ func (c *Client) RemoteJoin(ctx context.Context, token, pubKey string) ([]byte,
 
 	return joinRespPayloadEnc, nil
 }
+
+// RemoteList returns the remotes of the remote node that the user is allowed to
+// read.
+func (c *Client) RemoteList(ctx context.Context) ([]*types.Remote, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/remotes"}
+
+	resp := &types.RemoteListResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// RemoteDelete removes a remote from the remote node.
+func (c *Client) RemoteDelete(ctx context.Context, name string) error {
+	return c.sendJSON(ctx, "DELETE", c.objectURL("remotes", name), nil, &types.RemoteDeleteResponse{})
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// RoleList returns the roles of the remote node that the user is allowed to
+// read.
+func (c *Client) RoleList(ctx context.Context) ([]*types.Role, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/roles"}
+
+	resp := &types.RoleListResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// RoleCreate adds a new role with the given permissions to the remote node.
+func (c *Client) RoleCreate(ctx context.Context, name string, permissions []string) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/roles"}
+	req := &types.RoleCreateRequest{Name: name, Permissions: permissions}
+
+	return c.sendJSON(ctx, "POST", u, req, &types.RoleCreateResponse{})
+}
+
+// RoleUpdate replaces the permissions of a role on the remote node.
+func (c *Client) RoleUpdate(ctx context.Context, name string, permissions []string) error {
+	u := c.objectURL("roles", name)
+	req := &types.RoleCreateRequest{Name: name, Permissions: permissions}
+
+	return c.sendJSON(ctx, "PUT", u, req, &types.RoleCreateResponse{})
+}
+
+// RoleDelete removes a role from the remote node. If force is true, the role
+// is removed even if it's assigned to users.
+func (c *Client) RoleDelete(ctx context.Context, name string, force bool) error {
+	u := c.objectURL("roles", name)
+	if force {
+		u.RawQuery = "force=true"
+	}
+
+	return c.sendJSON(ctx, "DELETE", u, nil, &types.RoleDeleteResponse{})
+}
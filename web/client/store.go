@@ -9,20 +9,30 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 
+	"go.hackfix.me/disco/db/store"
 	"go.hackfix.me/disco/web/server/types"
 )
 
-func (c *Client) StoreGet(ctx context.Context, namespace, key string) (ok bool, value io.Reader, err error) {
+// StoreGet returns the value of a key from the remote store. If version is
+// greater than 0, the value of that version of the key is returned instead of
+// the current value.
+func (c *Client) StoreGet(ctx context.Context, namespace, key string, version int) (ok bool, value io.Reader, err error) {
 	path, err := url.JoinPath("/api/v1/store/value", key)
 	if err != nil {
 		return false, nil, fmt.Errorf("failed joining URL path: %w", err)
 	}
-	u := &url.URL{Scheme: "https", Host: c.address, Path: path}
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: path}
 
-	if namespace != "" {
+	if namespace != "" || version > 0 {
 		q := u.Query()
-		q.Set("namespace", namespace)
+		if namespace != "" {
+			q.Set("namespace", namespace)
+		}
+		if version > 0 {
+			q.Set("version", strconv.Itoa(version))
+		}
 		qDec, err := url.QueryUnescape(q.Encode())
 		if err != nil {
 			return false, nil, fmt.Errorf("failed decoding query string: %w", err)
@@ -44,10 +54,12 @@ func (c *Client) StoreGet(ctx context.Context, namespace, key string) (ok bool,
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return false, nil, fmt.Errorf(
-			"request 'GET %s' failed with status %s",
-			u.String(), resp.Status)
+		respData, _ := io.ReadAll(resp.Body)
+		return false, nil, responseError("GET", u, resp, respData)
 	}
 
 	var body bytes.Buffer
@@ -56,16 +68,27 @@ func (c *Client) StoreGet(ctx context.Context, namespace, key string) (ok bool,
 	return true, &body, nil
 }
 
-func (c *Client) StoreSet(ctx context.Context, namespace, key string, value io.Reader) error {
+// StoreSet stores the value of a key in the remote store. The author option is
+// ignored, since the remote node sets it from the client TLS certificate. If
+// the write is conditional and the condition isn't met, an error wrapping
+// store.ErrPreconditionFailed is returned.
+func (c *Client) StoreSet(ctx context.Context, namespace, key string, value io.Reader, opts ...store.SetOption) error {
+	options := store.NewSetOptions(opts...)
+
 	path, err := url.JoinPath("/api/v1/store/value", key)
 	if err != nil {
 		return fmt.Errorf("failed joining URL path: %w", err)
 	}
-	u := &url.URL{Scheme: "https", Host: c.address, Path: path}
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: path}
 
-	if namespace != "" {
+	if namespace != "" || options.TTL > 0 {
 		q := u.Query()
-		q.Set("namespace", namespace)
+		if namespace != "" {
+			q.Set("namespace", namespace)
+		}
+		if options.TTL > 0 {
+			q.Set("ttl", options.TTL.String())
+		}
 		qDec, err := url.QueryUnescape(q.Encode())
 		if err != nil {
 			return fmt.Errorf("failed decoding query string: %w", err)
@@ -80,6 +103,15 @@ func (c *Client) StoreSet(ctx context.Context, namespace, key string, value io.R
 	if err != nil {
 		return fmt.Errorf("failed creating request: %w", err)
 	}
+	if options.ContentType != "" {
+		req.Header.Set("Content-Type", options.ContentType)
+	}
+	if options.IfRevision > 0 {
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, options.IfRevision))
+	}
+	if options.CreateOnly {
+		req.Header.Set("If-None-Match", "*")
+	}
 
 	resp, err := c.Do(req)
 	if err != nil {
@@ -98,7 +130,9 @@ func (c *Client) StoreSet(ctx context.Context, namespace, key string, value io.R
 		return fmt.Errorf("failed unmarshalling response body: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return preconditionError(setResp.Error)
+	} else if resp.StatusCode != http.StatusOK {
 		return errors.New(setResp.Error)
 	}
 
@@ -106,11 +140,39 @@ func (c *Client) StoreSet(ctx context.Context, namespace, key string, value io.R
 }
 
 func (c *Client) StoreList(ctx context.Context, namespace, keyPrefix string) (map[string][]string, error) {
-	u := &url.URL{Scheme: "https", Host: c.address, Path: "/api/v1/store/keys"}
+	keysResp, err := c.storeKeys(ctx, namespace, keyPrefix, false)
+	if err != nil {
+		return nil, err
+	}
 
-	if namespace != "" {
+	return keysResp.Data, nil
+}
+
+// StoreListMetadata returns the metadata of keys in the remote store.
+func (c *Client) StoreListMetadata(ctx context.Context, namespace, keyPrefix string) (map[string][]*types.StoreKeyMetadata, error) {
+	keysResp, err := c.storeKeys(ctx, namespace, keyPrefix, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return keysResp.Metadata, nil
+}
+
+func (c *Client) storeKeys(ctx context.Context, namespace, keyPrefix string, metadata bool) (*types.StoreKeysResponse, error) {
+	path, err := url.JoinPath("/api/v1/store/keys", keyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed joining URL path: %w", err)
+	}
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: path}
+
+	if namespace != "" || metadata {
 		q := u.Query()
-		q.Set("namespace", namespace)
+		if namespace != "" {
+			q.Set("namespace", namespace)
+		}
+		if metadata {
+			q.Set("metadata", "true")
+		}
 		qDec, err := url.QueryUnescape(q.Encode())
 		if err != nil {
 			return nil, fmt.Errorf("failed decoding query string: %w", err)
@@ -147,5 +209,169 @@ func (c *Client) StoreList(ctx context.Context, namespace, keyPrefix string) (ma
 		return nil, errors.New(keysResp.Error)
 	}
 
-	return keysResp.Data, nil
+	return keysResp, nil
+}
+
+// StoreHistory returns the versions of a key in the remote store.
+func (c *Client) StoreHistory(ctx context.Context, namespace, key string) ([]*types.StoreVersion, error) {
+	path, err := url.JoinPath("/api/v1/store/history", key)
+	if err != nil {
+		return nil, fmt.Errorf("failed joining URL path: %w", err)
+	}
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: path}
+
+	if namespace != "" {
+		q := u.Query()
+		q.Set("namespace", namespace)
+		qDec, err := url.QueryUnescape(q.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding query string: %w", err)
+		}
+		u.RawQuery = qDec
+	}
+
+	reqCtx, cancelReqCtx := context.WithCancel(ctx)
+	defer cancelReqCtx()
+
+	req, err := http.NewRequestWithContext(reqCtx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	historyBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading response body: %w", err)
+	}
+
+	historyResp := &types.StoreHistoryResponse{}
+	err = json.Unmarshal(historyBody, historyResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(historyResp.Error)
+	}
+
+	return historyResp.Data, nil
 }
+
+// StoreRollback sets the value of a key in the remote store to the value of a
+// previous version.
+func (c *Client) StoreRollback(ctx context.Context, namespace, key string, version int) error {
+	path, err := url.JoinPath("/api/v1/store/rollback", key)
+	if err != nil {
+		return fmt.Errorf("failed joining URL path: %w", err)
+	}
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: path}
+
+	q := u.Query()
+	if namespace != "" {
+		q.Set("namespace", namespace)
+	}
+	q.Set("version", strconv.Itoa(version))
+	qDec, err := url.QueryUnescape(q.Encode())
+	if err != nil {
+		return fmt.Errorf("failed decoding query string: %w", err)
+	}
+	u.RawQuery = qDec
+
+	reqCtx, cancelReqCtx := context.WithCancel(ctx)
+	defer cancelReqCtx()
+
+	req, err := http.NewRequestWithContext(reqCtx, "POST", u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	rollbackRespBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading response body: %w", err)
+	}
+
+	rollbackResp := &types.StoreRollbackResponse{}
+	err = json.Unmarshal(rollbackRespBody, rollbackResp)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(rollbackResp.Error)
+	}
+
+	return nil
+}
+
+// StoreTxn applies multiple set and delete operations atomically in the remote
+// store. If the precondition of an operation isn't met, an error wrapping
+// store.ErrPreconditionFailed is returned.
+func (c *Client) StoreTxn(ctx context.Context, ops []*types.StoreTxnOp) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/store/txn"}
+
+	txnReqBody, err := json.Marshal(&types.StoreTxnRequest{Ops: ops})
+	if err != nil {
+		return fmt.Errorf("failed marshalling request body: %w", err)
+	}
+
+	reqCtx, cancelReqCtx := context.WithCancel(ctx)
+	defer cancelReqCtx()
+
+	req, err := http.NewRequestWithContext(reqCtx, "POST", u.String(), bytes.NewReader(txnReqBody))
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	txnRespBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading response body: %w", err)
+	}
+
+	txnResp := &types.StoreTxnResponse{}
+	err = json.Unmarshal(txnRespBody, txnResp)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return preconditionError(txnResp.Error)
+	} else if resp.StatusCode != http.StatusOK {
+		return errors.New(txnResp.Error)
+	}
+
+	return nil
+}
+
+// preconditionError returns an error with the message received from the remote
+// node, that wraps store.ErrPreconditionFailed.
+func preconditionError(msg string) error {
+	return &remoteError{msg: msg, target: store.ErrPreconditionFailed}
+}
+
+// remoteError is an error received from a remote node, that wraps a known
+// error so that it can be inspected with errors.Is.
+type remoteError struct {
+	msg    string
+	target error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.target }
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// SysSealStatus returns the seal status of the remote server.
+func (c *Client) SysSealStatus(ctx context.Context) (*types.SysSealStatusResponse, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/sys/seal-status"}
+
+	resp := &types.SysSealStatusResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// SysSeal seals the remote server, which drops its encryption key from memory.
+func (c *Client) SysSeal(ctx context.Context) (*types.SysSealStatusResponse, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/sys/seal"}
+
+	resp := &types.SysSealStatusResponse{}
+	if err := c.sendJSON(ctx, "POST", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// SysUnseal unseals the remote server with its encryption key, or with one or
+// more of its key shares.
+func (c *Client) SysUnseal(ctx context.Context, key string, shares []string) (*types.SysSealStatusResponse, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/sys/unseal"}
+	req := &types.SysUnsealRequest{Key: key, Shares: shares}
+
+	resp := &types.SysSealStatusResponse{}
+	if err := c.sendJSON(ctx, "POST", u, req, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// UserList returns the users of the remote node that the user is allowed to
+// read.
+func (c *Client) UserList(ctx context.Context) ([]*types.User, error) {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/users"}
+
+	resp := &types.UserListResponse{}
+	if err := c.sendJSON(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// UserCreate adds a new user with the given roles to the remote node.
+func (c *Client) UserCreate(ctx context.Context, name string, roles []string) error {
+	u := &url.URL{Scheme: c.scheme, Host: c.address, Path: "/api/v1/users"}
+	req := &types.UserCreateRequest{Name: name, Roles: roles}
+
+	return c.sendJSON(ctx, "POST", u, req, &types.UserCreateResponse{})
+}
+
+// UserUpdate replaces the roles of a user on the remote node.
+func (c *Client) UserUpdate(ctx context.Context, name string, roles []string) error {
+	u := c.objectURL("users", name)
+	req := &types.UserCreateRequest{Name: name, Roles: roles}
+
+	return c.sendJSON(ctx, "PUT", u, req, &types.UserCreateResponse{})
+}
+
+// UserDelete removes a user from the remote node.
+func (c *Client) UserDelete(ctx context.Context, name string) error {
+	return c.sendJSON(ctx, "DELETE", c.objectURL("users", name), nil, &types.UserDeleteResponse{})
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	actx "go.hackfix.me/disco/app/context"
+	apiv1 "go.hackfix.me/disco/web/server/api/v1"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// Agent is a server that keeps the node unlocked, and serves the API to local
+// clients over a Unix socket. Clients are authenticated as the local user, so
+// access is restricted by the permissions of the socket file.
+type Agent struct {
+	*http.Server
+	appCtx      *actx.Context
+	socketPath  string
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	locked      chan struct{}
+	lockOnce    sync.Once
+}
+
+// NewAgent returns a new Agent instance that will listen on socketPath. It's
+// locked after not receiving requests for idleTimeout, unless it's 0.
+func NewAgent(appCtx *actx.Context, socketPath string, idleTimeout time.Duration) *Agent {
+	a := &Agent{
+		appCtx:      appCtx,
+		socketPath:  socketPath,
+		idleTimeout: idleTimeout,
+		locked:      make(chan struct{}),
+	}
+
+	r := chi.NewRouter()
+	r.Use(requestLogger(appCtx.Logger))
+	r.Use(a.trackActivity)
+	r.Use(middleware.Heartbeat("/ping"))
+	r.Use(middleware.Recoverer)
+	r.Mount("/api/v1", apiv1.Router(appCtx, nil))
+	r.Post("/agent/lock", func(w http.ResponseWriter, _ *http.Request) {
+		a.Lock()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	a.Server = &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      10 * time.Minute,
+		// Mark requests received by the agent, so that they're authenticated
+		// as the local user.
+		ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
+			return context.WithValue(ctx, types.ConnAgentKey, true)
+		},
+	}
+
+	return a
+}
+
+// ListenAndServe listens on the Unix socket, which is only accessible by the
+// current user, and serves requests until the agent is shut down. The socket
+// file is removed when the listener is closed.
+func (a *Agent) ListenAndServe() error {
+	ln, err := listenSocket(a.socketPath)
+	if err != nil {
+		return err
+	}
+	// Not strictly needed on Unix, where the socket is created with these
+	// permissions, but make sure of it.
+	if err = os.Chmod(a.socketPath, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed setting socket permissions: %w", err)
+	}
+
+	if a.idleTimeout > 0 {
+		a.idleTimer = time.AfterFunc(a.idleTimeout, func() {
+			a.appCtx.Logger.Info("agent is idle", "timeout", a.idleTimeout)
+			a.Lock()
+		})
+	}
+
+	a.appCtx.Logger.Info("started agent", "socket", a.socketPath)
+
+	return a.Serve(ln)
+}
+
+// Lock signals that the agent should stop serving requests.
+func (a *Agent) Lock() {
+	a.lockOnce.Do(func() { close(a.locked) })
+}
+
+// Locked returns a channel that is closed when the agent is locked, either
+// explicitly or after being idle.
+func (a *Agent) Locked() <-chan struct{} {
+	return a.locked
+}
+
+// trackActivity postpones the idle timeout whenever a request is received.
+func (a *Agent) trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.idleTimer != nil {
+			a.idleTimer.Reset(a.idleTimeout)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
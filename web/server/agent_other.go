@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "net"
+
+// listenSocket listens on the Unix socket at path.
+func listenSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// listenSocket listens on the Unix socket at path, which is created with
+// permissions that only allow access by the current user. The directory of the
+// socket must not be accessible by other users either, so that it's not
+// possible to connect to the socket before its permissions are set.
+func listenSocket(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return nil, fmt.Errorf("directory '%s' is accessible by other users (mode %#o); "+
+			"run 'chmod 700 %s' to fix it", dir, perm, dir)
+	}
+
+	// The umask is process-wide, but the agent doesn't create other files
+	// concurrently.
+	oldMask := syscall.Umask(0o177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+
+	return ln, err
+}
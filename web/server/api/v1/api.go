@@ -1,6 +1,10 @@
 package api
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -11,26 +15,98 @@ import (
 // Handler is the API endpoint handler.
 type Handler struct {
 	appCtx *actx.Context
+	seal   *Seal
 }
 
-// Router returns the API router.
-func Router(appCtx *actx.Context) chi.Router {
+// Router returns the API router. If seal is not nil, the server can be sealed,
+// and requests that need the encryption key are rejected while it is.
+func Router(appCtx *actx.Context, seal *Seal) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 	// Limit request sizes to 100MB
 	r.Use(middleware.RequestSize(100 << (10 * 2)))
 
-	h := Handler{appCtx}
+	h := Handler{appCtx: appCtx, seal: seal}
 	r.Route("/store", func(r chi.Router) {
 		r.Use(authnUser(appCtx))
+		r.Use(requireUnsealed(seal))
 		r.Get("/value/*", h.StoreGet)
 		r.Post("/value/*", h.StoreSet)
 		r.Get("/keys/*", h.StoreKeys)
 		r.Get("/keys", h.StoreKeys)
+		r.Get("/history/*", h.StoreHistory)
+		r.Post("/rollback/*", h.StoreRollback)
+		r.Post("/txn", h.StoreTxn)
 	})
 
-	r.Post("/join", h.RemoteJoin)
+	r.Route("/namespaces", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Use(requireUnsealed(seal))
+		r.Get("/", h.NamespaceList)
+		r.Post("/", h.NamespaceCreate)
+		r.Delete("/{name}", h.NamespaceDelete)
+		r.Post("/{name}/rename", h.NamespaceRename)
+		r.Post("/{name}/copy", h.NamespaceCopy)
+		r.Get("/{name}/stats", h.NamespaceStats)
+	})
+
+	r.Route("/users", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Get("/", h.UserList)
+		r.Post("/", h.UserCreate)
+		r.Put("/{name}", h.UserUpdate)
+		r.Delete("/{name}", h.UserDelete)
+	})
+
+	r.Route("/roles", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Get("/", h.RoleList)
+		r.Post("/", h.RoleCreate)
+		r.Put("/{name}", h.RoleUpdate)
+		r.Delete("/{name}", h.RoleDelete)
+	})
+
+	r.Route("/invites", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Use(requireUnsealed(seal))
+		r.Get("/", h.InviteList)
+		r.Post("/", h.InviteCreate)
+		r.Put("/{uuid}", h.InviteUpdate)
+		r.Delete("/{uuid}", h.InviteDelete)
+	})
+
+	r.Route("/remotes", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Get("/", h.RemoteList)
+		r.Delete("/{name}", h.RemoteDelete)
+	})
+
+	r.Route("/auth", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Get("/check", h.AuthCheck)
+	})
+
+	r.Route("/sys", func(r chi.Router) {
+		r.Use(authnUser(appCtx))
+		r.Get("/seal-status", h.SysSealStatus)
+		r.Post("/seal", h.SysSeal)
+		r.Post("/unseal", h.SysUnseal)
+	})
+
+	r.With(requireUnsealed(seal)).Post("/join", h.RemoteJoin)
 
 	return r
 }
+
+// objectParam returns the unescaped value of the URL path parameter with the
+// given key, e.g. a user name, which is described by desc in errors. Clients
+// must escape values that may contain slashes.
+func objectParam(r *http.Request, key, desc string) (string, error) {
+	val, err := url.PathUnescape(chi.URLParam(r, key))
+	if err != nil || val == "" {
+		return "", fmt.Errorf("invalid %s: '%s'", desc, chi.URLParam(r, key))
+	}
+
+	return val, nil
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
 	"github.com/go-chi/render"
 	actx "go.hackfix.me/disco/app/context"
@@ -18,12 +21,21 @@ import (
 // the resource needs to have been accessed with a valid client certificate,
 // which is validated in the Go runtime, before reaching Disco HTTP endpoints.
 //
+// Requests received by the local agent are authenticated as the local user.
+//
 // If this fails, a response with status 401 Unauthorized is returned. Otherwise
 // the request is allowed to continue, and authorization to access individual
 // resources is done later in each handler.
 func authnUser(appCtx *actx.Context) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Only the local user can connect to the agent socket.
+			if agent, _ := r.Context().Value(types.ConnAgentKey).(bool); agent {
+				ctx := context.WithValue(r.Context(), types.ConnTLSUserKey, appCtx.User)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
 				_ = render.Render(w, r, types.ErrUnauthorized("failed TLS authentication"))
 				return
@@ -54,14 +66,14 @@ func authzUser(
 	req *http.Request, action models.Action, resource models.Resource,
 	namespace, target string,
 ) error {
-	user, ok := req.Context().Value(types.ConnTLSUserKey).(*models.User)
-	if !ok {
-		return errors.New("user object not found in the request context")
+	user, err := requestUser(req)
+	if err != nil {
+		return err
 	}
 
 	target = fmt.Sprintf("%s:%s:%s", namespace, resource, target)
 
-	if ok, err := user.Can(string(action), target); err != nil {
+	if ok, err := user.Can(string(action), target, accessContext(req)); err != nil {
 		return err
 	} else if !ok {
 		return fmt.Errorf("user '%s' is not authorized to %s %s", user.Name, action, target)
@@ -69,3 +81,154 @@ func authzUser(
 
 	return nil
 }
+
+// authzUserAll checks whether the user is authorized to perform the given
+// action on all targets matched by the target pattern, of the given resource in
+// the given namespace. Unlike authzUser, it fails if any deny permission of the
+// user applies to one of these targets.
+func authzUserAll(
+	req *http.Request, action models.Action, resource models.Resource,
+	namespace, target string,
+) error {
+	user, err := requestUser(req)
+	if err != nil {
+		return err
+	}
+
+	target = fmt.Sprintf("%s:%s:%s", namespace, resource, target)
+
+	if ok, err := user.CanAll(string(action), target, accessContext(req)); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("user '%s' is not authorized to %s all of %s", user.Name, action, target)
+	}
+
+	return nil
+}
+
+// AuthCheck returns whether a user is allowed to perform an action on a
+// target, and explains the decision. The action, namespace and target, in
+// "<resource>:<pattern>" format, are passed as query parameters. By default the
+// authenticated user is checked, while checking another user requires read
+// access to it. In both cases, permission conditions are evaluated against this
+// request.
+func (h *Handler) AuthCheck(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	user, err := requestUser(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	action, err := models.ActionFromString(query.Get("action"))
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("namespace not provided")))
+		return
+	}
+	res, pattern, ok := strings.Cut(query.Get("target"), ":")
+	if !ok || pattern == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(
+			fmt.Errorf("invalid target '%s': must be in '<resource>:<pattern>' format", query.Get("target"))))
+		return
+	}
+	resource, err := models.ResourceFromString(res)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if name := query.Get("user"); name != "" && name != user.Name {
+		if err = authzUser(r, models.ActionRead, models.ResourceUser, "*", name); err != nil {
+			_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+			return
+		}
+		user = &models.User{Name: name}
+		if err = user.Load(h.appCtx.DB.NewContext(), h.appCtx.DB); err != nil {
+			renderModelError(w, r, err)
+			return
+		}
+	}
+
+	target := fmt.Sprintf("%s:%s:%s", namespace, resource, pattern)
+	dec, err := user.Check(string(action), target, accessContext(r))
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	decResp, err := decisionResponse(dec)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	_ = render.Render(w, r, &types.AuthCheckResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     decResp,
+	})
+}
+
+func decisionResponse(dec *models.Decision) (*types.AuthDecision, error) {
+	resp := &types.AuthDecision{Allowed: dec.Allowed, Role: dec.Role}
+	if dec.Permission != nil {
+		perm, err := dec.Permission.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		resp.Permission = string(perm)
+	}
+	for _, cand := range dec.Candidates {
+		perm, err := cand.Permission.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		resp.Candidates = append(resp.Candidates, &types.AuthCandidate{
+			Role: cand.Role, Permission: string(perm), Mismatch: cand.Mismatch,
+		})
+	}
+
+	return resp, nil
+}
+
+// accessContext returns the attributes of the request that permission
+// conditions are evaluated against. Requests received by the local agent over
+// its Unix socket have no IP address, so they're considered to come from the
+// IPv6 loopback address. Otherwise, the address of the TCP peer is used, since
+// the request RemoteAddr can be set from client headers. If the address can't
+// be determined, it's left unset, which fails conditions of allow permissions,
+// and meets conditions of deny permissions.
+func accessContext(req *http.Request) *models.AccessContext {
+	accCtx := &models.AccessContext{Time: time.Now()}
+	if agent, _ := req.Context().Value(types.ConnAgentKey).(bool); agent {
+		accCtx.Addr = netip.IPv6Loopback()
+		return accCtx
+	}
+
+	remoteAddr, ok := req.Context().Value(types.ConnPeerAddrKey).(string)
+	if !ok {
+		remoteAddr = req.RemoteAddr
+	}
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		accCtx.Addr = addrPort.Addr()
+	} else if addr, err := netip.ParseAddr(remoteAddr); err == nil {
+		accCtx.Addr = addr
+	}
+
+	return accCtx
+}
+
+// requestUser returns the authenticated user stored in the request context.
+func requestUser(req *http.Request) (*models.User, error) {
+	user, ok := req.Context().Value(types.ConnTLSUserKey).(*models.User)
+	if !ok {
+		return nil, errors.New("user object not found in the request context")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"go.hackfix.me/disco/db/models"
+	dbtypes "go.hackfix.me/disco/db/types"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// The target of invite permissions is the name of the invited user.
+
+// InviteList returns the invites the user is allowed to read.
+// Expired invites are only included if the all query parameter is true.
+func (h *Handler) InviteList(w http.ResponseWriter, r *http.Request) {
+	var all bool
+	if a := r.URL.Query().Get("all"); a != "" {
+		var err error
+		all, err = strconv.ParseBool(a)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid all value: '%s'", a)))
+			return
+		}
+	}
+
+	var filter *dbtypes.Filter
+	if !all {
+		filter = dbtypes.NewFilter("inv.expires > ?", []any{time.Now().UTC()})
+	}
+	invites, err := models.Invites(h.appCtx.DB.NewContext(), h.appCtx.DB, filter)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.InviteListResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     []*types.Invite{},
+	}
+	for _, inv := range invites {
+		if err = authzUser(r, models.ActionRead, models.ResourceInvite, "*", inv.User.Name); err != nil {
+			continue
+		}
+		invResp, err := inviteResponse(inv)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrInternal(err))
+			return
+		}
+		resp.Data = append(resp.Data, invResp)
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// InviteCreate creates a new invite for an existing user.
+func (h *Handler) InviteCreate(w http.ResponseWriter, r *http.Request) {
+	req := &types.InviteCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if req.User == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("user name not provided")))
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if err = authzUser(r, models.ActionWrite, models.ResourceInvite, "*", req.User); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	dbCtx := h.appCtx.DB.NewContext()
+	user := &models.User{Name: req.User}
+	if err = user.Load(dbCtx, h.appCtx.DB); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+	inv, err := models.NewInvite(user, ttl, h.appCtx.UUIDGen, h.appCtx.User.PrivateKey)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(
+			fmt.Errorf("failed creating invite for user '%s': %w", req.User, err)))
+		return
+	}
+	if err = inv.Save(dbCtx, h.appCtx.DB, false); err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	invResp, err := inviteResponse(inv)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	_ = render.Render(w, r, &types.InviteCreateResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     invResp,
+	})
+}
+
+// InviteUpdate extends the validity period of an invite.
+func (h *Handler) InviteUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &types.InviteUpdateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	inv, ok := h.authzInvite(w, r, models.ActionWrite)
+	if !ok {
+		return
+	}
+
+	inv.Expires = time.Now().UTC().Add(ttl)
+	if err = inv.Save(h.appCtx.DB.NewContext(), h.appCtx.DB, true); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.InviteUpdateResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// InviteDelete deletes an invite.
+func (h *Handler) InviteDelete(w http.ResponseWriter, r *http.Request) {
+	inv, ok := h.authzInvite(w, r, models.ActionDelete)
+	if !ok {
+		return
+	}
+
+	if err := inv.Delete(h.appCtx.DB.NewContext(), h.appCtx.DB); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.InviteDeleteResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// authzInvite loads the invite with the UUID, or UUID prefix, from the URL
+// path, and checks whether the user is allowed to perform the action on it. If
+// the invite doesn't exist or authorization fails, an error response is sent,
+// and ok is false.
+func (h *Handler) authzInvite(
+	w http.ResponseWriter, r *http.Request, action models.Action,
+) (inv *models.Invite, ok bool) {
+	uuid, err := objectParam(r, "uuid", "invite UUID")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return nil, false
+	}
+
+	inv = &models.Invite{UUID: uuid}
+	if err = inv.Load(h.appCtx.DB.NewContext(), h.appCtx.DB); err != nil {
+		renderModelError(w, r, err)
+		return nil, false
+	}
+
+	if err = authzUser(r, action, models.ResourceInvite, "*", inv.User.Name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return nil, false
+	}
+
+	return inv, true
+}
+
+func inviteResponse(inv *models.Invite) (*types.Invite, error) {
+	token, err := inv.TokenComposite()
+	if err != nil {
+		return nil, fmt.Errorf("failed generating composite invitation token: %w", err)
+	}
+
+	return &types.Invite{
+		UUID: inv.UUID, User: inv.User.Name, Token: token,
+		CreatedAt: inv.CreatedAt, Expires: inv.Expires,
+	}, nil
+}
+
+func parseTTL(ttl string) (time.Duration, error) {
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid TTL: '%s'", ttl)
+	}
+
+	return d, nil
+}
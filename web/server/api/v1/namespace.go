@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/db/store"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// NamespaceList returns the namespaces the user is allowed to read.
+func (h *Handler) NamespaceList(w http.ResponseWriter, r *http.Request) {
+	nss, err := h.appCtx.Store.Namespaces()
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.NamespaceListResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     []*types.Namespace{},
+	}
+	for _, ns := range nss {
+		if err = authzUser(r, models.ActionRead, models.ResourceNamespace, ns.Name, ns.Name); err != nil {
+			continue
+		}
+		resp.Data = append(resp.Data, &types.Namespace{
+			Name: ns.Name, CreatedAt: ns.CreatedAt, Description: ns.Description,
+		})
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// NamespaceCreate creates a new empty namespace.
+func (h *Handler) NamespaceCreate(w http.ResponseWriter, r *http.Request) {
+	req := &types.NamespaceCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if req.Name == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("namespace name not provided")))
+		return
+	}
+
+	if err := authzUser(r, models.ActionWrite, models.ResourceNamespace, req.Name, req.Name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	if err := h.appCtx.Store.CreateNamespace(req.Name, req.Description); err != nil {
+		renderNamespaceError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.NamespaceCreateResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// NamespaceDelete deletes a namespace. Namespaces that contain keys are only
+// deleted if the force query parameter is true.
+func (h *Handler) NamespaceDelete(w http.ResponseWriter, r *http.Request) {
+	name, err := namespaceParam(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	var force bool
+	if f := r.URL.Query().Get("force"); f != "" {
+		force, err = strconv.ParseBool(f)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid force value: '%s'", f)))
+			return
+		}
+	}
+
+	if err = authzUser(r, models.ActionDelete, models.ResourceNamespace, name, name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	if err = h.appCtx.Store.DeleteNamespace(name, force); err != nil {
+		renderNamespaceError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.NamespaceDeleteResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// NamespaceRename renames a namespace.
+func (h *Handler) NamespaceRename(w http.ResponseWriter, r *http.Request) {
+	name, newName, ok := h.namespaceRenameRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := authzUser(r, models.ActionDelete, models.ResourceNamespace, name, name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+	if err := authzUser(r, models.ActionWrite, models.ResourceNamespace, newName, newName); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	if err := h.appCtx.Store.RenameNamespace(name, newName); err != nil {
+		renderNamespaceError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.NamespaceRenameResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// NamespaceCopy copies all keys in a namespace to a new namespace.
+func (h *Handler) NamespaceCopy(w http.ResponseWriter, r *http.Request) {
+	name, newName, ok := h.namespaceRenameRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Check all keys, so that deny permissions for some of them aren't bypassed.
+	if err := authzUserAll(r, models.ActionRead, models.ResourceStore, name, "*"); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+	if err := authzUser(r, models.ActionWrite, models.ResourceNamespace, newName, newName); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+	if err := authzUserAll(r, models.ActionWrite, models.ResourceStore, newName, "*"); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	if err := h.appCtx.Store.CopyNamespace(name, newName); err != nil {
+		renderNamespaceError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.NamespaceRenameResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// NamespaceStats returns usage statistics of a namespace.
+func (h *Handler) NamespaceStats(w http.ResponseWriter, r *http.Request) {
+	name, err := namespaceParam(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if err = authzUser(r, models.ActionRead, models.ResourceNamespace, name, name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	stats, err := h.appCtx.Store.NamespaceStats(name)
+	if err != nil {
+		renderNamespaceError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.NamespaceStatsResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Keys:     stats.Keys,
+		Size:     stats.Size,
+	})
+}
+
+// authzImplicitNamespace checks whether the user is allowed to create the
+// namespace, if it doesn't exist and would be created implicitly by storing a
+// key in it. The '*' namespace refers to all existing namespaces, so it's
+// never created, and the 'default' namespace is always available.
+func (h *Handler) authzImplicitNamespace(r *http.Request, namespace string) error {
+	if namespace == "*" || namespace == "default" {
+		return nil
+	}
+
+	nss, err := h.appCtx.Store.Namespaces()
+	if err != nil {
+		return err
+	}
+	for _, ns := range nss {
+		if ns.Name == namespace {
+			return nil
+		}
+	}
+
+	return authzUser(r, models.ActionWrite, models.ResourceNamespace, namespace, namespace)
+}
+
+// namespaceRenameRequest returns the source and destination namespace names of
+// a rename or copy request. If the request is invalid, an error response is
+// sent, and ok is false.
+func (h *Handler) namespaceRenameRequest(w http.ResponseWriter, r *http.Request) (name, newName string, ok bool) {
+	name, err := namespaceParam(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return "", "", false
+	}
+
+	req := &types.NamespaceRenameRequest{}
+	if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return "", "", false
+	}
+	if req.Name == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("new namespace name not provided")))
+		return "", "", false
+	}
+
+	return name, req.Name, true
+}
+
+// namespaceParam returns the unescaped namespace name from the URL path.
+// Namespace names may contain slashes, so clients must escape them.
+func namespaceParam(r *http.Request) (string, error) {
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil || name == "" {
+		return "", fmt.Errorf("invalid namespace name: '%s'", chi.URLParam(r, "name"))
+	}
+
+	return name, nil
+}
+
+// renderNamespaceError sends an error response with a status code that
+// corresponds to the namespace operation error.
+func renderNamespaceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNamespaceNotFound):
+		_ = render.Render(w, r, types.ErrNotFound(err))
+	case errors.Is(err, store.ErrNamespaceExists), errors.Is(err, store.ErrNamespaceNotEmpty):
+		_ = render.Render(w, r, types.ErrConflict(err))
+	case errors.Is(err, store.ErrInvalidNamespace):
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+	default:
+		_ = render.Render(w, r, types.ErrInternal(err))
+	}
+}
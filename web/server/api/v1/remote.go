@@ -126,6 +126,52 @@ func (h *Handler) RemoteJoin(w http.ResponseWriter, r *http.Request) {
 	_ = render.Render(w, r, resp)
 }
 
+// RemoteList returns the remote nodes the user is allowed to read.
+func (h *Handler) RemoteList(w http.ResponseWriter, r *http.Request) {
+	remotes, err := models.Remotes(h.appCtx.DB.NewContext(), h.appCtx.DB, nil)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.RemoteListResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     []*types.Remote{},
+	}
+	for _, rem := range remotes {
+		if err = authzUser(r, models.ActionRead, models.ResourceRemote, "*", rem.Name); err != nil {
+			continue
+		}
+		resp.Data = append(resp.Data, &types.Remote{Name: rem.Name, Address: rem.Address})
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// RemoteDelete removes a remote node.
+func (h *Handler) RemoteDelete(w http.ResponseWriter, r *http.Request) {
+	name, err := objectParam(r, "name", "remote name")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if err = authzUser(r, models.ActionDelete, models.ResourceRemote, "*", name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	rem := &models.Remote{Name: name}
+	if err = rem.Delete(h.appCtx.DB.NewContext(), h.appCtx.DB); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.RemoteDeleteResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
 func decodeToken(token string) ([]byte, []byte, error) {
 	tokenDec, err := base58.Decode(token)
 	if err != nil {
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// RoleList returns the roles the user is allowed to read.
+func (h *Handler) RoleList(w http.ResponseWriter, r *http.Request) {
+	roles, err := models.Roles(h.appCtx.DB.NewContext(), h.appCtx.DB, nil)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.RoleListResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     []*types.Role{},
+	}
+	for _, role := range roles {
+		if err = authzUser(r, models.ActionRead, models.ResourceRole, "*", role.Name); err != nil {
+			continue
+		}
+		perms := make([]string, len(role.Permissions))
+		for i, perm := range role.Permissions {
+			permText, err := perm.MarshalText()
+			if err != nil {
+				_ = render.Render(w, r, types.ErrInternal(err))
+				return
+			}
+			perms[i] = string(permText)
+		}
+		resp.Data = append(resp.Data, &types.Role{Name: role.Name, Permissions: perms})
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// RoleCreate adds a new role.
+func (h *Handler) RoleCreate(w http.ResponseWriter, r *http.Request) {
+	req := &types.RoleCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if req.Name == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("role name not provided")))
+		return
+	}
+
+	h.saveRole(w, r, req.Name, req.Permissions, false)
+}
+
+// RoleUpdate replaces the permissions of an existing role.
+func (h *Handler) RoleUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &types.RoleCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	name, err := objectParam(r, "name", "role name")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	h.saveRole(w, r, name, req.Permissions, true)
+}
+
+func (h *Handler) saveRole(w http.ResponseWriter, r *http.Request, name string, permTexts []string, update bool) {
+	if err := authzUser(r, models.ActionWrite, models.ResourceRole, "*", name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	perms := make([]models.Permission, len(permTexts))
+	for i, permText := range permTexts {
+		if err := perms[i].UnmarshalText([]byte(permText)); err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(
+				fmt.Errorf("invalid permission '%s': %w", permText, err)))
+			return
+		}
+	}
+
+	if err := authzGrant(r, perms); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	role := &models.Role{Name: name, Permissions: perms}
+	if err := role.Save(h.appCtx.DB.NewContext(), h.appCtx.DB, update); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.RoleCreateResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// RoleDelete removes a role. Roles that are assigned to users are only deleted
+// if the force query parameter is true.
+func (h *Handler) RoleDelete(w http.ResponseWriter, r *http.Request) {
+	name, err := objectParam(r, "name", "role name")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	var force bool
+	if f := r.URL.Query().Get("force"); f != "" {
+		force, err = strconv.ParseBool(f)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid force value: '%s'", f)))
+			return
+		}
+	}
+
+	if err = authzUser(r, models.ActionDelete, models.ResourceRole, "*", name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	role := &models.Role{Name: name}
+	if err = role.Delete(h.appCtx.DB.NewContext(), h.appCtx.DB, force); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.RoleDeleteResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// authzGrant checks whether the user is allowed to grant the permissions, which
+// requires being allowed to perform every action they allow, in every
+// namespace, on every target, under conditions at least as strict as the
+// user's own. Wildcards are checked literally, so granting them requires having
+// the same or broader wildcard permissions. Deny permissions of the user that
+// may apply to the granted targets must be granted as well, while other deny
+// permissions only take access away, so they can always be granted.
+func authzGrant(r *http.Request, perms []models.Permission) error {
+	user, err := requestUser(r)
+	if err != nil {
+		return err
+	}
+
+	accCtx := accessContext(r)
+	for i, perm := range perms {
+		if perm.Deny {
+			continue
+		}
+		if err := user.CheckGrant(&perms[i], perms, accCtx); err != nil {
+			permText, _ := perm.MarshalText()
+			return fmt.Errorf("not allowed to grant permission '%s': %w", permText, err)
+		}
+	}
+
+	return nil
+}
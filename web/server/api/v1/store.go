@@ -1,14 +1,20 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 
 	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/db/store"
 	"go.hackfix.me/disco/web/server/types"
 )
 
@@ -24,18 +30,40 @@ func (h *Handler) StoreGet(w http.ResponseWriter, r *http.Request) {
 		req.Namespace = ns
 	}
 
+	if ver := r.URL.Query().Get("version"); ver != "" {
+		var err error
+		req.Version, err = parseVersion(ver)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(err))
+			return
+		}
+	}
+
 	if err := authzUser(r, models.ActionRead, models.ResourceStore, req.Namespace, req.Key); err != nil {
 		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
 		return
 	}
 
-	ok, val, err := h.appCtx.Store.Get(req.Namespace, req.Key)
+	meta, err := h.keyMetadata(req.Namespace, req.Key, req.Version)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	var (
+		ok  bool
+		val io.Reader
+	)
+	if req.Version > 0 {
+		ok, val, err = h.appCtx.Store.GetVersion(req.Namespace, req.Key, req.Version)
+	} else {
+		ok, val, err = h.appCtx.Store.Get(req.Namespace, req.Key)
+	}
 	if err != nil {
 		_ = render.Render(w, r, types.ErrInternal(err))
 		return
 	}
 
-	// TODO: Infer Content-Type from the value
 	w.Header().Del("Content-Type")
 
 	if !ok {
@@ -43,10 +71,19 @@ func (h *Handler) StoreGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = io.Copy(w, val)
+	// The metadata is read separately, and may be of a value written in the
+	// meantime, so the length is taken from the value that's sent.
+	data, err := io.ReadAll(val)
 	if err != nil {
 		_ = render.Render(w, r, types.ErrInternal(err))
+		return
 	}
+	defer clear(data)
+
+	setMetadataHeaders(w.Header(), meta)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+
+	_, _ = w.Write(data)
 }
 
 // StoreSet stores the provided value associated to the provided key.
@@ -61,17 +98,68 @@ func (h *Handler) StoreSet(w http.ResponseWriter, r *http.Request) {
 		req.Namespace = ns
 	}
 
-	if err := authzUser(r, models.ActionWrite, models.ResourceStore, req.Namespace, req.Key); err != nil {
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		var err error
+		req.TTL, err = time.ParseDuration(ttl)
+		if err != nil || req.TTL <= 0 {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid TTL: '%s'", ttl)))
+			return
+		}
+	}
+
+	if etag := r.Header.Get("If-Match"); etag != "" {
+		var err error
+		req.IfRevision, err = parseETag(etag)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(err))
+			return
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if inm != "*" {
+			_ = render.Render(w, r, types.ErrBadRequest(
+				fmt.Errorf("unsupported If-None-Match value: '%s'", inm)))
+			return
+		}
+		req.CreateOnly = true
+	}
+
+	if err := h.authzStore(r, models.ActionWrite, req.Namespace, req.Key); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+	if err := h.authzImplicitNamespace(r, req.Namespace); err != nil {
 		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
 		return
 	}
 
-	err := h.appCtx.Store.Set(req.Namespace, req.Key, r.Body)
+	user, err := requestUser(r)
 	if err != nil {
 		_ = render.Render(w, r, types.ErrInternal(err))
 		return
 	}
 
+	opts := []store.SetOption{
+		store.WithAuthor(user.Name), store.WithTTL(req.TTL), store.IfRevision(req.IfRevision),
+	}
+	if req.CreateOnly {
+		opts = append(opts, store.CreateOnly())
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		opts = append(opts, store.WithContentType(ct))
+	}
+
+	err = h.appCtx.Store.Set(req.Namespace, req.Key, r.Body, opts...)
+	if err != nil {
+		if errors.Is(err, store.ErrPreconditionFailed) {
+			_ = render.Render(w, r, types.ErrPreconditionFailed(err))
+			return
+		}
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
 	_ = render.Render(w, r, &types.StoreSetResponse{
 		Response: &types.Response{StatusCode: http.StatusOK},
 	})
@@ -83,13 +171,21 @@ func (h *Handler) StoreKeys(w http.ResponseWriter, r *http.Request) {
 	if ns := r.URL.Query().Get("namespace"); ns != "" {
 		req.Namespace = ns
 	}
+	if md := r.URL.Query().Get("metadata"); md != "" {
+		var err error
+		req.Metadata, err = strconv.ParseBool(md)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid metadata value: '%s'", md)))
+			return
+		}
+	}
 
 	if err := authzUser(r, models.ActionRead, models.ResourceStore, req.Namespace, req.Prefix); err != nil {
 		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
 		return
 	}
 
-	nsKeys, err := h.appCtx.Store.List(req.Namespace, req.Prefix)
+	nsMeta, err := h.appCtx.Store.ListMetadata(req.Namespace, req.Prefix)
 	if err != nil {
 		_ = render.Render(w, r, types.ErrInternal(err))
 		return
@@ -99,14 +195,284 @@ func (h *Handler) StoreKeys(w http.ResponseWriter, r *http.Request) {
 		Response: &types.Response{StatusCode: http.StatusOK},
 		Data:     make(map[string][]string),
 	}
+	if req.Metadata {
+		resp.Metadata = make(map[string][]*types.StoreKeyMetadata)
+	}
 
-	for ns, keys := range nsKeys {
+	for ns, keysMeta := range nsMeta {
 		var strKeys []string
-		for _, v := range keys {
-			strKeys = append(strKeys, string(v))
+		for _, meta := range keysMeta {
+			// Omit keys the user can't read, e.g. if they're in a namespace
+			// matched by '*', or a deny permission overlaps the prefix.
+			if authzUser(r, models.ActionRead, models.ResourceStore, ns, meta.Key) != nil {
+				continue
+			}
+			strKeys = append(strKeys, meta.Key)
+			if req.Metadata {
+				keyMeta := &types.StoreKeyMetadata{
+					Key:         meta.Key,
+					CreatedAt:   meta.CreatedAt,
+					UpdatedAt:   meta.UpdatedAt,
+					Author:      meta.Author,
+					Size:        meta.Size,
+					ContentType: meta.ContentType,
+					Revision:    meta.Revision,
+				}
+				if !meta.ExpiresAt.IsZero() {
+					keyMeta.ExpiresAt = &meta.ExpiresAt
+				}
+				resp.Metadata[ns] = append(resp.Metadata[ns], keyMeta)
+			}
 		}
-		resp.Data[ns] = strKeys
+		if len(strKeys) > 0 {
+			resp.Data[ns] = strKeys
+		}
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// StoreHistory returns the versions of the received key.
+func (h *Handler) StoreHistory(w http.ResponseWriter, r *http.Request) {
+	req := &types.StoreHistoryRequest{Key: chi.URLParam(r, "*"), Namespace: "default"}
+	if req.Key == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("key not provided")))
+		return
+	}
+
+	if ns := r.URL.Query().Get("namespace"); ns != "" {
+		req.Namespace = ns
+	}
+
+	if err := authzUser(r, models.ActionRead, models.ResourceStore, req.Namespace, req.Key); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	versions, err := h.appCtx.Store.History(req.Namespace, req.Key)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.StoreHistoryResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     make([]*types.StoreVersion, 0, len(versions)),
+	}
+	for _, v := range versions {
+		resp.Data = append(resp.Data, &types.StoreVersion{
+			Version: v.Number, CreatedAt: v.CreatedAt, Author: v.Author,
+			Size: v.Size, ContentType: v.ContentType,
+		})
 	}
 
 	_ = render.Render(w, r, resp)
 }
+
+// StoreRollback sets the value of the received key to the value of a previous
+// version.
+func (h *Handler) StoreRollback(w http.ResponseWriter, r *http.Request) {
+	req := &types.StoreRollbackRequest{Key: chi.URLParam(r, "*"), Namespace: "default"}
+	if req.Key == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("key not provided")))
+		return
+	}
+
+	if ns := r.URL.Query().Get("namespace"); ns != "" {
+		req.Namespace = ns
+	}
+
+	var err error
+	req.Version, err = parseVersion(r.URL.Query().Get("version"))
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if err = authzUser(r, models.ActionWrite, models.ResourceStore, req.Namespace, req.Key); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	user, err := requestUser(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	err = h.appCtx.Store.Rollback(req.Namespace, req.Key, req.Version, store.WithAuthor(user.Name))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNamespaceNotFound), errors.Is(err, store.ErrVersionNotFound):
+			_ = render.Render(w, r, types.ErrNotFound(err))
+		case errors.Is(err, store.ErrInvalidNamespace):
+			_ = render.Render(w, r, types.ErrBadRequest(err))
+		case errors.Is(err, store.ErrPreconditionFailed):
+			_ = render.Render(w, r, types.ErrPreconditionFailed(err))
+		default:
+			_ = render.Render(w, r, types.ErrInternal(err))
+		}
+		return
+	}
+
+	_ = render.Render(w, r, &types.StoreRollbackResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+func parseVersion(ver string) (int, error) {
+	v, err := strconv.Atoi(ver)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid version: '%s'", ver)
+	}
+
+	return v, nil
+}
+
+// StoreTxn applies multiple set and delete operations atomically.
+func (h *Handler) StoreTxn(w http.ResponseWriter, r *http.Request) {
+	req := &types.StoreTxnRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if len(req.Ops) == 0 {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("no operations provided")))
+		return
+	}
+
+	user, err := requestUser(r)
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	ops := make([]*store.TxnOp, len(req.Ops))
+	for i, reqOp := range req.Ops {
+		op, err := reqOp.StoreOp()
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("operation %d: %w", i+1, err)))
+			return
+		}
+
+		action := models.ActionWrite
+		if op.Type == store.TxnOpDelete {
+			action = models.ActionDelete
+		}
+		if err = authzUser(r, action, models.ResourceStore, op.Namespace, op.Key); err != nil {
+			_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+			return
+		}
+		if op.Type == store.TxnOpSet {
+			if err = h.authzImplicitNamespace(r, op.Namespace); err != nil {
+				_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+				return
+			}
+		}
+
+		op.Options = append(op.Options, store.WithAuthor(user.Name))
+		ops[i] = op
+	}
+
+	if err = h.appCtx.Store.Txn(ops); err != nil {
+		if errors.Is(err, store.ErrPreconditionFailed) {
+			_ = render.Render(w, r, types.ErrPreconditionFailed(err))
+			return
+		}
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	_ = render.Render(w, r, &types.StoreTxnResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// authzStore checks whether the user is allowed to perform the action on the
+// key in the namespace. The '*' namespace refers to all existing namespaces, so
+// the user must also be allowed to perform it in each of them. Otherwise, deny
+// permissions for specific namespaces would be bypassed.
+func (h *Handler) authzStore(r *http.Request, action models.Action, namespace, key string) error {
+	if err := authzUser(r, action, models.ResourceStore, namespace, key); err != nil || namespace != "*" {
+		return err
+	}
+
+	nss, err := h.appCtx.Store.Namespaces()
+	if err != nil {
+		return err
+	}
+	for _, ns := range nss {
+		if err = authzUser(r, action, models.ResourceStore, ns.Name, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyMetadata returns the metadata of the current or a specific version of a
+// key, or nil if it's not available.
+func (h *Handler) keyMetadata(namespace, key string, version int) (*store.Metadata, error) {
+	meta, err := h.appCtx.Store.Stat(namespace, key)
+	if err != nil || meta == nil || version == 0 {
+		return meta, err
+	}
+
+	versions, err := h.appCtx.Store.History(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range versions {
+		if v.Number == version {
+			vMeta := &store.Metadata{
+				Key:         key,
+				ExpiresAt:   meta.ExpiresAt,
+				CreatedAt:   meta.CreatedAt,
+				UpdatedAt:   v.CreatedAt,
+				Author:      v.Author,
+				Size:        v.Size,
+				ContentType: v.ContentType,
+			}
+			// Only the current value has a revision, since previous
+			// versions can't be the target of conditional writes.
+			if i == len(versions)-1 {
+				vMeta.Revision = meta.Revision
+			}
+			return vMeta, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// setMetadataHeaders sets the response headers from the key metadata. Keys
+// written before metadata tracking was added only get a generic content type.
+func setMetadataHeaders(hdr http.Header, meta *store.Metadata) {
+	if meta == nil || meta.ContentType == "" {
+		hdr.Set("Content-Type", "application/octet-stream")
+		return
+	}
+
+	hdr.Set("Content-Type", meta.ContentType)
+	hdr.Set("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+	hdr.Set("X-Disco-Created", meta.CreatedAt.UTC().Format(time.RFC3339))
+	if meta.Author != "" {
+		hdr.Set("X-Disco-Author", meta.Author)
+	}
+	if !meta.ExpiresAt.IsZero() {
+		hdr.Set("Expires", meta.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	if meta.Revision > 0 {
+		hdr.Set("ETag", fmt.Sprintf(`"%d"`, meta.Revision))
+	}
+}
+
+// parseETag returns the key revision from an entity tag.
+func parseETag(etag string) (int, error) {
+	rev, err := strconv.Atoi(strings.Trim(etag, `"`))
+	if err != nil || rev < 1 {
+		return 0, fmt.Errorf("invalid ETag: '%s'", etag)
+	}
+
+	return rev, nil
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/go-chi/render"
+
+	actx "go.hackfix.me/disco/app/context"
+	"go.hackfix.me/disco/crypto"
+	"go.hackfix.me/disco/db/models"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// Seal guards access to the encryption key of a server that can be sealed.
+// While sealed, the key is dropped from memory, and requests that need it are
+// rejected until the server is unsealed with the key or enough of its shares.
+type Seal struct {
+	appCtx *actx.Context
+	// Held for reading while serving requests that use the encryption key, so
+	// that it can't be dropped in the middle of one.
+	mx     sync.RWMutex
+	sealed bool
+	// Key shares received so far while sealed.
+	shares []*crypto.KeyShare
+}
+
+// NewSeal returns a new Seal. If sealed is true, the encryption key is dropped.
+func NewSeal(appCtx *actx.Context, sealed bool) *Seal {
+	s := &Seal{appCtx: appCtx}
+	if sealed {
+		s.seal()
+	}
+	return s
+}
+
+// Sealed returns true if the server is sealed.
+func (s *Seal) Sealed() bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.sealed
+}
+
+// seal drops and wipes the encryption key. It must be called with the lock
+// held.
+func (s *Seal) seal() {
+	s.appCtx.Store.SetEncryptionKey(nil)
+	if s.appCtx.User != nil {
+		crypto.WipeKey(s.appCtx.User.PrivateKey)
+		s.appCtx.User.PrivateKey = nil
+	}
+	s.sealed = true
+	s.shares = nil
+}
+
+// unseal verifies and sets the encryption key. It must be called with the lock
+// held.
+func (s *Seal) unseal(encKey *[32]byte) error {
+	if err := s.appCtx.VerifyEncryptionKey(encKey); err != nil {
+		return err
+	}
+	encKey = crypto.LockKey(encKey)
+	s.appCtx.User.PrivateKey = encKey
+	s.appCtx.Store.SetEncryptionKey(encKey)
+	s.sealed = false
+	s.shares = nil
+
+	return nil
+}
+
+// status returns the seal status. It must be called with the lock held, unless
+// s is nil, in which case the server can't be sealed.
+func (s *Seal) status() *types.SysSealStatusResponse {
+	resp := &types.SysSealStatusResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	}
+	if s == nil {
+		return resp
+	}
+	resp.Sealed = s.sealed
+	if len(s.shares) > 0 {
+		resp.Threshold = s.shares[0].Threshold
+		resp.Progress = len(s.shares)
+	}
+	return resp
+}
+
+// requireUnsealed rejects requests with 503 Service Unavailable while the
+// server is sealed.
+func requireUnsealed(s *Seal) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s.mx.RLock()
+			defer s.mx.RUnlock()
+			if s.sealed {
+				_ = render.Render(w, r, types.ErrServiceUnavailable(errors.New("the server is sealed")))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SysSealStatus returns whether the server is sealed.
+func (h *Handler) SysSealStatus(w http.ResponseWriter, r *http.Request) {
+	if h.seal == nil {
+		_ = render.Render(w, r, h.seal.status())
+		return
+	}
+
+	h.seal.mx.RLock()
+	defer h.seal.mx.RUnlock()
+	_ = render.Render(w, r, h.seal.status())
+}
+
+// SysSeal drops the encryption key from memory, after which store requests are
+// rejected until the server is unsealed.
+func (h *Handler) SysSeal(w http.ResponseWriter, r *http.Request) {
+	if err := authzUser(r, models.ActionWrite, models.ResourceSys, "*", "seal"); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+	if h.seal == nil {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("the server can't be sealed")))
+		return
+	}
+
+	// This waits for requests that are using the key to finish.
+	h.seal.mx.Lock()
+	defer h.seal.mx.Unlock()
+	if !h.seal.sealed {
+		h.seal.seal()
+		h.appCtx.Logger.Info("sealed server", "user", requestUserName(r))
+	}
+
+	_ = render.Render(w, r, h.seal.status())
+}
+
+// SysUnseal unseals the server with the encryption key, or with its shares.
+// Shares can be sent over several requests, possibly by different users, and
+// are kept in memory until the threshold is reached.
+func (h *Handler) SysUnseal(w http.ResponseWriter, r *http.Request) {
+	if err := authzUser(r, models.ActionWrite, models.ResourceSys, "*", "seal"); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	req := &types.SysUnsealRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if (req.Key == "") == (len(req.Shares) == 0) {
+		_ = render.Render(w, r, types.ErrBadRequest(
+			errors.New("either the encryption key or key shares must be provided")))
+		return
+	}
+
+	if h.seal == nil {
+		_ = render.Render(w, r, h.seal.status())
+		return
+	}
+
+	h.seal.mx.Lock()
+	defer h.seal.mx.Unlock()
+	if !h.seal.sealed {
+		_ = render.Render(w, r, h.seal.status())
+		return
+	}
+
+	var encKey *[32]byte
+	if req.Key != "" {
+		var err error
+		encKey, err = crypto.DecodeKey(req.Key)
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(errors.New("invalid encryption key")))
+			return
+		}
+	} else {
+		for _, share := range req.Shares {
+			ks, err := crypto.ParseKeyShare(share)
+			if err != nil {
+				_ = render.Render(w, r, types.ErrBadRequest(err))
+				return
+			}
+			if len(h.seal.shares) > 0 && ks.SetID != h.seal.shares[0].SetID {
+				_ = render.Render(w, r, types.ErrBadRequest(
+					errors.New("the key share belongs to a different set than the other shares")))
+				return
+			}
+			if !slices.ContainsFunc(h.seal.shares, ks.Equal) {
+				h.seal.shares = append(h.seal.shares, ks)
+			}
+		}
+
+		if len(h.seal.shares) < h.seal.shares[0].Threshold {
+			_ = render.Render(w, r, h.seal.status())
+			return
+		}
+
+		var err error
+		encKey, err = crypto.CombineKeyShares(h.seal.shares)
+		// The shares are consumed regardless of the outcome, since it's not
+		// possible to tell which one is invalid.
+		h.seal.shares = nil
+		if err != nil {
+			_ = render.Render(w, r, types.ErrBadRequest(err))
+			return
+		}
+	}
+
+	if err := h.seal.unseal(encKey); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(
+			errors.New("the encryption key is invalid")))
+		return
+	}
+	h.appCtx.Logger.Info("unsealed server", "user", requestUserName(r))
+
+	_ = render.Render(w, r, h.seal.status())
+}
+
+// requestUserName returns the name of the authenticated user, for logging.
+func requestUserName(r *http.Request) string {
+	if user, err := requestUser(r); err == nil {
+		return user.Name
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"go.hackfix.me/disco/db/models"
+	dbtypes "go.hackfix.me/disco/db/types"
+	"go.hackfix.me/disco/web/server/types"
+)
+
+// UserList returns the remote users the user is allowed to read.
+func (h *Handler) UserList(w http.ResponseWriter, r *http.Request) {
+	users, err := models.Users(h.appCtx.DB.NewContext(), h.appCtx.DB,
+		// Local users are hidden for now.
+		dbtypes.NewFilter("u.type != ?", []any{models.UserTypeLocal}))
+	if err != nil {
+		_ = render.Render(w, r, types.ErrInternal(err))
+		return
+	}
+
+	resp := &types.UserListResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+		Data:     []*types.User{},
+	}
+	for _, user := range users {
+		if err = authzUser(r, models.ActionRead, models.ResourceUser, "*", user.Name); err != nil {
+			continue
+		}
+		roles := make([]string, len(user.Roles))
+		for i, role := range user.Roles {
+			roles[i] = role.Name
+		}
+		resp.Data = append(resp.Data, &types.User{Name: user.Name, Roles: roles})
+	}
+
+	_ = render.Render(w, r, resp)
+}
+
+// UserCreate adds a new remote user.
+func (h *Handler) UserCreate(w http.ResponseWriter, r *http.Request) {
+	req := &types.UserCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	if req.Name == "" {
+		_ = render.Render(w, r, types.ErrBadRequest(errors.New("user name not provided")))
+		return
+	}
+
+	h.saveUser(w, r, req.Name, req.Roles, false)
+}
+
+// UserUpdate replaces the roles of an existing remote user.
+func (h *Handler) UserUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &types.UserCreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	name, err := objectParam(r, "name", "user name")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	h.saveUser(w, r, name, req.Roles, true)
+}
+
+// saveUser creates or updates the remote user with the given roles. Assigning
+// a role requires write access to it, and being allowed to grant its
+// permissions, so that users can't grant permissions they don't have.
+func (h *Handler) saveUser(w http.ResponseWriter, r *http.Request, name string, roleNames []string, update bool) {
+	if err := authzUser(r, models.ActionWrite, models.ResourceUser, "*", name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	dbCtx := h.appCtx.DB.NewContext()
+	if update {
+		// Local users are hidden for now.
+		existing := &models.User{Name: name}
+		err := existing.Load(dbCtx, h.appCtx.DB)
+		if err == nil && existing.Type == models.UserTypeLocal {
+			err = dbtypes.ErrNoResult{Msg: fmt.Sprintf("user with name '%s' doesn't exist", name)}
+		}
+		if err != nil {
+			renderModelError(w, r, err)
+			return
+		}
+	}
+
+	roles := make([]*models.Role, 0, len(roleNames))
+	perms := []models.Permission{}
+	for _, roleName := range roleNames {
+		if err := authzUser(r, models.ActionWrite, models.ResourceRole, "*", roleName); err != nil {
+			_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+			return
+		}
+		role := &models.Role{Name: roleName}
+		if err := role.Load(dbCtx, h.appCtx.DB); err != nil {
+			renderModelError(w, r, err)
+			return
+		}
+		roles = append(roles, role)
+		perms = append(perms, role.Permissions...)
+	}
+	// The deny permissions of all roles apply to the user, so they're checked
+	// together.
+	if err := authzGrant(r, perms); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	user := &models.User{Name: name,
+		// Only remote users can be managed for now.
+		Type: models.UserTypeRemote, Roles: roles}
+	if err := user.Save(dbCtx, h.appCtx.DB, update); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.UserCreateResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// UserDelete removes a remote user.
+func (h *Handler) UserDelete(w http.ResponseWriter, r *http.Request) {
+	name, err := objectParam(r, "name", "user name")
+	if err != nil {
+		_ = render.Render(w, r, types.ErrBadRequest(err))
+		return
+	}
+
+	if err = authzUser(r, models.ActionDelete, models.ResourceUser, "*", name); err != nil {
+		_ = render.Render(w, r, types.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	user := &models.User{Name: name}
+	if err = user.Delete(h.appCtx.DB.NewContext(), h.appCtx.DB); err != nil {
+		renderModelError(w, r, err)
+		return
+	}
+
+	_ = render.Render(w, r, &types.UserDeleteResponse{
+		Response: &types.Response{StatusCode: http.StatusOK},
+	})
+}
+
+// renderModelError sends an error response with a status code that corresponds
+// to the error returned by a database model operation.
+func renderModelError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		errNoResult dbtypes.ErrNoResult
+		errRef      *dbtypes.ErrReference
+	)
+	switch {
+	case errors.As(err, &errNoResult):
+		_ = render.Render(w, r, types.ErrNotFound(err))
+	case errors.As(err, &errRef):
+		_ = render.Render(w, r, types.ErrConflict(fmt.Errorf("%s: %w", errRef.Msg, errRef.Cause)))
+	default:
+		_ = render.Render(w, r, types.ErrInternal(err))
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"net"
@@ -13,6 +14,7 @@ import (
 	actx "go.hackfix.me/disco/app/context"
 	"go.hackfix.me/disco/crypto"
 	apiv1 "go.hackfix.me/disco/web/server/api/v1"
+	"go.hackfix.me/disco/web/server/types"
 )
 
 // Server is a wrapper around http.Server with some custom behavior.
@@ -20,11 +22,14 @@ type Server struct {
 	*http.Server
 	appCtx    *actx.Context
 	tlsConfig *tls.Config
+	ln        net.Listener
 }
 
 // New returns a new web Server instance. It creates a self-signed certificate
-// for TLS connections over which store data will be transferred.
-func New(appCtx *actx.Context, addr string) (*Server, error) {
+// for TLS connections over which store data will be transferred. If sealed is
+// true, the server is started without the encryption key, and must be unsealed
+// via the API before store data can be accessed.
+func New(appCtx *actx.Context, addr string, sealed bool) (*Server, error) {
 	tlsCfg := crypto.DefaultTLSConfig()
 	cert, certPEM, _, err := appCtx.ServerTLSInfo()
 	if err != nil {
@@ -37,13 +42,22 @@ func New(appCtx *actx.Context, addr string) (*Server, error) {
 	caCertPool.AppendCertsFromPEM(certPEM)
 	tlsCfg.ClientCAs = caCertPool
 
+	// The TLS certificate is loaded before sealing, which drops the key.
+	seal := apiv1.NewSeal(appCtx, sealed)
+
 	srv := &Server{
 		Server: &http.Server{
-			Handler:           setupRouter(appCtx),
+			Handler:           setupRouter(appCtx, seal),
 			Addr:              addr,
 			ReadHeaderTimeout: 10 * time.Second,
 			ReadTimeout:       30 * time.Second,
 			WriteTimeout:      10 * time.Minute,
+			// Store the address of the peer for evaluating permission
+			// conditions, since RealIP replaces the request RemoteAddr with the
+			// value of headers set by the client.
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return context.WithValue(ctx, types.ConnPeerAddrKey, c.RemoteAddr().String())
+			},
 		},
 		appCtx:    appCtx,
 		tlsConfig: tlsCfg,
@@ -52,27 +66,40 @@ func New(appCtx *actx.Context, addr string) (*Server, error) {
 	return srv, nil
 }
 
-// ListenAndServe is a replacement of http.ListenAndServe to ensure we set the
-// correct server address to be used in URLs, templates, etc.
+// Listen starts listening on the server address, without serving requests,
+// and sets the correct server address to be used in URLs, templates, etc.
 // This is needed when starting the server with address ':0'.
-func (s *Server) ListenAndServe() error {
+func (s *Server) Listen() error {
 	ln, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return err
 	}
 
+	s.ln = ln
 	s.Addr = ln.Addr().String()
 	s.appCtx.Logger.Info("started web server", "address", s.Addr)
 
+	return nil
+}
+
+// ListenAndServe is a replacement of http.ListenAndServe that serves requests
+// on the listener created by Listen, or creates it if Listen wasn't called.
+func (s *Server) ListenAndServe() error {
+	if s.ln == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
 	hl := &HybridListener{
-		Listener:  ln,
+		Listener:  s.ln,
 		tlsConfig: s.tlsConfig,
 		logger:    s.appCtx.Logger,
 	}
 	return s.Serve(hl)
 }
 
-func setupRouter(appCtx *actx.Context) chi.Router {
+func setupRouter(appCtx *actx.Context, seal *apiv1.Seal) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RealIP)
@@ -80,7 +107,7 @@ func setupRouter(appCtx *actx.Context) chi.Router {
 	r.Use(middleware.Heartbeat("/ping"))
 	r.Use(middleware.Recoverer)
 
-	r.Mount("/api/v1", apiv1.Router(appCtx))
+	r.Mount("/api/v1", apiv1.Router(appCtx, seal))
 
 	return r
 }
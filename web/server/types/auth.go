@@ -0,0 +1,26 @@
+package types
+
+// AuthDecision is the result of an authorization check. Permissions are in the
+// format "[!]<actions>:<namespaces>:<resource>:<target>[;<conditions>]".
+type AuthDecision struct {
+	Allowed bool `json:"allowed"`
+	// The role and permission that allowed or denied the action, if any.
+	Role       string `json:"role,omitempty"`
+	Permission string `json:"permission,omitempty"`
+	// The permissions closest to allowing the action, if no permission
+	// allowed or denied it.
+	Candidates []*AuthCandidate `json:"candidates,omitempty"`
+}
+
+// AuthCandidate is a permission that didn't allow the action of an
+// authorization check, along with the parts of it that didn't match.
+type AuthCandidate struct {
+	Role       string   `json:"role"`
+	Permission string   `json:"permission"`
+	Mismatch   []string `json:"mismatch"`
+}
+
+type AuthCheckResponse struct {
+	*Response
+	Data *AuthDecision `json:"decision"`
+}
@@ -4,4 +4,11 @@ const (
 	// ConnTLSUserKey is the key used to reference the Disco user extracted from
 	// the client TLS certificate and stored in the HTTP request context.
 	ConnTLSUserKey = "connTLSUser"
+	// ConnAgentKey is the key used to mark connections accepted by the local
+	// agent in the HTTP request context.
+	ConnAgentKey = "connAgent"
+	// ConnPeerAddrKey is the key used to store the address of the TCP peer in
+	// the HTTP request context. Unlike the request RemoteAddr, it can't be
+	// overridden by proxy headers.
+	ConnPeerAddrKey = "connPeerAddr"
 )
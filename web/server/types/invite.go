@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+// Invite is an invitation for a remote user to join the Disco node.
+type Invite struct {
+	UUID      string    `json:"uuid"`
+	User      string    `json:"user"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	Expires   time.Time `json:"expires"`
+}
+
+type InviteListResponse struct {
+	*Response
+	Data []*Invite `json:"invites"`
+}
+
+type InviteCreateRequest struct {
+	User string `json:"user"`
+	// TTL is the duration the invite is valid for, in a format accepted by
+	// time.ParseDuration.
+	TTL string `json:"ttl"`
+}
+
+type InviteCreateResponse struct {
+	*Response
+	Data *Invite `json:"invite"`
+}
+
+// InviteUpdateRequest is the request to extend the validity period of an
+// invite.
+type InviteUpdateRequest struct {
+	TTL string `json:"ttl"`
+}
+
+type InviteUpdateResponse struct {
+	*Response
+}
+
+type InviteDeleteResponse struct {
+	*Response
+}
@@ -0,0 +1,44 @@
+package types
+
+import "time"
+
+// Namespace is a group of keys in the store.
+type Namespace struct {
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description,omitempty"`
+}
+
+type NamespaceListResponse struct {
+	*Response
+	Data []*Namespace `json:"namespaces"`
+}
+
+type NamespaceCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type NamespaceCreateResponse struct {
+	*Response
+}
+
+type NamespaceDeleteResponse struct {
+	*Response
+}
+
+// NamespaceRenameRequest is the request to rename a namespace, or to copy it
+// to a new namespace.
+type NamespaceRenameRequest struct {
+	Name string `json:"name"`
+}
+
+type NamespaceRenameResponse struct {
+	*Response
+}
+
+type NamespaceStatsResponse struct {
+	*Response
+	Keys int   `json:"keys"`
+	Size int64 `json:"size"`
+}
@@ -12,3 +12,18 @@ type RemoteJoinResponsePayload struct {
 	TLSClientCert []byte `json:"tls_client_cert"`
 	TLSClientKey  []byte `json:"tls_client_key"`
 }
+
+// Remote is a remote Disco node that a node connects to.
+type Remote struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type RemoteListResponse struct {
+	*Response
+	Data []*Remote `json:"remotes"`
+}
+
+type RemoteDeleteResponse struct {
+	*Response
+}
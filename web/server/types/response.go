@@ -36,6 +36,13 @@ func ErrInternal(err error) render.Renderer {
 	}
 }
 
+func ErrConflict(err error) render.Renderer {
+	return &Response{
+		StatusCode: http.StatusConflict,
+		Error:      err.Error(),
+	}
+}
+
 func ErrNotFound(err error) render.Renderer {
 	return &Response{
 		StatusCode: http.StatusNotFound,
@@ -43,6 +50,20 @@ func ErrNotFound(err error) render.Renderer {
 	}
 }
 
+func ErrPreconditionFailed(err error) render.Renderer {
+	return &Response{
+		StatusCode: http.StatusPreconditionFailed,
+		Error:      err.Error(),
+	}
+}
+
+func ErrServiceUnavailable(err error) render.Renderer {
+	return &Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Error:      err.Error(),
+	}
+}
+
 func ErrUnauthorized(msg string) render.Renderer {
 	return &Response{
 		StatusCode: http.StatusUnauthorized,
@@ -0,0 +1,28 @@
+package types
+
+// Role is a named set of permissions. Permissions are in the format
+// "<actions>:<namespaces>:<resource>:<target>".
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleListResponse struct {
+	*Response
+	Data []*Role `json:"roles"`
+}
+
+// RoleCreateRequest is the request to add a new role, or to replace the
+// permissions of an existing role.
+type RoleCreateRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleCreateResponse struct {
+	*Response
+}
+
+type RoleDeleteResponse struct {
+	*Response
+}
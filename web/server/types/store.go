@@ -1,14 +1,27 @@
 package types
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.hackfix.me/disco/db/store"
+)
+
 type StoreGetRequest struct {
 	Key       string
 	Namespace string
+	Version   int
 }
 
 type StoreSetRequest struct {
-	Key       string
-	Value     []byte
-	Namespace string
+	Key        string
+	Value      []byte
+	Namespace  string
+	TTL        time.Duration
+	IfRevision int
+	CreateOnly bool
 }
 
 type StoreSetResponse struct {
@@ -18,9 +31,121 @@ type StoreSetResponse struct {
 type StoreKeysRequest struct {
 	Namespace string
 	Prefix    string
+	Metadata  bool
 }
 
 type StoreKeysResponse struct {
 	*Response
-	Data map[string][]string `json:"keys"`
+	Data     map[string][]string            `json:"keys"`
+	Metadata map[string][]*StoreKeyMetadata `json:"metadata,omitempty"`
+}
+
+// StoreKeyMetadata is the metadata of a key in the store.
+type StoreKeyMetadata struct {
+	Key         string     `json:"key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Author      string     `json:"author,omitempty"`
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Revision    int        `json:"revision"`
+}
+
+type StoreHistoryRequest struct {
+	Key       string
+	Namespace string
+}
+
+type StoreHistoryResponse struct {
+	*Response
+	Data []*StoreVersion `json:"versions"`
+}
+
+// StoreVersion is a version of a key in the store.
+type StoreVersion struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Author      string    `json:"author,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+type StoreRollbackRequest struct {
+	Key       string
+	Namespace string
+	Version   int
+}
+
+type StoreRollbackResponse struct {
+	*Response
+}
+
+type StoreTxnRequest struct {
+	Ops []*StoreTxnOp `json:"ops"`
+}
+
+// StoreTxnOp is an operation applied atomically along with others in a store
+// transaction.
+type StoreTxnOp struct {
+	Op          string `json:"op"`
+	Namespace   string `json:"namespace,omitempty"`
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	TTL         string `json:"ttl,omitempty"`
+	IfRevision  int    `json:"if_revision,omitempty"`
+	CreateOnly  bool   `json:"create_only,omitempty"`
+}
+
+// StoreOp validates the operation and converts it to a store transaction
+// operation.
+func (op *StoreTxnOp) StoreOp() (*store.TxnOp, error) {
+	if op.Key == "" {
+		return nil, errors.New("key not provided")
+	}
+
+	sop := &store.TxnOp{
+		Type:      store.TxnOpType(op.Op),
+		Namespace: op.Namespace,
+		Key:       op.Key,
+		Options:   []store.SetOption{store.IfRevision(op.IfRevision)},
+	}
+	if sop.Namespace == "" {
+		sop.Namespace = "default"
+	}
+
+	switch sop.Type {
+	case store.TxnOpSet:
+		sop.Value = strings.NewReader(op.Value)
+		if op.ContentType != "" {
+			sop.Options = append(sop.Options, store.WithContentType(op.ContentType))
+		}
+		if op.TTL != "" {
+			ttl, err := time.ParseDuration(op.TTL)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("invalid TTL: '%s'", op.TTL)
+			}
+			sop.Options = append(sop.Options, store.WithTTL(ttl))
+		}
+		if op.CreateOnly {
+			sop.Options = append(sop.Options, store.CreateOnly())
+		}
+	case store.TxnOpDelete:
+		if op.Value != "" || op.ContentType != "" || op.TTL != "" || op.CreateOnly {
+			return nil, fmt.Errorf("delete of key '%s' only supports the if_revision option", op.Key)
+		}
+	default:
+		return nil, fmt.Errorf("invalid operation: '%s'", op.Op)
+	}
+
+	if op.IfRevision < 0 {
+		return nil, fmt.Errorf("invalid revision: %d", op.IfRevision)
+	}
+
+	return sop, nil
+}
+
+type StoreTxnResponse struct {
+	*Response
 }
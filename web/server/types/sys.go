@@ -0,0 +1,18 @@
+package types
+
+// SysUnsealRequest is the request to unseal the server, either with the
+// encryption key, or with one or more of its shares.
+type SysUnsealRequest struct {
+	Key    string   `json:"key,omitempty"`
+	Shares []string `json:"shares,omitempty"`
+}
+
+// SysSealStatusResponse is the seal status of the server. If the server is
+// sealed, Threshold and Progress are the number of key shares required to
+// unseal it, and the number of shares received so far, if any.
+type SysSealStatusResponse struct {
+	*Response
+	Sealed    bool `json:"sealed"`
+	Threshold int  `json:"threshold,omitempty"`
+	Progress  int  `json:"progress,omitempty"`
+}
@@ -0,0 +1,27 @@
+package types
+
+// User is a remote user of the Disco node.
+type User struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+type UserListResponse struct {
+	*Response
+	Data []*User `json:"users"`
+}
+
+// UserCreateRequest is the request to add a new user, or to update the roles
+// of an existing user.
+type UserCreateRequest struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+type UserCreateResponse struct {
+	*Response
+}
+
+type UserDeleteResponse struct {
+	*Response
+}